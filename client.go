@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
@@ -42,58 +45,153 @@ type Client struct {
 	Client *govmomi.Client
 	Finder *find.Finder
 
-	datacenter *object.Datacenter
-	datastore  *object.Datastore
-	timeout    time.Duration
+	datacenter  *object.Datacenter
+	datastore   *object.Datastore
+	sessionFile string
+	timeout     time.Duration
+
+	// DryRun stops the client from submitting requests which would change
+	// vSphere; they are kept for DryRunRequests instead
+	DryRun      bool
+	dryRunState dryRunState
+
+	Verbose bool
+}
+
+// taskCancelTimeout bounds the request to cancel an abandoned vSphere task
+const taskCancelTimeout = 10 * time.Second
+
+// ClientOptions describes how to connect and log in to a vSphere instance
+type ClientOptions struct {
+	VSphere    string
+	Username   string
+	Password   string
+	Datacenter string
+	Datastore  string
+
+	// Timeout is the number of seconds allowed for each operation; see
+	// NewClientContext
+	Timeout int
+
+	// PasswordFunc is called to get the password when Password is empty and a
+	// new session must be created
+	PasswordFunc func() (string, error)
+
+	// SessionFile is the path to a file which keeps the session cookie, so that
+	// the session can be reused by later clients instead of logging in again
+	SessionFile string
+
+	// CAFile is the path to a PEM bundle of the certificate authorities which
+	// are trusted to sign vSphere's certificate, instead of the system's
+	CAFile string
+
+	// Thumbprint is the SHA-1 or SHA-256 thumbprint that vSphere's certificate
+	// must have.  When it is set, the certificate authority is not checked.
+	Thumbprint string
+
+	// KnownHostsFile keeps the thumbprints of certificates which have been
+	// trusted on first use
+	KnownHostsFile string
+
+	// TrustOnFirstUse records the thumbprint of a certificate which cannot
+	// otherwise be verified in the known hosts file, the first time that the
+	// host is seen.  Later connections must present the same certificate.
+	TrustOnFirstUse bool
+
+	// Insecure disables all verification of vSphere's certificate
+	Insecure bool
+
+	// DryRun keeps requests which would change vSphere instead of submitting
+	// them; see Client.DryRunRequests
+	DryRun bool
 
 	Verbose bool
 }
 
 // NewClient creates a connection to a vSphere instance
 func NewClient(url, username, password, datacenter, datastore string, timeout int) (*Client, error) {
-	connectionURL, err := buildConnectionString(url, username, password)
-	if err != nil {
-		return nil, err
+	return NewClientContext(context.Background(), url, username, password, datacenter, datastore, timeout)
+}
+
+// NewClientContext is like NewClient, but uses the provided context.  The
+// timeout is applied to each operation on top of any deadline on the
+// context; a timeout of 0 leaves operations bounded only by their context.
+func NewClientContext(ctx context.Context, url, username, password, datacenter, datastore string, timeout int) (*Client, error) {
+	opts := &ClientOptions{
+		VSphere:    url,
+		Username:   username,
+		Password:   password,
+		Datacenter: datacenter,
+		Datastore:  datastore,
+		Timeout:    timeout,
 	}
 
+	return NewClientWithOptions(ctx, opts)
+}
+
+// NewClientWithOptions creates a connection to a vSphere instance, reusing the
+// session in the options' session file if it is still valid
+func NewClientWithOptions(ctx context.Context, opts *ClientOptions) (*Client, error) {
 	c := &Client{
-		timeout: time.Duration(timeout) * time.Second,
+		sessionFile: opts.SessionFile,
+		timeout:     time.Duration(opts.Timeout) * time.Second,
+		DryRun:      opts.DryRun,
+		Verbose:     opts.Verbose,
 	}
-	err = func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		// Connect and log in to ESX or vCenter
-		c.Client, err = govmomi.NewClient(ctx, connectionURL, true)
+		verifier, err := newTLSVerifier(opts, opts.VSphere)
+		if err != nil {
+			return err
+		}
+
+		// Connect and log in to ESX or vCenter, unless the previous session is
+		// still good
+		c.Client, err = c.resumeSession(ctx, opts.VSphere, verifier)
 		if err := c.checkErr(ctx, err); err != nil {
-			return errors.Wrapf(err, "Failed to connect to vSphere at '%s' with user '%s'", url, username)
+			return errors.Wrapf(err, "Failed to resume session with vSphere at '%s'", opts.VSphere)
+		}
+
+		if c.Client == nil {
+			c.Client, err = c.login(ctx, opts, verifier)
+			if err != nil {
+				return err
+			}
+
+			err = c.saveSession()
+			if err != nil {
+				return errors.Wrapf(err, "Failed to save session to '%s'", c.sessionFile)
+			}
 		}
 
 		c.Finder = find.NewFinder(c.Client.Client, false)
-		c.datacenter, err = c.Finder.Datacenter(ctx, datacenter)
-		if err := c.checkErr(ctx, err); err != nil {
-			return errors.Wrapf(err, "Failed to find data center with name '%s'", datacenter)
+		c.datacenter, err = c.Finder.Datacenter(ctx, opts.Datacenter)
+		if err := c.checkErr(ctx, translateFindErr(err, "data center", opts.Datacenter)); err != nil {
+			return errors.Wrapf(err, "Failed to find data center with name '%s'", opts.Datacenter)
 		}
 
 		c.Finder.SetDatacenter(c.datacenter)
 
-		c.datastore, err = c.Finder.Datastore(ctx, datastore)
-		if err := c.checkErr(ctx, err); err != nil {
-			return errors.Wrapf(err, "Failed to find data store with name '%s'", datastore)
+		c.datastore, err = c.Finder.Datastore(ctx, opts.Datastore)
+		if err := c.checkErr(ctx, translateFindErr(err, "data store", opts.Datastore)); err != nil {
+			return errors.Wrapf(err, "Failed to find data store with name '%s'", opts.Datastore)
 		}
 
 		return nil
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return nil, fmt.Errorf("Timeout while attempting to establish connection to vSphere")
+			err = errors.Wrap(err, "Timeout while attempting to establish connection to vSphere")
 		default:
 			// unknown error
-			return nil, errors.Wrap(err, "Got error while attempting to establish connection to vSphere")
+			err = errors.Wrap(err, "Got error while attempting to establish connection to vSphere")
 		}
+		return nil, newOperationError("NewClient", "", err)
 	}
 
 	return c, nil
@@ -101,12 +199,17 @@ func NewClient(url, username, password, datacenter, datastore string, timeout in
 
 // AssignNote adds a note to the VM, or overwrites the notes entirely
 func (c *Client) AssignNote(vm *VirtualMachine, note string, overwrite bool) error {
+	return c.AssignNoteContext(context.Background(), vm, note, overwrite)
+}
+
+// AssignNoteContext is like AssignNote, but uses the provided context
+func (c *Client) AssignNoteContext(ctx context.Context, vm *VirtualMachine, note string, overwrite bool) error {
 	if c.Verbose {
 		fmt.Printf("Assigning note to VM...\n")
 	}
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		if !overwrite {
@@ -119,6 +222,9 @@ func (c *Client) AssignNote(vm *VirtualMachine, note string, overwrite bool) err
 		config := types.VirtualMachineConfigSpec{
 			Annotation: note,
 		}
+		if c.dryRun("ReconfigVM_Task", vm.Ref, types.ReconfigVM_Task{This: vm.Ref, Spec: config}) {
+			return nil
+		}
 		task, err := vm.VM.Reconfigure(ctx, config)
 		_, err = c.finishTask(ctx, task, err)
 		if err != nil {
@@ -129,39 +235,120 @@ func (c *Client) AssignNote(vm *VirtualMachine, note string, overwrite bool) err
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to assign note to VM")
+			err = errors.Wrap(err, "Timeout while attempting to assign note to VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while assigning note to  VM")
+			err = errors.Wrap(err, "Got error while assigning note to  VM")
 		}
+		return newOperationError("AssignNote", vm.Ref.Value, err)
 	}
 
 	return nil
 }
 
+// CloneOptions are applied to a new VM by the clone itself, rather than by
+// changing the VM afterward, so that the VM never exists without them
+type CloneOptions struct {
+	// LeaseExpires, if set, is recorded as the VM's lease, as for SetLease
+	LeaseExpires *time.Time
+
+	// Configuration, if set, is applied to the new VM.  Its CPUs, memory,
+	// network adapters, extraConfig (i.e., guestinfo data), and customization
+	// are part of the clone; its disks and network are applied by Configure
+	// once the VM exists.  If that fails, the new VM is returned along with
+	// the error, so that it can be cleaned up.
+	Configuration *VirtualMachineConfiguration
+}
+
 // Clone clones the specified VM
 func (c *Client) Clone(vm *VirtualMachine, name, destination, resourcePool string) (*VirtualMachine, error) {
+	return c.CloneContext(context.Background(), vm, name, destination, resourcePool)
+}
+
+// CloneContext is like Clone, but uses the provided context
+func (c *Client) CloneContext(ctx context.Context, vm *VirtualMachine, name, destination, resourcePool string) (*VirtualMachine, error) {
+	return c.CloneWithOptionsContext(ctx, vm, name, destination, resourcePool, nil)
+}
+
+// CloneWithOptions is like Clone, but the options are applied to the new VM
+// as part of the clone
+func (c *Client) CloneWithOptions(vm *VirtualMachine, name, destination, resourcePool string, opts *CloneOptions) (*VirtualMachine, error) {
+	return c.CloneWithOptionsContext(context.Background(), vm, name, destination, resourcePool, opts)
+}
+
+// CloneWithOptionsContext is like CloneWithOptions, but uses the provided
+// context
+func (c *Client) CloneWithOptionsContext(ctx context.Context, vm *VirtualMachine, name, destination, resourcePool string, opts *CloneOptions) (*VirtualMachine, error) {
 	if c.Verbose {
 		fmt.Printf("Cloning VM...\n")
 	}
 
+	return c.clone(ctx, "Clone", vm, nil, name, destination, resourcePool, opts)
+}
+
+// LinkedClone clones the specified VM from one of its snapshots.  Rather than
+// copying the VM's disks, the new VM gets child disks backed by the
+// snapshot's disks, which is much faster.  If snapshot is nil, the VM's
+// current snapshot is used.
+func (c *Client) LinkedClone(vm *VirtualMachine, snapshot *types.ManagedObjectReference, name, destination, resourcePool string) (*VirtualMachine, error) {
+	return c.LinkedCloneContext(context.Background(), vm, snapshot, name, destination, resourcePool)
+}
+
+// LinkedCloneContext is like LinkedClone, but uses the provided context
+func (c *Client) LinkedCloneContext(ctx context.Context, vm *VirtualMachine, snapshot *types.ManagedObjectReference, name, destination, resourcePool string) (*VirtualMachine, error) {
+	return c.LinkedCloneWithOptionsContext(ctx, vm, snapshot, name, destination, resourcePool, nil)
+}
+
+// LinkedCloneWithOptions is like LinkedClone, but the options are applied to
+// the new VM as part of the clone
+func (c *Client) LinkedCloneWithOptions(vm *VirtualMachine, snapshot *types.ManagedObjectReference, name, destination, resourcePool string, opts *CloneOptions) (*VirtualMachine, error) {
+	return c.LinkedCloneWithOptionsContext(context.Background(), vm, snapshot, name, destination, resourcePool, opts)
+}
+
+// LinkedCloneWithOptionsContext is like LinkedCloneWithOptions, but uses the
+// provided context
+func (c *Client) LinkedCloneWithOptionsContext(ctx context.Context, vm *VirtualMachine, snapshot *types.ManagedObjectReference, name, destination, resourcePool string, opts *CloneOptions) (*VirtualMachine, error) {
+	if c.Verbose {
+		fmt.Printf("Creating linked clone of VM...\n")
+	}
+
+	if snapshot == nil {
+		var err error
+		snapshot, err = c.CurrentSnapshotContext(ctx, vm)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			err = errors.Wrap(NotFoundError{Kind: "snapshot", Path: "(current)"}, "Got error while creating linked clone of a VM")
+			return nil, newOperationError("LinkedClone", vm.Ref.Value, err)
+		}
+	}
+
+	return c.clone(ctx, "LinkedClone", vm, snapshot, name, destination, resourcePool, opts)
+}
+
+// clone clones the VM, linking the new VM's disks to the snapshot if one is
+// provided, and applying the options if there are any
+func (c *Client) clone(ctx context.Context, op string, vm *VirtualMachine, snapshot *types.ManagedObjectReference, name, destination, resourcePool string, opts *CloneOptions) (*VirtualMachine, error) {
 	var newVM *object.VirtualMachine
+	var dryRunVM *VirtualMachine
+	var remaining *VirtualMachineConfiguration
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		objPool, err := c.Finder.ResourcePoolOrDefault(ctx, resourcePool)
-		if err := c.checkErr(ctx, err); err != nil {
+		if err := c.checkErr(ctx, translateFindErr(err, "resource pool", resourcePool)); err != nil {
 			return errors.Wrapf(err, "While getting resource pool named '%s'", resourcePool)
 		}
 
 		// makeInventoryPath transforms a path to an inventory path by prepending
 		inventoryDestination := c.makeInventoryPath(destination)
 		objFolder, err := c.Finder.Folder(ctx, inventoryDestination)
-		if err := c.checkErr(ctx, err); err != nil {
+		if err := c.checkErr(ctx, translateFindErr(err, "folder", destination)); err != nil {
 			return errors.Wrapf(err, "While getting folder named '%s'", destination)
 		}
 
@@ -176,6 +363,22 @@ func (c *Client) Clone(vm *VirtualMachine, name, destination, resourcePool strin
 			},
 			Template: false,
 		}
+		if snapshot != nil {
+			config.Snapshot = snapshot
+			config.Location.DiskMoveType = string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking)
+		}
+		if opts != nil {
+			config.Config, config.Customization, remaining, err = c.cloneConfigSpec(ctx, vm, opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		request := types.CloneVM_Task{This: vm.Ref, Folder: objFolderRef, Name: name, Spec: config}
+		if c.dryRun("CloneVM_Task", vm.Ref, request) {
+			dryRunVM = c.dryRunClone(vm, name)
+			return nil
+		}
 
 		task, err := vm.VM.Clone(ctx, objFolder, name, config)
 		res, err := c.finishTask(ctx, task, err)
@@ -188,33 +391,110 @@ func (c *Client) Clone(vm *VirtualMachine, name, destination, resourcePool strin
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return nil, fmt.Errorf("Timeout while attempting to clone VM")
+			err = errors.Wrap(err, "Timeout while attempting to clone VM")
 		default:
 			// unknown error
-			return nil, errors.Wrap(err, "Got error while cloning a VM")
+			err = errors.Wrap(err, "Got error while cloning a VM")
 		}
+		return nil, newOperationError(op, vm.Ref.Value, err)
 	}
 
-	result := &VirtualMachine{
-		Ref: newVM.Reference(),
-		VM:  newVM,
+	result := dryRunVM
+	if result == nil {
+		result = &VirtualMachine{
+			Ref: newVM.Reference(),
+			VM:  newVM,
+		}
+	}
+
+	if remaining != nil {
+		err = c.ConfigureContext(ctx, result, remaining)
+		if err != nil {
+			return result, err
+		}
 	}
 
 	return result, nil
 }
 
+// cloneConfigSpec builds the changes which a clone makes to the new VM: its
+// lease, CPUs, memory, network adapters, extraConfig, and customization.  The
+// network adapters are changed by the clone, so that the customization has
+// settings for each of the new VM's adapters.  The rest of the configuration,
+// if any, is returned, to be applied once the VM exists.
+func (c *Client) cloneConfigSpec(ctx context.Context, vm *VirtualMachine, opts *CloneOptions) (*types.VirtualMachineConfigSpec, *types.CustomizationSpec, *VirtualMachineConfiguration, error) {
+	spec := &types.VirtualMachineConfigSpec{}
+	vmc := opts.Configuration
+	if vmc != nil {
+		spec.ExtraConfig = extraConfigOptions(vmc.ExtraConfig)
+	}
+	if opts.LeaseExpires != nil {
+		spec.ExtraConfig = append(spec.ExtraConfig, leaseOption(*opts.LeaseExpires))
+	}
+
+	if vmc == nil {
+		return spec, nil, nil, nil
+	}
+
+	if vmc.CPUs != nil {
+		spec.NumCPUs = int32(*vmc.CPUs)
+	}
+	if vmc.Memory != nil {
+		spec.MemoryMB = int64(*vmc.Memory)
+	}
+
+	var customization *types.CustomizationSpec
+	if vmc.Network != nil || len(vmc.NetworkAdapters) != 0 || vmc.Customization != nil {
+		devices, err := vm.VM.Device(ctx)
+		if err = c.checkErr(ctx, err); err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "While getting devices")
+		}
+
+		adapters, err := networkAdapterEdits(devices, vmc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		spec.DeviceChange, devices, err = c.networkAdapterChanges(ctx, devices, adapters)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if vmc.Customization != nil {
+			customization, err = c.customizationSpec(ctx, vmc.Customization, devices)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	var remaining *VirtualMachineConfiguration
+	if len(vmc.Disks) != 0 {
+		remaining = &VirtualMachineConfiguration{
+			Disks: vmc.Disks,
+		}
+	}
+
+	return spec, customization, remaining, nil
+}
+
 // Configure will change some of the virtual hardware that the specified VM
 // uses
 func (c *Client) Configure(vm *VirtualMachine, vmc *VirtualMachineConfiguration) error {
+	return c.ConfigureContext(context.Background(), vm, vmc)
+}
+
+// ConfigureContext is like Configure, but uses the provided context
+func (c *Client) ConfigureContext(ctx context.Context, vm *VirtualMachine, vmc *VirtualMachineConfiguration) error {
 	if c.Verbose {
 		fmt.Printf("Configuring VM...\n")
 	}
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		cspec := types.VirtualMachineConfigSpec{}
@@ -227,102 +507,127 @@ func (c *Client) Configure(vm *VirtualMachine, vmc *VirtualMachineConfiguration)
 			cspec.MemoryMB = int64(*vmc.Memory)
 			reconfigure = true
 		}
-
-		if reconfigure == true {
-			task, err := vm.VM.Reconfigure(ctx, cspec)
-			_, err = c.finishTask(ctx, task, err)
-			if err = c.checkErr(ctx, err); err != nil {
-				return err
-			}
+		if len(vmc.ExtraConfig) != 0 {
+			cspec.ExtraConfig = extraConfigOptions(vmc.ExtraConfig)
+			reconfigure = true
 		}
-
-		if vmc.Network != nil {
-			devices, err := vm.VM.Device(ctx)
-			if err = c.checkErr(ctx, err); err != nil {
-				return err
-			}
-
-			dest := []mo.Network{}
-			pc := property.DefaultCollector(c.Client.Client)
-			err = pc.Retrieve(ctx, vm.MO.Network, []string{"name"}, &dest)
-			if err = c.checkErr(ctx, err); err != nil {
+		if len(vmc.Disks) != 0 || vmc.Network != nil || len(vmc.NetworkAdapters) != 0 {
+			changes, err := c.deviceChanges(ctx, vm, vmc)
+			if err != nil {
 				return err
 			}
+			cspec.DeviceChange = changes
+			reconfigure = true
+		}
 
-			network := dest[0]
-
-			if network.Name == *vmc.Network {
-				// There's nothing to change; exit early.
-				return nil
-			}
-
-			backing := &types.VirtualEthernetCardNetworkBackingInfo{
-				VirtualDeviceDeviceBackingInfo: types.VirtualDeviceDeviceBackingInfo{
-					DeviceName: network.Name,
-				},
-			}
-			matchingDevices := devices.SelectByBackingInfo(backing)
-
-			requestedNetwork, err := c.Finder.Network(ctx, *vmc.Network)
+		if reconfigure == true && !c.dryRun("ReconfigVM_Task", vm.Ref, types.ReconfigVM_Task{This: vm.Ref, Spec: cspec}) {
+			task, err := vm.VM.Reconfigure(ctx, cspec)
+			_, err = c.finishTask(ctx, task, err)
 			if err = c.checkErr(ctx, err); err != nil {
 				return err
 			}
+		}
 
-			if requestedNetwork == nil {
-				return fmt.Errorf("Failed to find requested network")
-			}
-
-			requestedBacking, err := requestedNetwork.EthernetCardBackingInfo(ctx)
-			if err = c.checkErr(ctx, err); err != nil {
+		if vmc.Customization != nil {
+			err := c.customize(ctx, vm, vmc.Customization)
+			if err != nil {
 				return err
 			}
-
-			matchingDevices.Select(func(device types.BaseVirtualDevice) bool {
-				device.GetVirtualDevice().Backing = requestedBacking
-				err = vm.VM.EditDevice(ctx, device)
-				if err = c.checkErr(ctx, err); err != nil {
-					// Unclear what to do here; we will continue looping regardless.
-				}
-
-				// We are not collecting the results, so return false
-				return false
-			})
 		}
 
 		return nil
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to reconfigure VM")
+			err = errors.Wrap(err, "Timeout while attempting to reconfigure VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while reconfiguring a VM")
+			err = errors.Wrap(err, "Got error while reconfiguring a VM")
 		}
+		return newOperationError("Configure", vm.Ref.Value, err)
 	}
 
 	return nil
 }
 
+// extraConfigOptions converts extraConfig settings to option values, in order
+// of their keys
+func extraConfigOptions(extraConfig map[string]string) []types.BaseOptionValue {
+	keys := make([]string, 0, len(extraConfig))
+	for key := range extraConfig {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	options := make([]types.BaseOptionValue, 0, len(keys))
+	for _, key := range keys {
+		options = append(options, &types.OptionValue{
+			Key:   key,
+			Value: extraConfig[key],
+		})
+	}
+
+	return options
+}
+
+// deviceChanges builds the device changes for the configuration's disks and
+// network adapters
+func (c *Client) deviceChanges(ctx context.Context, vm *VirtualMachine, vmc *VirtualMachineConfiguration) ([]types.BaseVirtualDeviceConfigSpec, error) {
+	devices, err := vm.VM.Device(ctx)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, err
+	}
+
+	diskChanges, devices, err := c.diskChanges(ctx, devices, vmc.Disks)
+	if err != nil {
+		return nil, err
+	}
+
+	adapters, err := networkAdapterEdits(devices, vmc)
+	if err != nil {
+		return nil, err
+	}
+
+	adapterChanges, _, err := c.networkAdapterChanges(ctx, devices, adapters)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(diskChanges, adapterChanges...), nil
+}
+
 // Destroy will remove a VM from vSphere
 func (c *Client) Destroy(vm *VirtualMachine) error {
+	return c.DestroyContext(context.Background(), vm)
+}
+
+// DestroyContext is like Destroy, but uses the provided context
+func (c *Client) DestroyContext(ctx context.Context, vm *VirtualMachine) error {
 	if c.Verbose {
 		fmt.Printf("Destroying VM...\n")
 	}
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		powerState, err := vm.VM.PowerState(ctx)
+		powerState, err := c.powerState(ctx, vm)
 		if err := c.checkErr(ctx, err); err != nil {
 			return errors.Wrapf(err, "While getting getting power state")
 		}
 
 		if powerState != types.VirtualMachinePowerStatePoweredOff {
-			return fmt.Errorf("Cannot destroy a Vm that is running")
+			return InvalidPowerStateError{
+				Expected: PoweredOff,
+				Actual:   toPowerState(powerState),
+			}
+		}
+
+		if c.dryRun("Destroy_Task", vm.Ref, types.Destroy_Task{This: vm.Ref}) {
+			return nil
 		}
 
 		task, err := vm.VM.Destroy(ctx)
@@ -335,14 +640,15 @@ func (c *Client) Destroy(vm *VirtualMachine) error {
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to destroy VM")
+			err = errors.Wrap(err, "Timeout while attempting to destroy VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while destroy a VM")
+			err = errors.Wrap(err, "Got error while destroy a VM")
 		}
+		return newOperationError("Destroy", vm.Ref.Value, err)
 	}
 
 	return nil
@@ -350,22 +656,32 @@ func (c *Client) Destroy(vm *VirtualMachine) error {
 
 // EnsureOff makes certain that the VM is off (not on or suspended)
 func (c *Client) EnsureOff(vm *VirtualMachine) error {
+	return c.EnsureOffContext(context.Background(), vm)
+}
+
+// EnsureOffContext is like EnsureOff, but uses the provided context
+func (c *Client) EnsureOffContext(ctx context.Context, vm *VirtualMachine) error {
 	if c.Verbose {
 		fmt.Printf("Ensuring off power state...\n")
 	}
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		vmps, err := vm.VM.PowerState(ctx)
-		if err != nil {
+		vmps, err := c.powerState(ctx, vm)
+		if err := c.checkErr(ctx, err); err != nil {
 			return errors.Wrapf(err, "While checking current power state")
 		}
 		if vmps == types.VirtualMachinePowerStatePoweredOff {
 			return nil
 		}
 
+		if c.dryRun("PowerOffVM_Task", vm.Ref, types.PowerOffVM_Task{This: vm.Ref}) {
+			c.setDryRunPowerState(vm, types.VirtualMachinePowerStatePoweredOff)
+			return nil
+		}
+
 		task, err := vm.VM.PowerOff(ctx)
 		_, err = c.finishTask(ctx, task, err)
 		if err != nil {
@@ -376,14 +692,15 @@ func (c *Client) EnsureOff(vm *VirtualMachine) error {
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to power off VM")
+			err = errors.Wrap(err, "Timeout while attempting to power off VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while power off VM")
+			err = errors.Wrap(err, "Got error while power off VM")
 		}
+		return newOperationError("EnsureOff", vm.Ref.Value, err)
 	}
 
 	return nil
@@ -391,22 +708,32 @@ func (c *Client) EnsureOff(vm *VirtualMachine) error {
 
 // EnsureOn makes certain that the VM is on
 func (c *Client) EnsureOn(vm *VirtualMachine) error {
+	return c.EnsureOnContext(context.Background(), vm)
+}
+
+// EnsureOnContext is like EnsureOn, but uses the provided context
+func (c *Client) EnsureOnContext(ctx context.Context, vm *VirtualMachine) error {
 	if c.Verbose {
 		fmt.Printf("Ensuring on power state...\n")
 	}
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		vmps, err := vm.VM.PowerState(ctx)
-		if err != nil {
+		vmps, err := c.powerState(ctx, vm)
+		if err := c.checkErr(ctx, err); err != nil {
 			return errors.Wrapf(err, "While checking current power state")
 		}
 		if vmps == types.VirtualMachinePowerStatePoweredOn {
 			return nil
 		}
 
+		if c.dryRun("PowerOnVM_Task", vm.Ref, types.PowerOnVM_Task{This: vm.Ref}) {
+			c.setDryRunPowerState(vm, types.VirtualMachinePowerStatePoweredOn)
+			return nil
+		}
+
 		task, err := vm.VM.PowerOn(ctx)
 		_, err = c.finishTask(ctx, task, err)
 		if err != nil {
@@ -422,14 +749,15 @@ func (c *Client) EnsureOn(vm *VirtualMachine) error {
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to power on VM")
+			err = errors.Wrap(err, "Timeout while attempting to power on VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while power on VM")
+			err = errors.Wrap(err, "Got error while power on VM")
 		}
+		return newOperationError("EnsureOn", vm.Ref.Value, err)
 	}
 
 	return nil
@@ -437,37 +765,36 @@ func (c *Client) EnsureOn(vm *VirtualMachine) error {
 
 // GetPowerState returns the current power state of the provided VM
 func (c *Client) GetPowerState(vm *VirtualMachine) (PowerState, error) {
+	return c.GetPowerStateContext(context.Background(), vm)
+}
+
+// GetPowerStateContext is like GetPowerState, but uses the provided context
+func (c *Client) GetPowerStateContext(ctx context.Context, vm *VirtualMachine) (PowerState, error) {
 	ps := Unknown
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		s, err := vm.VM.PowerState(ctx)
+		s, err := c.powerState(ctx, vm)
 		if err = c.checkErr(ctx, err); err != nil {
 			return errors.Wrapf(err, "While getting getting power state")
 		}
 
-		switch s {
-		case types.VirtualMachinePowerStatePoweredOff:
-			ps = PoweredOff
-		case types.VirtualMachinePowerStatePoweredOn:
-			ps = PoweredOn
-		case types.VirtualMachinePowerStateSuspended:
-			ps = Suspended
-		}
+		ps = toPowerState(s)
 
 		return nil
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return ps, fmt.Errorf("Timeout while getting power state of VM")
+			err = errors.Wrap(err, "Timeout while getting power state of VM")
 		default:
 			// unknown error
-			return ps, errors.Wrapf(err, "Got error while getting power state of VM")
+			err = errors.Wrapf(err, "Got error while getting power state of VM")
 		}
+		return ps, newOperationError("GetPowerState", vm.Ref.Value, err)
 	}
 
 	return ps, nil
@@ -476,11 +803,16 @@ func (c *Client) GetPowerState(vm *VirtualMachine) (PowerState, error) {
 // Relocate will move the VM into a new destination folder, and/or change its
 // name
 func (c *Client) Relocate(vm *VirtualMachine, name, destination string) error {
+	return c.RelocateContext(context.Background(), vm, name, destination)
+}
+
+// RelocateContext is like Relocate, but uses the provided context
+func (c *Client) RelocateContext(ctx context.Context, vm *VirtualMachine, name, destination string) error {
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		if name != "" {
+		if name != "" && !c.dryRun("Rename_Task", vm.Ref, types.Rename_Task{This: vm.Ref, NewName: name}) {
 			task, err := vm.VM.Rename(ctx, name)
 			_, err = c.finishTask(ctx, task, err)
 			if err = c.checkErr(ctx, err); err != nil {
@@ -489,35 +821,44 @@ func (c *Client) Relocate(vm *VirtualMachine, name, destination string) error {
 		}
 
 		if destination != "" {
-			fmt.Printf("Have destination '%s'\n", destination)
 			inventoryDestination := c.makeInventoryPath(destination)
-			fmt.Printf("inventoryDestination: '%s'\n", inventoryDestination)
+			if c.Verbose {
+				fmt.Printf("Moving VM to '%s'...\n", inventoryDestination)
+			}
 			objFolder, err := c.Finder.Folder(ctx, inventoryDestination)
-			if err := c.checkErr(ctx, err); err != nil {
+			if err := c.checkErr(ctx, translateFindErr(err, "folder", destination)); err != nil {
 				return errors.Wrapf(err, "While getting folder named '%s'", destination)
 			}
 
+			request := types.MoveIntoFolder_Task{This: objFolder.Reference(), List: []types.ManagedObjectReference{vm.Ref}}
+			if c.dryRun("MoveIntoFolder_Task", objFolder.Reference(), request) {
+				return nil
+			}
+
 			task, err := objFolder.MoveInto(ctx, []types.ManagedObjectReference{vm.Ref})
 			_, err = c.finishTask(ctx, task, err)
 			if err = c.checkErr(ctx, err); err != nil {
 				return err
 			}
 
-			fmt.Printf("Relocate complete\n")
+			if c.Verbose {
+				fmt.Printf("Relocate complete\n")
+			}
 		}
 
 		return nil
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to rename and/or move VM")
+			err = errors.Wrap(err, "Timeout while attempting to rename and/or move VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while attempting to rename and/or move VM")
+			err = errors.Wrap(err, "Got error while attempting to rename and/or move VM")
 		}
+		return newOperationError("Relocate", vm.Ref.Value, err)
 	}
 
 	return err
@@ -533,110 +874,317 @@ func (c *Client) ReportSnapshot(mo *types.ManagedObjectReference) *Snapshot {
 
 // ReportVM writes descriptive JSON data to the console
 func (c *Client) ReportVM(vm *VirtualMachine) *VirtualMachineInfo {
+	return c.ReportVMContext(context.Background(), vm)
+}
+
+// ReportVMContext is like ReportVM, but uses the provided context
+func (c *Client) ReportVMContext(ctx context.Context, vm *VirtualMachine) *VirtualMachineInfo {
+	return c.ReportVMFieldsContext(ctx, vm, nil)
+}
+
+// ReportVMFields is like ReportVM, but only the named fields, using their
+// JSON names such as "powerState" and "host", need to be reported.  Lookups
+// for the other fields may be skipped; in particular, the VM's IPs are only
+// waited for when "ips" or "configuration" is named.  If no fields are named,
+// all of them are reported.
+func (c *Client) ReportVMFields(vm *VirtualMachine, fields []string) *VirtualMachineInfo {
+	return c.ReportVMFieldsContext(context.Background(), vm, fields)
+}
+
+// ReportVMFieldsContext is like ReportVMFields, but uses the provided context
+func (c *Client) ReportVMFieldsContext(ctx context.Context, vm *VirtualMachine, fields []string) *VirtualMachineInfo {
+	want := func(names ...string) bool {
+		if len(fields) == 0 {
+			return true
+		}
+		for _, f := range fields {
+			for _, name := range names {
+				if f == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
 	d := &VirtualMachineInfo{
 		Configuration: &VirtualMachineConfiguration{},
+		IPs:           []string{},
 	}
 
 	func() {
-		pc := property.DefaultCollector(c.Client.Client)
-
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		d.Ref = vm.VM.Reference().Value
+		d.Ref = vm.Ref.Value
+		if c.dryRunCloned(vm) {
+			// The VM does not exist, so there is nothing more to report
+			return
+		}
 
-		powerState, err := vm.VM.PowerState(ctx)
-		select {
-		case <-ctx.Done():
+		// All of the VM's properties are retrieved at once, and then the
+		// names of the objects which they refer to
+		pc := property.DefaultCollector(c.Client.Client)
+		refs := []types.ManagedObjectReference{vm.VM.Reference()}
+		res := []mo.VirtualMachine{}
+		err := pc.Retrieve(ctx, refs, reportProperties, &res)
+		if err = c.checkErr(ctx, err); err != nil {
 			return
-		default:
+		}
+		moVM := &res[0]
+
+		powerState := moVM.Runtime.PowerState
+		if ps, ok := c.dryRunPowerState(vm); ok {
+			powerState = ps
+		}
+		d.PowerState = toPowerState(powerState)
+		d.IsRunning = powerState != types.VirtualMachinePowerStatePoweredOff
+
+		reportVMProperties(d, moVM)
+
+		var names map[string]string
+		if want("cluster", "configuration", "datastores", "host", "resourcePool") {
+			var cluster string
+			names, cluster, err = c.retrieveRelatedNames(ctx, moVM)
 			if err != nil {
 				return
 			}
-			d.IsRunning = powerState != types.VirtualMachinePowerStatePoweredOff
+			d.Cluster = cluster
+		}
+		if moVM.Runtime.Host != nil {
+			d.Host = names[moVM.Runtime.Host.Value]
+		}
+		if moVM.ResourcePool != nil {
+			d.ResourcePool = names[moVM.ResourcePool.Value]
+		}
+		for i := range d.Datastores {
+			d.Datastores[i].Name = names[moVM.Datastore[i].Value]
+		}
+		if len(moVM.Network) != 0 {
+			if name, ok := names[moVM.Network[0].Value]; ok {
+				d.Configuration.Network = &name
+			}
 		}
 
-		d.IPs = []string{}
-		if d.IsRunning {
-			macs, err := vm.VM.WaitForNetIP(ctx, true)
-			select {
-			case <-ctx.Done():
+		if want("path") {
+			elements, err := c.Finder.Element(ctx, vm.VM.Reference())
+			if err = c.checkErr(ctx, err); err != nil {
 				return
-			default:
-				if err != nil {
-					return
-				}
+			}
+			d.Path = c.makePath(elements.Path)
+		}
 
+		var devices object.VirtualDeviceList
+		if moVM.Config != nil {
+			devices = object.VirtualDeviceList(moVM.Config.Hardware.Device)
+		}
+		d.Configuration.Disks = reportDisks(devices)
+
+		// Waiting for the IPs comes last, since it may take until the
+		// timeout; the adapters are still reported without their IPs if so
+		var macs map[string][]string
+		if d.IsRunning && want("configuration", "ips") {
+			macs, err = vm.VM.WaitForNetIP(ctx, true)
+			if err = c.checkErr(ctx, err); err == nil {
 				for _, addrs := range macs {
 					d.IPs = append(d.IPs, addrs...)
 				}
 			}
 		}
+		d.Configuration.NetworkAdapters = reportNetworkAdapters(devices, names, macs)
+	}()
 
-		elements, err := c.Finder.Element(ctx, vm.VM.Reference())
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if err != nil {
-				return
+	return d
+}
+
+// reportProperties are the VM's properties which are needed by ReportVM
+var reportProperties = []string{
+	"config.annotation",
+	"config.createDate",
+	"config.extraConfig",
+	"config.guestFullName",
+	"config.hardware.device",
+	"config.instanceUuid",
+	"config.uuid",
+	"config.version",
+	"datastore",
+	"guest.guestFullName",
+	"guest.hostName",
+	"guest.toolsRunningStatus",
+	"guest.toolsVersion",
+	"guest.toolsVersionStatus2",
+	"network",
+	"resourcePool",
+	"runtime.bootTime",
+	"runtime.host",
+	"runtime.powerState",
+	"storage.perDatastoreUsage",
+	"summary.config.memorySizeMB",
+	"summary.config.numCpu",
+	"summary.quickStats.uptimeSeconds",
+	"summary.storage",
+}
+
+// reportVMProperties fills in the parts of the report which come directly
+// from the VM's properties
+func reportVMProperties(d *VirtualMachineInfo, vm *mo.VirtualMachine) {
+	cpuCount := int(vm.Summary.Config.NumCpu)
+	memorySize := int(vm.Summary.Config.MemorySizeMB)
+	d.Configuration.CPUs = &cpuCount
+	d.Configuration.Memory = &memorySize
+
+	if vm.Config != nil {
+		d.Annotation = vm.Config.Annotation
+		d.BIOSUUID = vm.Config.Uuid
+		d.Created = vm.Config.CreateDate
+		d.GuestOS = vm.Config.GuestFullName
+		d.HardwareVersion = vm.Config.Version
+		d.InstanceUUID = vm.Config.InstanceUuid
+	}
+	d.LeaseExpires = leaseExpiry(vm)
+
+	if vm.Guest != nil {
+		if vm.Guest.GuestFullName != "" {
+			d.GuestOS = vm.Guest.GuestFullName
+		}
+		d.Hostname = vm.Guest.HostName
+		if vm.Guest.ToolsRunningStatus != "" || vm.Guest.ToolsVersion != "" {
+			d.Tools = &ToolsInfo{
+				RunningStatus: vm.Guest.ToolsRunningStatus,
+				Version:       vm.Guest.ToolsVersion,
+				VersionStatus: vm.Guest.ToolsVersionStatus2,
 			}
-			d.Path = c.makePath(elements.Path)
 		}
+	}
 
-		if vm.MO == nil {
-			refs := []types.ManagedObjectReference{vm.VM.Reference()}
-			res := []mo.VirtualMachine{}
-			err = pc.Retrieve(ctx, refs, []string{"network", "summary"}, &res)
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if err != nil {
-					return
-				}
-				vm.MO = &res[0]
+	d.BootTime = vm.Runtime.BootTime
+	if d.PowerState == PoweredOn {
+		d.UptimeSeconds = int64(vm.Summary.QuickStats.UptimeSeconds)
+	}
+
+	if s := vm.Summary.Storage; s != nil {
+		d.Storage = &StorageUsage{
+			Committed:   s.Committed,
+			Uncommitted: s.Uncommitted,
+			Unshared:    s.Unshared,
+		}
+	}
+
+	// The usage is reported for each of the VM's datastores, in order; the
+	// names are filled in later
+	usage := map[types.ManagedObjectReference]StorageUsage{}
+	if vm.Storage != nil {
+		for _, u := range vm.Storage.PerDatastoreUsage {
+			usage[u.Datastore] = StorageUsage{
+				Committed:   u.Committed,
+				Uncommitted: u.Uncommitted,
+				Unshared:    u.Unshared,
 			}
 		}
+	}
+	for _, ref := range vm.Datastore {
+		d.Datastores = append(d.Datastores, DatastoreUsage{StorageUsage: usage[ref]})
+	}
+}
+
+// retrieveRelatedNames looks up the names of the host, resource pool,
+// datastores and networks which the VM refers to, keyed by their refs'
+// values, along with the name of the host's cluster, in one request
+func (c *Client) retrieveRelatedNames(ctx context.Context, vm *mo.VirtualMachine) (map[string]string, string, error) {
+	objects := []types.ObjectSpec{}
+	if vm.Runtime.Host != nil {
+		// The host's parent is its cluster, or a compute resource of its own
+		objects = append(objects, types.ObjectSpec{
+			Obj: *vm.Runtime.Host,
+			SelectSet: []types.BaseSelectionSpec{
+				&types.TraversalSpec{
+					Type: "HostSystem",
+					Path: "parent",
+				},
+			},
+		})
+	}
+	if vm.ResourcePool != nil {
+		objects = append(objects, types.ObjectSpec{Obj: *vm.ResourcePool})
+	}
+	for _, ref := range vm.Datastore {
+		objects = append(objects, types.ObjectSpec{Obj: ref})
+	}
+	for _, ref := range vm.Network {
+		objects = append(objects, types.ObjectSpec{Obj: ref})
+	}
 
-		cpuCount := int(vm.MO.Summary.Config.NumCpu)
-		memorySize := int(vm.MO.Summary.Config.MemorySizeMB)
-		d.Configuration.CPUs = &cpuCount
-		d.Configuration.Memory = &memorySize
+	names := map[string]string{}
+	if len(objects) == 0 {
+		return names, "", nil
+	}
 
-		dest := []interface{}{}
-		err = pc.Retrieve(ctx, vm.MO.Network, []string{"name"}, &dest)
-		err = c.checkErr(ctx, err)
-		if err != nil {
-			return
-		}
+	req := types.RetrieveProperties{
+		SpecSet: []types.PropertyFilterSpec{
+			{
+				ObjectSet: objects,
+				PropSet: []types.PropertySpec{
+					{
+						Type:    "ManagedEntity",
+						PathSet: []string{"name"},
+					},
+				},
+			},
+		},
+	}
 
-		network := dest[0].(mo.Network)
-		d.Configuration.Network = &network.Name
+	pc := property.DefaultCollector(c.Client.Client)
+	res, err := pc.RetrieveProperties(ctx, req)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, "", err
+	}
 
-	}()
+	var cluster string
+	for _, content := range res.Returnval {
+		for _, prop := range content.PropSet {
+			name, ok := prop.Val.(string)
+			if !ok || prop.Name != "name" {
+				continue
+			}
 
-	return d
+			names[content.Obj.Value] = name
+			if content.Obj.Type == "ClusterComputeResource" {
+				cluster = name
+			}
+		}
+	}
+
+	return names, cluster, nil
 }
 
 // Suspend makes certain that the VM is suspended
 func (c *Client) Suspend(vm *VirtualMachine) error {
+	return c.SuspendContext(context.Background(), vm)
+}
+
+// SuspendContext is like Suspend, but uses the provided context
+func (c *Client) SuspendContext(ctx context.Context, vm *VirtualMachine) error {
 	if c.Verbose {
 		fmt.Printf("Ensuring on suspended state...\n")
 	}
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
-		vmps, err := vm.VM.PowerState(ctx)
-		if err != nil {
+		vmps, err := c.powerState(ctx, vm)
+		if err := c.checkErr(ctx, err); err != nil {
 			return errors.Wrapf(err, "While checking current power state")
 		}
 		if vmps == types.VirtualMachinePowerStateSuspended {
 			return nil
 		}
 
+		if c.dryRun("SuspendVM_Task", vm.Ref, types.SuspendVM_Task{This: vm.Ref}) {
+			c.setDryRunPowerState(vm, types.VirtualMachinePowerStateSuspended)
+			return nil
+		}
+
 		task, err := vm.VM.Suspend(ctx)
 		_, err = c.finishTask(ctx, task, err)
 		if err != nil {
@@ -647,41 +1195,114 @@ func (c *Client) Suspend(vm *VirtualMachine) error {
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to suspend VM")
+			err = errors.Wrap(err, "Timeout while attempting to suspend VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while attempting to suspend VM")
+			err = errors.Wrap(err, "Got error while attempting to suspend VM")
 		}
+		return newOperationError("Suspend", vm.Ref.Value, err)
 	}
 
 	return err
 }
 
-func buildConnectionString(vsphere, name, password string) (*url.URL, error) {
-	if name == "" || password == "" {
-		return nil, fmt.Errorf("Missing username or password")
+// toPowerState converts a vSphere power state into a PowerState
+func toPowerState(s types.VirtualMachinePowerState) PowerState {
+	switch s {
+	case types.VirtualMachinePowerStatePoweredOff:
+		return PoweredOff
+	case types.VirtualMachinePowerStatePoweredOn:
+		return PoweredOn
+	case types.VirtualMachinePowerStateSuspended:
+		return Suspended
+	}
+
+	return Unknown
+}
+
+// login connects to vSphere and creates a new session.  The credentials are
+// sent to the SessionManager, rather than being included in the URL.
+func (c *Client) login(ctx context.Context, opts *ClientOptions, verifier *tlsVerifier) (*govmomi.Client, error) {
+	password := opts.Password
+	if password == "" && opts.PasswordFunc != nil {
+		var err error
+		password, err = opts.PasswordFunc()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get password for user '%s'", opts.Username)
+		}
+	}
+
+	if opts.Username == "" || password == "" {
+		return nil, InvalidConfigurationError{Message: "Missing username or password"}
+	}
+
+	connectionURL, err := buildConnectionString(opts.VSphere)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := soap.NewClient(connectionURL, opts.Insecure)
+	verifier.configure(sc)
+
+	vc, err := vim25.NewClient(ctx, sc)
+	if verifier.failure != nil {
+		err = verifier.failure
+	}
+	if err := c.checkErr(ctx, err); err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to vSphere at '%s'", opts.VSphere)
+	}
+
+	gc := &govmomi.Client{
+		Client:         vc,
+		SessionManager: session.NewManager(vc),
+	}
+
+	err = gc.Login(ctx, url.UserPassword(opts.Username, password))
+	if err := c.checkErr(ctx, err); err != nil {
+		return nil, errors.Wrapf(err, "Failed to log in to vSphere at '%s' with user '%s'", opts.VSphere, opts.Username)
+	}
+
+	return gc, nil
+}
+
+func buildConnectionString(vsphere string) (*url.URL, error) {
+	if vsphere == "" {
+		return nil, InvalidConfigurationError{Message: "Missing vSphere address"}
 	}
 
-	connectionString := fmt.Sprintf("https://%s:%s@%s/sdk", name, password, vsphere)
+	connectionString := fmt.Sprintf("https://%s/sdk", vsphere)
 	url, err := soap.ParseURL(connectionString)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to form URL")
+		return nil, InvalidConfigurationError{Message: fmt.Sprintf("Failed to form URL for vSphere at '%s'", vsphere)}
 	}
+	url.User = nil
 	return url, nil
 }
 
+// withTimeout derives a context for a single operation, bounded by the
+// client's timeout
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
 func (c *Client) checkErr(ctx context.Context, err error) error {
 	select {
 	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			return CanceledError{}
+		}
 		return TimeoutExceededError{
 			timeout: c.timeout,
 		}
 	default:
 		if err != nil {
-			return err
+			return translateErr(err)
 		}
 	}
 	return nil
@@ -689,21 +1310,41 @@ func (c *Client) checkErr(ctx context.Context, err error) error {
 
 func (c *Client) finishTask(ctx context.Context, task *object.Task, err error) (types.AnyType, error) {
 	if err := c.checkErr(ctx, err); err != nil {
-		return nil, errors.Wrapf(err, "While suspend")
+		return nil, errors.Wrapf(err, "While starting task")
 	}
 
 	ti, err := task.WaitForResult(ctx, nil)
 	if err := c.checkErr(ctx, err); err != nil {
+		if ctx.Err() != nil {
+			// The task is still running, whether the context was canceled or
+			// its deadline passed
+			c.cancelTask(task)
+		}
 		return nil, errors.Wrapf(err, "While waiting for task to finish")
 	}
 
 	if ti.State != types.TaskInfoStateSuccess {
-		return nil, fmt.Errorf(ti.Error.LocalizedMessage)
+		if ti.Error == nil {
+			return nil, TaskFailedError{Fault: "Unknown", Message: string(ti.State)}
+		}
+		return nil, translateFault(ti.Error.Fault, ti.Error.LocalizedMessage)
 	}
 
 	return ti.Result, nil
 }
 
+// cancelTask asks vSphere to stop a task that is still running after its
+// context was canceled or timed out
+func (c *Client) cancelTask(task *object.Task) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), taskCancelTimeout)
+	defer cancelFn()
+
+	err := task.Cancel(ctx)
+	if err != nil && c.Verbose {
+		fmt.Printf("Failed to cancel task %s: %s\n", task.Reference().Value, err.Error())
+	}
+}
+
 // makeInventoryPath transforms a path to an inventory path by prepending
 // the datacenter name and "vm" path segments
 func (c *Client) makeInventoryPath(path string) string {
@@ -0,0 +1,1048 @@
+package vcon
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Names in the inventory of the simulator's default vCenter model
+const (
+	testVM           = "DC0_H0_VM0"
+	testResourcePool = "/DC0/host/DC0_C0/Resources"
+)
+
+// newTestServer starts an in-process vCenter simulator, and returns the
+// options to connect to it.  The simulator keeps its inventory in a global
+// registry, so tests which use it must not run in parallel.
+func newTestServer(t *testing.T) (*ClientOptions, func()) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+
+	opts := &ClientOptions{
+		VSphere:    server.URL.Host,
+		Username:   "user",
+		Password:   "pass",
+		Datacenter: "DC0",
+		Datastore:  "LocalDS_0",
+		Timeout:    10,
+
+		// The simulator's certificate is self-signed
+		Insecure: true,
+	}
+
+	return opts, func() {
+		server.Close()
+		model.Remove()
+	}
+}
+
+// newTestClient starts a simulator with newTestServer, and connects a client
+// to it
+func newTestClient(t *testing.T) (*Client, func()) {
+	opts, done := newTestServer(t)
+
+	c, err := NewClientWithOptions(context.Background(), opts)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	return c, done
+}
+
+// findTestVM finds a VM in the simulator, with the requested properties
+func findTestVM(t *testing.T, c *Client, path string, properties ...string) *VirtualMachine {
+	vm, err := c.FindVM(path, false, properties...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+// properties gets the current properties of the VM
+func properties(t *testing.T, c *Client, vm *VirtualMachine, props ...string) *mo.VirtualMachine {
+	found, err := c.FindVM(vm.Ref.Value, true, props...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found.MO
+}
+
+// extraConfig gets the VM's extraConfig as a map
+func extraConfig(t *testing.T, c *Client, vm *VirtualMachine) map[string]string {
+	o := properties(t, c, vm, "config.extraConfig")
+	values := map[string]string{}
+	for _, bov := range o.Config.ExtraConfig {
+		ov := bov.GetOptionValue()
+		values[ov.Key], _ = ov.Value.(string)
+	}
+	return values
+}
+
+// expectCause fails the test unless the root cause of the error has the same
+// type as expected
+func expectCause(t *testing.T, err error, expected error) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected a %T, got no error", expected)
+	}
+	if cause := errors.Cause(err); reflect.TypeOf(cause) != reflect.TypeOf(expected) {
+		t.Fatalf("expected a %T, got %T: %v", expected, cause, err)
+	}
+}
+
+// simulatedVM stands in for one of the simulator's VMs, adding what the
+// simulator lacks: suspending, renaming, and reporting the new snapshot in the
+// result of CreateSnapshot_Task
+type simulatedVM struct {
+	*simulator.VirtualMachine
+}
+
+// withSimulatedVM replaces the VM in the simulator with a simulatedVM while fn
+// runs.  Other parts of the simulator expect its own VM type, so the original
+// is put back afterwards.
+func withSimulatedVM(vm *VirtualMachine, fn func()) {
+	original := simulator.Map.Get(vm.Ref).(*simulator.VirtualMachine)
+	simulator.Map.Put(&simulatedVM{original})
+	defer simulator.Map.Put(original)
+
+	fn()
+}
+
+// Get lets the simulator's property collector find the VM's properties
+func (vm *simulatedVM) Get() mo.Reference {
+	return vm.VirtualMachine
+}
+
+func (vm *simulatedVM) CreateSnapshotTask(req *types.CreateSnapshot_Task) soap.HasFault {
+	body := vm.VirtualMachine.CreateSnapshotTask(req).(*methods.CreateSnapshot_TaskBody)
+	task := simulator.Map.Get(body.Res.Returnval).(*simulator.Task)
+	if task.Info.Error == nil {
+		task.Info.Result = *vm.Snapshot.CurrentSnapshot
+	}
+	return body
+}
+
+func (vm *simulatedVM) RenameTask(req *types.Rename_Task) soap.HasFault {
+	return simulator.RenameTask(vm.VirtualMachine, req)
+}
+
+func (vm *simulatedVM) SuspendVMTask(req *types.SuspendVM_Task) soap.HasFault {
+	task := simulator.CreateTask(vm, "suspend", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			return nil, &types.InvalidPowerState{
+				RequestedState: types.VirtualMachinePowerStatePoweredOn,
+				ExistingState:  vm.Runtime.PowerState,
+			}
+		}
+		vm.Runtime.PowerState = types.VirtualMachinePowerStateSuspended
+		vm.Summary.Runtime.PowerState = types.VirtualMachinePowerStateSuspended
+		return nil, nil
+	})
+
+	return &methods.SuspendVM_TaskBody{
+		Res: &types.SuspendVM_TaskResponse{
+			Returnval: task.Run(),
+		},
+	}
+}
+
+// setGuestIP makes the simulated guest report an IP address, which EnsureOn
+// waits for
+func setGuestIP(vm *VirtualMachine, ip string) {
+	simVM := simulator.Map.Get(vm.Ref).(*simulator.VirtualMachine)
+	if simVM.Guest == nil {
+		simVM.Guest = &types.GuestInfo{}
+	}
+	simVM.Guest.IpAddress = ip
+}
+
+// setGuestInfo makes the simulated guest publish a guestinfo value, without
+// reconfiguring the VM
+func setGuestInfo(vm *VirtualMachine, key string, value string) {
+	simVM := simulator.Map.Get(vm.Ref).(*simulator.VirtualMachine)
+	simVM.Config.ExtraConfig = append(simVM.Config.ExtraConfig, &types.OptionValue{Key: key, Value: value})
+}
+
+func TestFindVM(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM, "name", "config.annotation")
+	if vm.MO == nil || vm.MO.Name != testVM {
+		t.Fatalf("expected the VM's properties, got %+v", vm.MO)
+	}
+
+	byRef, err := c.FindVM(vm.Ref.Value, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byRef.Ref != vm.Ref || byRef.MO != nil {
+		t.Errorf("expected %v without properties, got %v with %+v", vm.Ref, byRef.Ref, byRef.MO)
+	}
+
+	withSlash := findTestVM(t, c, "/"+testVM)
+	if withSlash.Ref != vm.Ref {
+		t.Errorf("expected %v, got %v", vm.Ref, withSlash.Ref)
+	}
+
+	_, err = c.FindVM("missing", false)
+	expectCause(t, err, NotFoundError{})
+	if ExitCode(err) != 2 {
+		t.Errorf("expected exit code 2, got %d", ExitCode(err))
+	}
+}
+
+func TestSession(t *testing.T) {
+	opts, done := newTestServer(t)
+	defer done()
+
+	dir, err := ioutil.TempDir("", "vcon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts.SessionFile = filepath.Join(dir, "session.json")
+
+	c, err := NewClientWithOptions(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(opts.SessionFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected the session to be saved, got %v, %v", fi, err)
+	}
+
+	// The saved session is reused without asking for the password
+	opts.Password = ""
+	opts.PasswordFunc = func() (string, error) {
+		t.Error("expected the session to be reused")
+		return "pass", nil
+	}
+	resumed, err := NewClientWithOptions(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findTestVM(t, resumed, testVM)
+
+	if err := c.Logout(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(opts.SessionFile); !os.IsNotExist(err) {
+		t.Errorf("expected the session file to be removed, got %v", err)
+	}
+
+	// Ending a session which no longer exists is not an error
+	if err := EndSession(context.Background(), opts); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	opts, done := newTestServer(t)
+	defer done()
+
+	opts.Insecure = false
+	_, err := NewClientWithOptions(context.Background(), opts)
+	expectCause(t, err, CertificateError{})
+	if ExitCode(err) != 10 {
+		t.Errorf("expected exit code 10, got %d", ExitCode(err))
+	}
+
+	// The certificate is accepted once its thumbprint is pinned
+	opts.Thumbprint = errors.Cause(err).(CertificateError).Thumbprint
+	if _, err = NewClientWithOptions(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Thumbprint = strings.Repeat("00", 32)
+	_, err = NewClientWithOptions(context.Background(), opts)
+	expectCause(t, err, CertificateError{})
+}
+
+func TestListVMs(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	list, err := c.ListVMs("", false, &VirtualMachineFilter{Name: "DC0_H0_*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, vm := range list {
+		paths = append(paths, vm.Path)
+	}
+	expected := []string{"DC0_H0_VM0", "DC0_H0_VM1"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+
+	_, err = c.ListVMs("missing", false, nil)
+	expectCause(t, err, NotFoundError{})
+
+	_, err = c.ListVMs("", false, &VirtualMachineFilter{Name: "["})
+	expectCause(t, err, InvalidConfigurationError{})
+}
+
+func TestLease(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	lease, err := c.GetLease(vm)
+	if err != nil || lease != nil {
+		t.Fatalf("expected no lease, got %v, %v", lease, err)
+	}
+
+	expires := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := c.SetLease(vm, expires); err != nil {
+		t.Fatal(err)
+	}
+	lease, err = c.GetLease(vm)
+	if err != nil || lease == nil || !lease.Equal(expires) {
+		t.Fatalf("expected a lease until %v, got %v, %v", expires, lease, err)
+	}
+
+	results, err := c.Reap("", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Ref != vm.Ref.Value || results[0].Destroyed {
+		t.Fatalf("expected the VM to be reported, got %+v", results)
+	}
+
+	results, err = c.Reap("", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Destroyed {
+		t.Fatalf("expected the VM to be destroyed, got %+v", results)
+	}
+	_, err = c.FindVM(testVM, false)
+	expectCause(t, err, NotFoundError{})
+}
+
+func TestClone(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	source := findTestVM(t, c, testVM)
+	cpus, memory := 2, 2048
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := &CloneOptions{
+		LeaseExpires: &expires,
+		Configuration: &VirtualMachineConfiguration{
+			CPUs:          &cpus,
+			Memory:        &memory,
+			ExtraConfig:   map[string]string{"guestinfo.userdata": "data"},
+			Customization: &Customization{Hostname: "clone", Domain: "example.com"},
+		},
+	}
+
+	newVM, err := c.CloneWithOptions(source, "clone-1", "", testResourcePool, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := findTestVM(t, c, "clone-1")
+	if found.Ref != newVM.Ref {
+		t.Errorf("expected the clone at %v, got %v", newVM.Ref, found.Ref)
+	}
+
+	// The simulator only applies the devices in a clone's spec, so check the
+	// configuration in the request instead
+	c.DryRun = true
+	if _, err = c.CloneWithOptions(source, "clone-dry", "", testResourcePool, opts); err != nil {
+		t.Fatal(err)
+	}
+	c.DryRun = false
+
+	requests := c.DryRunRequests()
+	if len(requests) != 1 || requests[0].Method != "CloneVM_Task" {
+		t.Fatalf("expected one CloneVM_Task request, got %+v", requests)
+	}
+	cloneSpec := requests[0].Request.(types.CloneVM_Task).Spec
+	spec := cloneSpec.Config
+	if spec == nil || spec.NumCPUs != int32(cpus) || spec.MemoryMB != int64(memory) {
+		t.Fatalf("expected %d CPUs and %d MB in the clone spec, got %+v", cpus, memory, spec)
+	}
+	if cloneSpec.Customization == nil {
+		t.Error("expected the customization in the clone spec")
+	}
+	ec := map[string]string{}
+	for _, bov := range spec.ExtraConfig {
+		ov := bov.GetOptionValue()
+		ec[ov.Key], _ = ov.Value.(string)
+	}
+	if ec["guestinfo.userdata"] != "data" {
+		t.Errorf("expected the guestinfo in the clone spec, got %v", ec)
+	}
+	if ec[LeaseExpiryKey] != expires.Format(time.RFC3339) {
+		t.Errorf("expected the lease in the clone spec, got %v", ec)
+	}
+
+	plain, err := c.Clone(source, "clone-2", "", testResourcePool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Ref == newVM.Ref || plain.Ref == source.Ref {
+		t.Errorf("expected a new VM, got %v", plain.Ref)
+	}
+
+	_, err = c.Clone(source, "clone-3", "missing", testResourcePool)
+	expectCause(t, err, NotFoundError{})
+	if !strings.Contains(err.Error(), "folder identified by 'missing'") {
+		t.Errorf("expected the missing folder to be reported, got %v", err)
+	}
+
+	_, err = c.Clone(source, "clone-3", "", "missing")
+	expectCause(t, err, NotFoundError{})
+}
+
+func TestLinkedClone(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	source := findTestVM(t, c, testVM)
+	_, err := c.LinkedClone(source, nil, "linked-1", "", testResourcePool)
+	expectCause(t, err, NotFoundError{})
+
+	var snapshot *types.ManagedObjectReference
+	withSimulatedVM(source, func() {
+		if snapshot, err = c.SnapshotCreate(source, "base"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	newVM, err := c.LinkedClone(source, nil, "linked-1", "", testResourcePool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVM2, err := c.LinkedClone(source, snapshot, "linked-2", "", testResourcePool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVM.Ref == newVM2.Ref {
+		t.Errorf("expected two VMs, got %v twice", newVM.Ref)
+	}
+}
+
+func TestCloneMany(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	source := findTestVM(t, c, testVM)
+	results, err := c.CloneMany(source, &CloneManyOptions{
+		Names:        []string{"clone-1", "clone-2", "clone-3"},
+		ResourcePool: testResourcePool,
+		Concurrency:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil || r.VM == nil {
+			t.Fatalf("expected '%s' to be cloned, got %v", r.Name, r.Err)
+		}
+		if found := findTestVM(t, c, r.Name); found.Ref != r.VM.Ref {
+			t.Errorf("expected '%s' to be %v, got %v", r.Name, r.VM.Ref, found.Ref)
+		}
+	}
+
+	// A failure to prepare one VM rolls back all of them
+	failure := fmt.Errorf("failed")
+	results, err = c.CloneMany(source, &CloneManyOptions{
+		Names:        []string{"clone-4", "clone-5"},
+		ResourcePool: testResourcePool,
+		Prepare: func(ctx context.Context, index int, vm *VirtualMachine) error {
+			if index == 1 {
+				return failure
+			}
+			return nil
+		},
+		Rollback: true,
+	})
+	if err != failure {
+		t.Fatalf("expected the failure to be returned, got %v", err)
+	}
+	for _, r := range results {
+		if !r.RolledBack {
+			t.Errorf("expected '%s' to be rolled back", r.Name)
+		}
+		_, err = c.FindVM(r.Name, false)
+		expectCause(t, err, NotFoundError{})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM, "network")
+	cpus, memory := 4, 4096
+	err := c.Configure(vm, &VirtualMachineConfiguration{CPUs: &cpus, Memory: &memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := properties(t, c, vm, "summary.config.numCpu", "summary.config.memorySizeMB")
+	if o.Summary.Config.NumCpu != int32(cpus) || o.Summary.Config.MemorySizeMB != int32(memory) {
+		t.Errorf("expected %d CPUs and %d MB, got %d and %d", cpus, memory, o.Summary.Config.NumCpu, o.Summary.Config.MemorySizeMB)
+	}
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{ExtraConfig: map[string]string{"guestinfo.userdata": "data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec := extraConfig(t, c, vm); ec["guestinfo.userdata"] != "data" {
+		t.Errorf("expected the guestinfo to be set, got %v", ec)
+	}
+
+	network := "missing"
+	err = c.Configure(vm, &VirtualMachineConfiguration{Network: &network})
+	expectCause(t, err, NotFoundError{})
+
+	// The network setting attaches the VM's first adapter
+	network = "DC0_DVPG0"
+	err = c.Configure(vm, &VirtualMachineConfiguration{Network: &network})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := c.ReportVM(vm)
+	if adapters := info.Configuration.NetworkAdapters; len(adapters) != 1 || adapters[0].Network != network {
+		t.Errorf("expected ethernet-0 to be attached to %s, got %+v", network, adapters)
+	}
+
+	remove := []NetworkAdapter{{Name: "ethernet-0", Remove: true}}
+	err = c.Configure(vm, &VirtualMachineConfiguration{Network: &network, NetworkAdapters: remove})
+	expectCause(t, err, InvalidConfigurationError{})
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{NetworkAdapters: remove})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Configure(vm, &VirtualMachineConfiguration{Network: &network})
+	expectCause(t, err, InvalidConfigurationError{})
+}
+
+func TestDisks(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+
+	// disks reports the VM's disks by name
+	disks := func() map[string]Disk {
+		devices, err := vm.VM.Device(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := map[string]Disk{}
+		for _, disk := range reportDisks(devices) {
+			byName[disk.Name] = disk
+		}
+		return byName
+	}
+	before := disks()
+
+	err := c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{SizeMB: 1024, Controller: "scsi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added Disk
+	for name, disk := range disks() {
+		if _, ok := before[name]; !ok {
+			added = disk
+		}
+	}
+	if added.SizeMB != 1024 || added.Provisioning != ProvisioningThin {
+		t.Fatalf("expected a new thin 1024 MB disk, got %+v", added)
+	}
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{Name: added.Name, SizeMB: 2048}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grown := disks()[added.Name]; grown.SizeMB != 2048 {
+		t.Errorf("expected the disk to grow to 2048 MB, got %+v", grown)
+	}
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{Name: added.Name, SizeMB: 1024}}})
+	expectCause(t, err, InvalidConfigurationError{})
+
+	// The existing disk's controller may be given by kind or by name
+	for _, controller := range []string{"scsi", added.Controller} {
+		err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{Name: added.Name, Controller: controller}}})
+		if err != nil {
+			t.Errorf("expected %s to be %s's controller, got %v", controller, added.Name, err)
+		}
+	}
+	for _, controller := range []string{"ide", "nvme", "missing"} {
+		err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{Name: added.Name, Controller: controller}}})
+		expectCause(t, err, InvalidConfigurationError{})
+	}
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{SizeMB: 1024, Controller: "missing"}}})
+	expectCause(t, err, InvalidConfigurationError{})
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{Name: "disk-9-9", SizeMB: 1024}}})
+	expectCause(t, err, NotFoundError{})
+
+	err = c.Configure(vm, &VirtualMachineConfiguration{Disks: []Disk{{Name: added.Name, Remove: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := disks()[added.Name]; ok {
+		t.Error("expected the disk to be removed")
+	}
+}
+
+func TestPower(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	setGuestIP(vm, "10.0.0.1")
+
+	expectPowerState := func(expected PowerState) {
+		t.Helper()
+		ps, err := c.GetPowerState(vm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ps != expected {
+			t.Fatalf("expected %s, got %s", expected, ps)
+		}
+	}
+
+	// The simulator's VMs start powered on
+	expectPowerState(PoweredOn)
+	if err := c.EnsureOn(vm); err != nil {
+		t.Fatal(err)
+	}
+	expectPowerState(PoweredOn)
+
+	if err := c.EnsureOff(vm); err != nil {
+		t.Fatal(err)
+	}
+	expectPowerState(PoweredOff)
+	if err := c.EnsureOff(vm); err != nil {
+		t.Fatal(err)
+	}
+	expectPowerState(PoweredOff)
+
+	if err := c.EnsureOn(vm); err != nil {
+		t.Fatal(err)
+	}
+	expectPowerState(PoweredOn)
+}
+
+func TestSuspend(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	withSimulatedVM(vm, func() {
+		if err := c.Suspend(vm); err != nil {
+			t.Fatal(err)
+		}
+		ps, err := c.GetPowerState(vm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ps != Suspended {
+			t.Fatalf("expected %s, got %s", Suspended, ps)
+		}
+
+		// Suspending a suspended VM does nothing
+		if err := c.Suspend(vm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.EnsureOff(vm); err != nil {
+			t.Fatal(err)
+		}
+		err = c.Suspend(vm)
+		expectCause(t, err, InvalidPowerStateError{})
+	})
+}
+
+func TestDestroy(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	err := c.Destroy(vm)
+	expectCause(t, err, InvalidPowerStateError{})
+	if ExitCode(err) != 6 {
+		t.Errorf("expected exit code 6, got %d", ExitCode(err))
+	}
+	if oe, ok := err.(OperationError); !ok || oe.Operation != "Destroy" || oe.Ref != vm.Ref.Value {
+		t.Errorf("expected the failed operation to be recorded, got %#v", err)
+	}
+
+	if err := c.EnsureOff(vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Destroy(vm); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.FindVM(testVM, false)
+	expectCause(t, err, NotFoundError{})
+}
+
+func TestRelocate(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	folder, err := c.Finder.Folder(context.Background(), "/DC0/vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = folder.CreateFolder(context.Background(), "moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	withSimulatedVM(vm, func() {
+		if err = c.Relocate(vm, "renamed", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if found := findTestVM(t, c, "renamed"); found.Ref != vm.Ref {
+		t.Errorf("expected the renamed VM to be %v, got %v", vm.Ref, found.Ref)
+	}
+
+	if err = c.Relocate(vm, "", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if found := findTestVM(t, c, "moved/renamed"); found.Ref != vm.Ref {
+		t.Errorf("expected the moved VM to be %v, got %v", vm.Ref, found.Ref)
+	}
+
+	err = c.Relocate(vm, "", "missing")
+	expectCause(t, err, NotFoundError{})
+}
+
+func TestAssignNote(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM, "config.annotation")
+	if err := c.AssignNote(vm, "first", true); err != nil {
+		t.Fatal(err)
+	}
+
+	vm = findTestVM(t, c, testVM, "config.annotation")
+	if vm.MO.Config.Annotation != "first" {
+		t.Fatalf("expected the note to be replaced, got %q", vm.MO.Config.Annotation)
+	}
+
+	if err := c.AssignNote(vm, "second", false); err != nil {
+		t.Fatal(err)
+	}
+	vm = findTestVM(t, c, testVM, "config.annotation")
+	if vm.MO.Config.Annotation != "first\n\nsecond" {
+		t.Errorf("expected the note to be appended, got %q", vm.MO.Config.Annotation)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	list, err := c.SnapshotList(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no snapshots, got %+v", list)
+	}
+
+	var first, second *types.ManagedObjectReference
+	withSimulatedVM(vm, func() {
+		if first, err = c.SnapshotCreate(vm, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if second, err = c.SnapshotCreate(vm, "second"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	found, err := c.FindSnapshot(vm, "first", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *found != *first {
+		t.Errorf("expected %v, got %v", first, found)
+	}
+	found, err = c.FindSnapshot(vm, "first/second", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *found != *second {
+		t.Errorf("expected %v, got %v", second, found)
+	}
+	found, err = c.FindSnapshot(vm, second.Value, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *found != *second {
+		t.Errorf("expected %v, got %v", second, found)
+	}
+	_, err = c.FindSnapshot(vm, "missing", false)
+	expectCause(t, err, NotFoundError{})
+
+	// listSnapshots lists the snapshots as they are now
+	listSnapshots := func() []Snapshot {
+		t.Helper()
+		list, err := c.SnapshotList(findTestVM(t, c, testVM))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	list = listSnapshots()
+	if len(list) != 1 || list[0].Name != "first" || len(list[0].Children) != 1 || list[0].Children[0].Name != "second" {
+		t.Fatalf("expected first, with a child second, got %+v", list)
+	}
+	if list[0].IsCurrent || !list[0].Children[0].IsCurrent {
+		t.Errorf("expected second to be current, got %+v", list)
+	}
+
+	if err = c.SnapshotRevertTo(vm, first); err != nil {
+		t.Fatal(err)
+	}
+	list = listSnapshots()
+	if !list[0].IsCurrent || list[0].Children[0].IsCurrent {
+		t.Errorf("expected first to be current, got %+v", list)
+	}
+
+	if err = c.SnapshotRevert(vm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.SnapshotRemove(vm, second); err != nil {
+		t.Fatal(err)
+	}
+	list = listSnapshots()
+	if len(list) != 1 || len(list[0].Children) != 0 {
+		t.Errorf("expected only first, got %+v", list)
+	}
+
+	if err = c.SnapshotRemoveAll(vm); err != nil {
+		t.Fatal(err)
+	}
+	if list = listSnapshots(); len(list) != 0 {
+		t.Errorf("expected no snapshots, got %+v", list)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	setGuestIP(vm, "127.0.0.1")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	setGuestInfo(vm, "guestinfo.ready", "yes")
+
+	conditions := []*WaitCondition{}
+	for _, spec := range []string{"ip", "tcp:" + port, "http://{{ip}}:" + port + "/", "guestinfo.ready=yes"} {
+		wc, err := ParseWaitCondition(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conditions = append(conditions, wc)
+	}
+	results, err := c.WaitFor(vm, conditions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"127.0.0.1", "127.0.0.1:" + port, "http://127.0.0.1:" + port + "/", "yes"}
+	for i, result := range results {
+		if !result.Satisfied || result.Value != expected[i] {
+			t.Errorf("expected %s to be satisfied by %s, got %+v", result.Condition, expected[i], result)
+		}
+	}
+
+	wc, err := ParseWaitCondition("guestinfo.ready=no@1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = c.WaitFor(vm, []*WaitCondition{wc})
+	expectCause(t, err, TimeoutExceededError{})
+	if len(results) != 1 || results[0].Satisfied {
+		t.Errorf("expected the condition not to be satisfied, got %+v", results)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	cpus := 4
+	c.DryRun = true
+
+	newVM, err := c.Clone(vm, "clone-dry", "", testResourcePool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Configure(newVM, &VirtualMachineConfiguration{CPUs: &cpus}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnsureOff(vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Destroy(vm); err != nil {
+		t.Fatal(err)
+	}
+	c.DryRun = false
+
+	methods := []string{}
+	for _, request := range c.DryRunRequests() {
+		methods = append(methods, request.Method)
+	}
+	expected := []string{"CloneVM_Task", "ReconfigVM_Task", "PowerOffVM_Task", "Destroy_Task"}
+	if !reflect.DeepEqual(methods, expected) {
+		t.Errorf("expected %v, got %v", expected, methods)
+	}
+
+	// Nothing was changed
+	if _, err = c.FindVM("clone-dry", false); err == nil {
+		t.Error("expected the clone not to be created")
+	}
+	if ps, err := c.GetPowerState(findTestVM(t, c, testVM)); err != nil || ps != PoweredOn {
+		t.Errorf("expected the VM to be left running, got %v, %v", ps, err)
+	}
+}
+
+func TestForEachVM(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	targets, err := c.ExpandTargets([]VMTarget{
+		{Path: "DC0_H0_*"},
+		{Path: testVM},
+		{Path: "missing-*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []VMTarget{{Path: "DC0_H0_VM0"}, {Path: "DC0_H0_VM1"}, {Path: "missing-*"}}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Fatalf("expected %v, got %v", expected, targets)
+	}
+
+	results, err := c.ForEachVM(targets, 2, nil, func(ctx context.Context, index int, vm *VirtualMachine) (interface{}, error) {
+		return vm.Ref.Value, nil
+	})
+	expectCause(t, err, NotFoundError{})
+	for i, r := range results[:2] {
+		if r.Err != nil || r.Value != r.VM.Ref.Value {
+			t.Errorf("expected %v to be found, got %+v", targets[i], r)
+		}
+	}
+	if results[2].Err == nil {
+		t.Errorf("expected %v not to be found", targets[2])
+	}
+}
+
+func TestReportVM(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	setGuestIP(vm, "10.0.0.1")
+	o := properties(t, c, vm, "config.uuid", "config.instanceUuid", "runtime.host")
+
+	info := c.ReportVM(vm)
+	if info.Path != testVM || info.Ref != vm.Ref.Value || info.PowerState != PoweredOn {
+		t.Errorf("expected %s (%s) to be powered on, got %+v", testVM, vm.Ref.Value, info)
+	}
+	if info.BIOSUUID != o.Config.Uuid || info.InstanceUUID != o.Config.InstanceUuid {
+		t.Errorf("expected UUIDs %s and %s, got %s and %s", o.Config.Uuid, o.Config.InstanceUuid, info.BIOSUUID, info.InstanceUUID)
+	}
+	if info.Host == "" || info.Configuration == nil {
+		t.Errorf("expected the host and configuration, got %+v", info)
+	}
+
+	// Only the named fields are reported
+	info = c.ReportVMFields(vm, []string{"powerState"})
+	if info.PowerState != PoweredOn || info.Host != "" {
+		t.Errorf("expected only the power state, got %+v", info)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+	c.timeout = time.Nanosecond
+
+	_, err := c.FindVM(testVM, false)
+	expectCause(t, err, TimeoutExceededError{})
+
+	err = c.EnsureOff(vm)
+	expectCause(t, err, TimeoutExceededError{})
+
+	_, err = c.Clone(vm, "clone-1", "", testResourcePool)
+	expectCause(t, err, TimeoutExceededError{})
+
+	_, err = c.SnapshotCreate(vm, "late")
+	expectCause(t, err, TimeoutExceededError{})
+}
+
+func TestContext(t *testing.T) {
+	c, done := newTestClient(t)
+	defer done()
+
+	vm := findTestVM(t, c, testVM)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelFn()
+
+	_, err := c.FindVMContext(ctx, testVM, false)
+	expectCause(t, err, TimeoutExceededError{})
+
+	err = c.EnsureOffContext(ctx, vm)
+	expectCause(t, err, TimeoutExceededError{})
+
+	_, err = c.CloneContext(ctx, vm, "clone-1", "", testResourcePool)
+	expectCause(t, err, TimeoutExceededError{})
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	_, err = c.SnapshotListContext(canceled, vm)
+	expectCause(t, err, CanceledError{})
+}
@@ -0,0 +1,140 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// CloneManyOptions describes the VMs to be created by CloneMany
+type CloneManyOptions struct {
+	// Names are the names of the new VMs; one VM is cloned for each name
+	Names []string
+
+	// Destination and ResourcePool are as for Clone
+	Destination  string
+	ResourcePool string
+
+	// Linked creates linked clones, as for LinkedClone.  If Snapshot is nil,
+	// the source's current snapshot is used.
+	Linked   bool
+	Snapshot *types.ManagedObjectReference
+
+	// Options, if set, has the options for each name, as for
+	// CloneWithOptions
+	Options []*CloneOptions
+
+	// Concurrency limits the number of clones in progress at once; if it is
+	// not positive, all the VMs are cloned at once
+	Concurrency int
+
+	// Prepare, if set, is called for each new VM after it is cloned, with the
+	// index of its name.  An error fails that VM, as if the clone had failed.
+	Prepare func(ctx context.Context, index int, vm *VirtualMachine) error
+
+	// Rollback destroys all of the new VMs if any of them fails
+	Rollback bool
+}
+
+// CloneResult describes the outcome for one of the VMs cloned by CloneMany
+type CloneResult struct {
+	Name string
+
+	// VM is the new VM, or nil if it could not be cloned
+	VM *VirtualMachine
+
+	// Err is the reason that the VM failed, if it did
+	Err error
+
+	// RolledBack is whether the VM was destroyed because another VM failed
+	RolledBack bool
+}
+
+// CloneMany clones the specified VM several times in parallel.  There is one
+// result for each name, in the same order.  A failure does not stop the other
+// VMs from being cloned; the first failure is also returned as the error.
+func (c *Client) CloneMany(vm *VirtualMachine, opts *CloneManyOptions) ([]CloneResult, error) {
+	return c.CloneManyContext(context.Background(), vm, opts)
+}
+
+// CloneManyContext is like CloneMany, but uses the provided context
+func (c *Client) CloneManyContext(ctx context.Context, vm *VirtualMachine, opts *CloneManyOptions) ([]CloneResult, error) {
+	if c.Verbose {
+		fmt.Printf("Cloning %d VMs...\n", len(opts.Names))
+	}
+
+	snapshot := opts.Snapshot
+	if opts.Linked && snapshot == nil {
+		var err error
+		snapshot, err = c.CurrentSnapshotContext(ctx, vm)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			err = errors.Wrap(NotFoundError{Kind: "snapshot", Path: "(current)"}, "Got error while creating linked clones of a VM")
+			return nil, newOperationError("CloneMany", vm.Ref.Value, err)
+		}
+	}
+
+	results := make([]CloneResult, len(opts.Names))
+	firstErr := runConcurrently(len(opts.Names), opts.Concurrency, func(i int) error {
+		var cloneOpts *CloneOptions
+		if i < len(opts.Options) {
+			cloneOpts = opts.Options[i]
+		}
+
+		r := &results[i]
+		r.Name = opts.Names[i]
+		if snapshot != nil {
+			r.VM, r.Err = c.LinkedCloneWithOptionsContext(ctx, vm, snapshot, r.Name, opts.Destination, opts.ResourcePool, cloneOpts)
+		} else {
+			r.VM, r.Err = c.CloneWithOptionsContext(ctx, vm, r.Name, opts.Destination, opts.ResourcePool, cloneOpts)
+		}
+
+		if r.Err == nil && opts.Prepare != nil {
+			r.Err = opts.Prepare(ctx, i, r.VM)
+		}
+		return r.Err
+	})
+
+	if firstErr != nil && opts.Rollback {
+		// If the clones were canceled, they should still be cleaned up.
+		rollbackCtx := ctx
+		if ctx.Err() != nil {
+			rollbackCtx = context.Background()
+		}
+		c.rollbackClones(rollbackCtx, results)
+	}
+
+	return results, firstErr
+}
+
+// rollbackClones destroys all of the VMs which were cloned.  A VM which cannot
+// be destroyed keeps its error, or is given one.
+func (c *Client) rollbackClones(ctx context.Context, results []CloneResult) {
+	for i := range results {
+		r := &results[i]
+		if r.VM == nil {
+			continue
+		}
+
+		if c.Verbose {
+			fmt.Printf("Rolling back clone '%s'...\n", r.Name)
+		}
+
+		err := c.EnsureOffContext(ctx, r.VM)
+		if err == nil {
+			err = c.DestroyContext(ctx, r.VM)
+		}
+		if err != nil {
+			if r.Err == nil {
+				r.Err = errors.Wrap(err, "Failed to roll back")
+			}
+			continue
+		}
+
+		r.RolledBack = true
+	}
+}
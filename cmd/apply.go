@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const applyLongDescription = `Creates or updates a set of VMs described by an environment manifest
+
+The manifest is a YAML (or JSON) file like:
+
+  name: nightly-tests              # recorded on each VM, as "vcon.environment"
+  folder: /Engineering/TeamSharks/temporary VMs
+  resourcePool: TeamSharks
+  vms:
+    - name: "web-{{ Index }}"      # a name template; Index counts from 1
+      count: 2
+      source: /Engineering/Templates/Base Template
+      linked: true
+      configuration: { cpus: 2, memory: 4096, network: VLAN3000 }
+      note: Web servers for the nightly tests
+      power: on                    # "on", "off", or "suspend"
+      snapshots: [ baseline ]
+
+Each VM may have its own "folder" and "resourcePool"; otherwise the manifest's are used, or else the configured destination and resource pool.  Names should not depend on the time, so that they stay the same between runs.
+
+The VMs in the folders are matched by name, and a plan is made to create the missing ones and update those which differ.  For an existing VM, only the cpus, memory, and network of the configuration are compared; its hardware is changed while it is off.  VMs in the folders which belong to the environment but are no longer in the manifest are destroyed, unless "--prune=false" is given.  An existing VM with the name of one in the manifest, which does not belong to the environment, is a conflict; with "--adopt", a VM which belongs to no environment is made part of this one instead.
+
+With "--plan", the plan is reported as JSON without changing anything.  Otherwise the changes are made in order, stopping at the first failure, and the plan is reported with what happened to each VM.  A VM which fails while it is being created is destroyed, so that applying the manifest again starts it afresh.
+`
+
+func createApplyCommand() *cobra.Command {
+	file := ""
+	planOnly := false
+	prune := true
+	adopt := false
+
+	cc := NewClientCommand("apply", "Creates or updates a set of VMs described by an environment manifest")
+	cc.Long = applyLongDescription
+	cc.Args = cobra.NoArgs
+
+	cc.RunE = func(_ *cobra.Command, _ []string) error {
+		env, err := cc.readEnvironment(file)
+		if err != nil {
+			return err
+		}
+
+		plan, err := cc.c.PlanEnvironmentContext(cc.ctx, env, prune, adopt)
+		if err != nil {
+			return err
+		}
+
+		if planOnly {
+			return cc.writeToConsole(plan)
+		}
+
+		results, err := cc.c.ApplyPlanContext(cc.ctx, plan)
+		if werr := cc.writeToConsole(results); werr != nil && err == nil {
+			err = werr
+		}
+
+		return err
+	}
+	cc.reportDryRun()
+
+	cc.Flags().StringVarP(&file, "file", "f", file, "environment manifest")
+	cc.MarkFlagRequired("file")
+	cc.Flags().BoolVar(&planOnly, "plan", planOnly, "reports the plan without changing anything")
+	cc.Flags().BoolVar(&prune, "prune", prune, "destroys VMs which belong to the environment but are no longer in the manifest")
+	cc.Flags().BoolVar(&adopt, "adopt", adopt, "makes existing VMs which belong to no environment part of this one")
+
+	return &cc.Command
+}
+
+// environmentManifest is the file read by `apply` and `destroy --file`
+type environmentManifest struct {
+	Name         string       `json:"name"`
+	Folder       string       `json:"folder"`
+	ResourcePool string       `json:"resourcePool"`
+	VMs          []manifestVM `json:"vms"`
+}
+
+// manifestVM describes one or more VMs of an environment manifest
+type manifestVM struct {
+	Name          string                            `json:"name"`
+	Count         *int                              `json:"count"`
+	Source        string                            `json:"source"`
+	Folder        string                            `json:"folder"`
+	ResourcePool  string                            `json:"resourcePool"`
+	Linked        bool                              `json:"linked"`
+	Configuration *vcon.VirtualMachineConfiguration `json:"configuration"`
+	Note          *string                           `json:"note"`
+	Power         manifestPower                     `json:"power"`
+	Snapshots     []string                          `json:"snapshots"`
+}
+
+// manifestPower is the power state of a manifest VM.  YAML reads a bare "on"
+// or "off" as a boolean, so either is accepted.
+type manifestPower string
+
+func (mp *manifestPower) UnmarshalJSON(b []byte) error {
+	var on bool
+	if err := json.Unmarshal(b, &on); err == nil {
+		*mp = powerOff
+		if on {
+			*mp = powerOn
+		}
+		return nil
+	}
+
+	var s string
+	err := json.Unmarshal(b, &s)
+	*mp = manifestPower(s)
+	return err
+}
+
+// readEnvironment reads an environment manifest, and renders the name of each
+// of its VMs
+func (cc *ClientCommand) readEnvironment(file string) (*vcon.Environment, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read manifest '%s': %s", file, err.Error())}
+	}
+
+	// The manifest is converted to JSON, so that the configuration is read
+	// with the same field names as the "--configuration" option.
+	var doc interface{}
+	err = yaml.Unmarshal(b, &doc)
+	if err == nil {
+		b, err = json.Marshal(jsonCompatible(doc))
+	}
+	manifest := environmentManifest{}
+	if err == nil {
+		err = json.Unmarshal(b, &manifest)
+	}
+	if err != nil {
+		return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read manifest '%s': %s", file, err.Error())}
+	}
+
+	if manifest.Name == "" {
+		return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Manifest '%s' must have a name", file)}
+	}
+
+	folder := manifest.Folder
+	if folder == "" {
+		folder = viper.GetString(destinationKey)
+	}
+	resourcePool := manifest.ResourcePool
+	if resourcePool == "" {
+		resourcePool = viper.GetString(resourcePoolKey)
+	}
+
+	env := &vcon.Environment{Name: manifest.Name}
+	for _, mvm := range manifest.VMs {
+		if mvm.Name == "" || mvm.Source == "" {
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Each VM in manifest '%s' must have a name and a source", file)}
+		}
+
+		count := 1
+		if mvm.Count != nil {
+			count = *mvm.Count
+		}
+		if count < 0 {
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("count %d for '%s' is invalid; must not be negative", count, mvm.Name)}
+		}
+
+		var powerState vcon.PowerState
+		switch string(mvm.Power) {
+		case "":
+		case powerOn:
+			powerState = vcon.PoweredOn
+		case powerOff:
+			powerState = vcon.PoweredOff
+		case suspend, "suspended":
+			powerState = vcon.Suspended
+		default:
+			return nil, vcon.InvalidConfigurationError{
+				Message: fmt.Sprintf("power '%s' for '%s' is invalid; must be \"on\", \"off\", or \"suspend\"", mvm.Power, mvm.Name),
+			}
+		}
+
+		vm := vcon.EnvironmentVM{
+			Source:        mvm.Source,
+			Folder:        folder,
+			ResourcePool:  resourcePool,
+			Linked:        mvm.Linked,
+			Configuration: mvm.Configuration,
+			Note:          mvm.Note,
+			PowerState:    powerState,
+			Snapshots:     mvm.Snapshots,
+		}
+		if mvm.Folder != "" {
+			vm.Folder = mvm.Folder
+		}
+		if mvm.ResourcePool != "" {
+			vm.ResourcePool = mvm.ResourcePool
+		}
+
+		for i := 1; i <= count; i++ {
+			cc.nameIndex = i
+			vm.Name, err = cc.renderTemplate(mvm.Name)
+			if err != nil {
+				return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to render name '%s': %s", mvm.Name, err.Error())}
+			}
+			env.VMs = append(env.VMs, vm)
+		}
+	}
+
+	return env, nil
+}
+
+// jsonCompatible converts the maps decoded from YAML, which may have keys of
+// any type, to maps with string keys
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprintf("%v", key)] = jsonCompatible(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+	}
+
+	return v
+}
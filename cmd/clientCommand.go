@@ -2,40 +2,64 @@ package cmd
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"os/user"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/RallyTools/vcon"
 	"github.com/google/uuid"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/vjeantet/jodaTime"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 // Keys for configuration data
 const (
-	configurationKey     = "configuration"
-	datacenterKey        = "datacenter"
-	datastoreKey         = "datastore"
-	destinationKey       = "destination"
-	forceKey             = "force"
-	nameKey              = "name"
-	passwordKey          = "password"
-	promptForPasswordKey = "prompt-for-password"
-	resourcePoolKey      = "resourcepool"
-	timeoutKey           = "timeout"
-	usernameKey          = "username"
-	verboseKey           = "verbose"
-	vSphereKey           = "vsphere"
+	caFileKey               = "ca-file"
+	configurationKey        = "configuration"
+	datacenterKey           = "datacenter"
+	datastoreKey            = "datastore"
+	defaultProfileKey       = "default-profile"
+	destinationKey          = "destination"
+	dryRunKey               = "dry-run"
+	forceKey                = "force"
+	guestPasswordKey        = "guest-password"
+	guestPasswordCommandKey = "guest-password-command"
+	guestPasswordFileKey    = "guest-password-file"
+	guestPasswordStdinKey   = "guest-password-stdin"
+	guestUsernameKey        = "guest-username"
+	insecureKey             = "insecure"
+	nameKey                 = "name"
+	outputKey               = "output"
+	outputErrorsKey         = "output-errors"
+	passwordKey             = "password"
+	passwordCommandKey      = "password-command"
+	passwordFileKey         = "password-file"
+	passwordStdinKey        = "password-stdin"
+	persistSessionKey       = "persist-session"
+	profileKey              = "profile"
+	profilesKey             = "profiles"
+	promptForPasswordKey    = "prompt-for-password"
+	resourcePoolKey         = "resourcepool"
+	thumbprintKey           = "thumbprint"
+	timeoutKey              = "timeout"
+	trustOnFirstUseKey      = "trust-on-first-use"
+	ttlKey                  = "ttl"
+	usernameKey             = "username"
+	verboseKey              = "verbose"
+	vSphereKey              = "vsphere"
 )
 
 const (
@@ -47,9 +71,21 @@ const (
 // ClientCommand embeds a cobra.Command and keeps a vcon.Client
 type ClientCommand struct {
 	cobra.Command
-	c *vcon.Client
+	c   *vcon.Client
+	ctx context.Context
 
-	nameTmpl *template.Template
+	nameTmpl  *template.Template
+	nameIndex int
+	nameMu    sync.Mutex
+
+	// funcs are the functions for name and output templates
+	funcs  template.FuncMap
+	output *outputFormat
+
+	// dryRunReport is set for commands which change vSphere; with
+	// "--dry-run", they report the requests they would have made instead of
+	// their usual output
+	dryRunReport bool
 }
 
 // NewClientCommand creates a new ClientCommand and assigns the PreRunE on
@@ -99,44 +135,170 @@ func NewClientCommand(use, shortDescription string) *ClientCommand {
 			Use:   use,
 			Short: shortDescription,
 		},
-		c:        nil,
-		nameTmpl: tmpl,
+		c:         nil,
+		ctx:       context.Background(),
+		nameTmpl:  tmpl,
+		nameIndex: 1,
+		funcs:     fm,
 	}
 
+	// Index is the number of the VM being named, when several are created
+	tmpl.Funcs(template.FuncMap{
+		"Index": func() int {
+			return cc.nameIndex
+		},
+	})
+
 	cc.Command.PreRunE = cc.preRunE
 
 	return &cc
 }
 
 func (cc *ClientCommand) preRunE(_ *cobra.Command, _ []string) error {
-	password := viper.GetString(passwordKey)
-	if len(password) == 0 && viper.GetBool(promptForPasswordKey) {
-		// Password is not provided; request it now.
-		fmt.Printf("Password for %s: ", viper.GetString(usernameKey))
-		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
-		fmt.Printf("\n")
-		if err != nil {
-			return err
-		}
-		password = string(bytePassword)
-		fmt.Printf("Proceeding...\n")
+	// The output format is checked first, so that nothing is changed if the
+	// results cannot be reported.
+	of, err := parseOutputFormat(viper.GetString(outputKey), cc.funcs)
+	if err != nil {
+		return err
 	}
+	cc.output = of
 
-	url := viper.GetString(vSphereKey)
-	name := viper.GetString(usernameKey)
-	datacenter := viper.GetString(datacenterKey)
-	datastore := viper.GetString(datastoreKey)
-	timeout := viper.GetInt(timeoutKey)
-	c, err := vcon.NewClient(url, name, password, datacenter, datastore, timeout)
+	persist := viper.GetBool(persistSessionKey)
+	c, err := connect(cc.ctx, persist)
 	if err != nil {
-		return &vcon.ConnectionError{}
+		return vcon.ConnectionError{Err: err}
 	}
-	c.Verbose = viper.GetBool(verboseKey)
 	cc.c = c
 
+	// Interrupts are only caught once the credentials have been collected,
+	// so that Ctrl-C still exits at the password prompt
+	cc.ctx = cancelOnInterrupt(cc.ctx)
+
+	if !persist {
+		// The session will not be reused, so end it when vcon exits.
+		transientClients = append(transientClients, c)
+	}
+
 	return nil
 }
 
+// transientClients have sessions which should be ended by Disconnect
+var transientClients []*vcon.Client
+
+// Disconnect logs out of any vSphere sessions which are not being persisted
+// for reuse by later invocations of vcon.  This should be called once the
+// command has finished.
+func Disconnect() {
+	for _, c := range transientClients {
+		err := c.Logout()
+		if err != nil && c.Verbose {
+			fmt.Printf("Failed to log out: %s\n", err.Error())
+		}
+	}
+	transientClients = nil
+}
+
+// connect creates a vcon.Client using the configured connection options.  If
+// the session is persisted, an existing session for the same vSphere instance
+// and user will be reused when possible.
+func connect(ctx context.Context, persist bool) (*vcon.Client, error) {
+	opts, err := clientOptions(persist)
+	if err != nil {
+		return nil, err
+	}
+
+	return vcon.NewClientWithOptions(ctx, opts)
+}
+
+// clientOptions builds the vcon.ClientOptions from the configuration
+func clientOptions(persist bool) (*vcon.ClientOptions, error) {
+	if profileErr != nil {
+		return nil, profileErr
+	}
+
+	opts := &vcon.ClientOptions{
+		VSphere:         viper.GetString(vSphereKey),
+		Username:        viper.GetString(usernameKey),
+		Datacenter:      viper.GetString(datacenterKey),
+		Datastore:       viper.GetString(datastoreKey),
+		Timeout:         viper.GetInt(timeoutKey),
+		CAFile:          viper.GetString(caFileKey),
+		Thumbprint:      viper.GetString(thumbprintKey),
+		TrustOnFirstUse: viper.GetBool(trustOnFirstUseKey),
+		Insecure:        viper.GetBool(insecureKey),
+		DryRun:          viper.GetBool(dryRunKey),
+		Verbose:         viper.GetBool(verboseKey),
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	opts.KnownHostsFile = filepath.Join(dir, "known_hosts")
+
+	password, provider, err := selectPasswordProvider(rootFlags, vSpherePasswordSettings, viper.GetBool(promptForPasswordKey))
+	if err != nil {
+		return nil, err
+	}
+	opts.Password = password
+	if provider != nil {
+		opts.PasswordFunc = func() (string, error) {
+			return provider.Password(opts.Username)
+		}
+	}
+
+	if persist {
+		sessionFile, err := sessionFilePath(opts.VSphere, opts.Username)
+		if err != nil {
+			return nil, err
+		}
+		opts.SessionFile = sessionFile
+	}
+
+	return opts, nil
+}
+
+// configDir returns the directory where vcon keeps its private state, such as
+// sessions
+func configDir() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".vcon"), nil
+}
+
+// sessionFilePath returns the path of the file which keeps the session for a
+// user on a vSphere instance
+func sessionFilePath(vsphere, username string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s@%s", username, vsphere)))
+	return filepath.Join(dir, "sessions", fmt.Sprintf("%x.json", key)), nil
+}
+
+// cancelOnInterrupt returns a context which is canceled when the user hits
+// Ctrl-C, so that in-flight vSphere tasks are canceled rather than left
+// running.  A second interrupt is handled by the default signal behavior.
+func cancelOnInterrupt(parent context.Context) context.Context {
+	ctx, cancelFn := context.WithCancel(parent)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		fmt.Fprintf(os.Stderr, "Interrupted; canceling...\n")
+		cancelFn()
+	}()
+
+	return ctx
+}
+
 // generateVMName returns a new name for a VM.  This may be specified by the
 // `--name` flag to the `clone` verb, or if none is provided, one will be
 // created using the current time and user name
@@ -157,22 +319,31 @@ func (cc *ClientCommand) generateSnapshotName(nameTemplate string) string {
 }
 
 func (cc *ClientCommand) generateName(template string) string {
-	tmpl, err := cc.nameTmpl.Parse(template)
+	name, err := cc.renderTemplate(template)
 	if err != nil {
-		fmt.Printf("Template parsing error: %s\n", err.Error())
+		fmt.Printf("%s\n", err.Error())
 		uuid, _ := uuid.NewUUID()
 		return fmt.Sprintf("%s", uuid.String())
 	}
 
+	return name
+}
+
+// renderTemplate executes a Go template string with the name template
+// functions
+func (cc *ClientCommand) renderTemplate(template string) (string, error) {
+	tmpl, err := cc.nameTmpl.Parse(template)
+	if err != nil {
+		return "", errors.Wrap(err, "Template parsing error")
+	}
+
 	var sb strings.Builder
 	err = tmpl.Execute(&sb, nil)
 	if err != nil {
-		fmt.Printf("Template execution error: %s\n", err.Error())
-		uuid, _ := uuid.NewUUID()
-		return fmt.Sprintf("%s", uuid.String())
+		return "", errors.Wrap(err, "Template execution error")
 	}
 
-	return sb.String()
+	return sb.String(), nil
 }
 
 func (cc *ClientCommand) readString(params []string) (string, error) {
@@ -210,26 +381,43 @@ func (cc *ClientCommand) readString(params []string) (string, error) {
 }
 
 func (cc *ClientCommand) writeSnapshotToConsole(snapshot *vcon.Snapshot) error {
-	bytes, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return fmt.Errorf("Failed to serialize to JSON")
-	}
-
-	os.Stdout.Write(bytes)
-	os.Stdout.WriteString("\n")
+	return cc.writeToConsole(snapshot)
+}
 
-	return nil
+func (cc *ClientCommand) writeSnapshotListToConsole(snapshots []vcon.Snapshot) error {
+	return cc.writeToConsole(snapshots)
 }
 
 func (cc *ClientCommand) writeVMInfoToConsole(vm *vcon.VirtualMachine) error {
-	vmi := cc.c.ReportVM(vm)
-	bytes, err := json.MarshalIndent(vmi, "", "  ")
-	if err != nil {
-		return fmt.Errorf("Failed to serialize to JSON")
+	vmi := cc.c.ReportVMContext(cc.ctx, vm)
+	return cc.writeToConsole(vmi)
+}
+
+// reportDryRun makes the command report the requests it would have made,
+// when "--dry-run" is set.  The requests made before a failure are reported
+// too, so it wraps RunE, which must be set first.
+func (cc *ClientCommand) reportDryRun() {
+	cc.dryRunReport = true
+	runE := cc.Command.RunE
+	cc.Command.RunE = func(cmd *cobra.Command, params []string) (err error) {
+		defer func() {
+			if !cc.c.DryRun {
+				return
+			}
+			if werr := cc.writeOutput(cc.c.DryRunRequests()); werr != nil && err == nil {
+				err = werr
+			}
+		}()
+
+		return runE(cmd, params)
 	}
+}
 
-	os.Stdout.Write(bytes)
-	os.Stdout.WriteString("\n")
+func (cc *ClientCommand) writeToConsole(v interface{}) error {
+	if cc.dryRunReport && cc.c != nil && cc.c.DryRun {
+		// The requests are reported instead, once the command has finished
+		return nil
+	}
 
-	return nil
+	return cc.writeOutput(v)
 }
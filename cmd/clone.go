@@ -1,66 +1,224 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"time"
 
 	"github.com/RallyTools/vcon"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 func createCloneCommand() *cobra.Command {
 	configuration := ""
 	name := ""
 	on := true
+	linked := false
+	snapshotName := ""
+	createSnapshot := false
+	count := 1
+	concurrency := 4
+	rollback := false
+	userData := ""
+	metadata := ""
+	vendorData := ""
+	ignition := ""
+	guestInfoEncoding := vcon.GuestInfoGzipBase64
+	waitFor := []string{}
 
 	cc := NewClientCommand("clone SOURCE", "Clones a template or VM")
 	cc.Args = cobra.ExactArgs(1)
 
-	cc.RunE = func(_ *cobra.Command, params []string) error {
+	cc.RunE = func(cmd *cobra.Command, params []string) error {
 		source := params[0]
 
-		vm, err := cc.c.FindVM(source, false)
+		vm, err := cc.c.FindVMContext(cc.ctx, source, false)
 		if err != nil {
 			return err
 		}
 
-		name = cc.generateVMName(name)
 		destination := viper.GetString(destinationKey)
 		resourcePool := viper.GetString(resourcePoolKey)
 
-		newVM, err := cc.c.Clone(vm, name, destination, resourcePool)
+		ttl, err := parseAge(ttlKey, viper.GetString(ttlKey))
 		if err != nil {
 			return err
 		}
 
+		var vmc *vcon.VirtualMachineConfiguration
 		if configuration != "" {
-			vmc := &vcon.VirtualMachineConfiguration{}
+			vmc = &vcon.VirtualMachineConfiguration{}
 			err = json.Unmarshal([]byte(configuration), vmc)
 			if err != nil {
-				// TODO: track errors
+				return vcon.InvalidConfigurationError{Message: err.Error()}
 			}
+		}
+
+		guestInfo, err := readGuestInfoFiles(map[string]string{
+			vcon.GuestInfoUserData:   userData,
+			vcon.GuestInfoMetadata:   metadata,
+			vcon.GuestInfoVendorData: vendorData,
+			vcon.GuestInfoIgnition:   ignition,
+		})
+		if err != nil {
+			return err
+		}
+		if len(guestInfo) != 0 && vmc == nil {
+			vmc = &vcon.VirtualMachineConfiguration{}
+		}
+
+		if !linked && (snapshotName != "" || createSnapshot) {
+			return vcon.InvalidConfigurationError{Message: "The snapshot options require --linked"}
+		}
+
+		if len(waitFor) != 0 && !on {
+			return vcon.InvalidConfigurationError{Message: "--wait-for may not be used with --on=false"}
+		}
+		conditions, err := parseWaitConditions(waitFor)
+		if err != nil {
+			return err
+		}
+
+		var snapshot *types.ManagedObjectReference
+		if linked {
+			snapshot, err = cc.linkedCloneSnapshot(vm, snapshotName, createSnapshot)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The lease and configuration are applied by the clone itself, so that
+		// a VM is never left without them
+		var expires *time.Time
+		if ttl != 0 {
+			t := time.Now().Add(ttl)
+			expires = &t
+		}
+		cloneOptions := func(vmc *vcon.VirtualMachineConfiguration) *vcon.CloneOptions {
+			return &vcon.CloneOptions{
+				LeaseExpires:  expires,
+				Configuration: vmc,
+			}
+		}
+
+		// prepare readies a new VM for use, once it has been cloned
+		prepare := func(ctx context.Context, newVM *vcon.VirtualMachine) error {
+			if on {
+				err := cc.c.EnsureOnContext(ctx, newVM)
+				if err != nil {
+					return errors.Wrap(err, "Error requesting power-on new VM")
+				}
+			}
+
+			if len(conditions) != 0 {
+				_, err := cc.c.WaitForContext(ctx, newVM, conditions)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
 
-			vm, err := cc.c.FindVM(newVM.Ref.Value, true, "network")
+		if !cmd.Flags().Changed("count") {
+			instance, err := cc.guestInfoConfiguration(cc.instanceConfiguration(vmc), guestInfo, guestInfoEncoding)
 			if err != nil {
 				return err
 			}
+			name = cc.generateVMName(name)
 
-			err = cc.c.Configure(vm, vmc)
+			var newVM *vcon.VirtualMachine
+			if linked {
+				newVM, err = cc.c.LinkedCloneWithOptionsContext(cc.ctx, vm, snapshot, name, destination, resourcePool, cloneOptions(instance))
+			} else {
+				newVM, err = cc.c.CloneWithOptionsContext(cc.ctx, vm, name, destination, resourcePool, cloneOptions(instance))
+			}
 			if err != nil {
-				// TODO: track errors
+				return err
 			}
+
+			err = prepare(cc.ctx, newVM)
+			if err != nil {
+				return err
+			}
+
+			return cc.writeVMInfoToConsole(newVM)
 		}
 
-		if on {
-			cc.c.EnsureOn(newVM)
+		if count < 1 {
+			return vcon.InvalidConfigurationError{Message: fmt.Sprintf("count %d is invalid; must be at least 1", count)}
+		}
+
+		names := make([]string, count)
+		options := make([]*vcon.CloneOptions, count)
+		seen := map[string]bool{}
+		for i := range names {
+			cc.nameIndex = i + 1
+			instance, err := cc.guestInfoConfiguration(cc.instanceConfiguration(vmc), guestInfo, guestInfoEncoding)
 			if err != nil {
-				return fmt.Errorf("Error requesting power-on new VM: %s", err.Error())
+				return err
 			}
+			options[i] = cloneOptions(instance)
+			names[i] = cc.generateVMName(name)
+			if seen[names[i]] {
+				return vcon.InvalidConfigurationError{Message: fmt.Sprintf("The name '%s' was generated more than once; use {{ Index }} in the name template", names[i])}
+			}
+			seen[names[i]] = true
+		}
+
+		infos := make([]*vcon.VirtualMachineInfo, count)
+		results, err := cc.c.CloneManyContext(cc.ctx, vm, &vcon.CloneManyOptions{
+			Names:        names,
+			Destination:  destination,
+			ResourcePool: resourcePool,
+			Linked:       linked,
+			Snapshot:     snapshot,
+			Options:      options,
+			Concurrency:  concurrency,
+			Prepare: func(ctx context.Context, index int, newVM *vcon.VirtualMachine) error {
+				err := prepare(ctx, newVM)
+				if err != nil {
+					return err
+				}
+
+				infos[index] = cc.c.ReportVMContext(ctx, newVM)
+				return nil
+			},
+			Rollback: rollback,
+		})
+		if results == nil {
+			return err
+		}
+
+		reports := make([]cloneReport, len(results))
+		for i, r := range results {
+			reports[i] = cloneReport{
+				Name:       r.Name,
+				RolledBack: r.RolledBack,
+			}
+			// A VM which was rolled back no longer exists, so there is
+			// nothing to report about it
+			if r.VM != nil && !r.RolledBack {
+				reports[i].VirtualMachineInfo = infos[i]
+				reports[i].Ref = r.VM.Ref.Value
+			}
+			if r.Err != nil {
+				reports[i].Error = r.Err.Error()
+			}
+		}
+
+		if werr := cc.writeToConsole(reports); werr != nil && err == nil {
+			err = werr
 		}
 
-		return cc.writeVMInfoToConsole(newVM)
+		return err
 	}
+	cc.reportDryRun()
 
 	cc.Flags().StringVarP(&configuration, configurationKey, "c", "", "JSON block containing VM configuration")
 
@@ -71,8 +229,153 @@ func createCloneCommand() *cobra.Command {
 
 	cc.Flags().BoolVar(&on, "on", true, "determines whether the VM will be started after cloning")
 
+	cc.Flags().StringArrayVar(&waitFor, "wait-for", waitFor, "condition to wait for after the VM is started, as for \"vcon wait\"; may be repeated")
+
+	cc.Flags().IntVar(&count, "count", count, "number of VMs to clone; the results are reported as a JSON array")
+	cc.Flags().IntVar(&concurrency, "concurrency", concurrency, "number of VMs to clone at once, with --count")
+	cc.Flags().BoolVar(&rollback, "rollback", rollback, "destroys all of the new VMs if any of them fails, with --count")
+
+	cc.Flags().BoolVar(&linked, "linked", linked, "creates a linked clone, with disks backed by a snapshot of the source")
+	cc.Flags().StringVar(&snapshotName, "snapshot", snapshotName, "name of the snapshot for a linked clone; if no name is specified, the current snapshot is used")
+	cc.Flags().BoolVar(&createSnapshot, "create-snapshot", createSnapshot, "creates the snapshot for a linked clone, if the source does not have it")
+
+	cc.Flags().StringVar(&userData, "userdata", userData, "file with cloud-init user data, passed to the guest as guestinfo.userdata")
+	cc.Flags().StringVar(&metadata, "metadata", metadata, "file with cloud-init metadata, passed to the guest as guestinfo.metadata")
+	cc.Flags().StringVar(&vendorData, "vendordata", vendorData, "file with cloud-init vendor data, passed to the guest as guestinfo.vendordata")
+	cc.Flags().StringVar(&ignition, "ignition", ignition, "file with an Ignition config, passed to the guest as guestinfo.ignition.config.data")
+	cc.Flags().StringVar(&guestInfoEncoding, "guestinfo-encoding", guestInfoEncoding, "encoding for guestinfo data; \"base64\" or \"gzip+base64\"")
+
 	cc.Flags().String(resourcePoolKey, "", "resource pool name for new VM")
 	viper.BindPFlag(resourcePoolKey, cc.Flags().Lookup(resourcePoolKey))
 
+	cc.Flags().String(ttlKey, "", "lease for the new VM, i.e., \"4h\" or \"2d\"; once it expires, the VM may be destroyed by \"vcon reap\"")
+	viper.BindPFlag(ttlKey, cc.Flags().Lookup(ttlKey))
+
 	return &cc.Command
 }
+
+// cloneReport describes one of the VMs created by `clone --count`.  The VM's
+// information is included if it was created successfully, and was not rolled
+// back.
+type cloneReport struct {
+	Name string `json:"name"`
+	*vcon.VirtualMachineInfo
+
+	// Ref is also reported for a VM which was created but then failed
+	Ref        string `json:"ref,omitempty"`
+	Error      string `json:"error,omitempty"`
+	RolledBack bool   `json:"rolledBack,omitempty"`
+}
+
+// linkedCloneSnapshotName is the name of the snapshot created for linked
+// clones, when no name is specified
+const linkedCloneSnapshotName = "Linked clone base"
+
+// linkedCloneSnapshot finds the snapshot to base a linked clone on; either the
+// named snapshot, or the current one.  If it does not exist and createSnapshot
+// is set, it is created.
+func (cc *ClientCommand) linkedCloneSnapshot(vm *vcon.VirtualMachine, snapshotName string, createSnapshot bool) (*types.ManagedObjectReference, error) {
+	var snapshot *types.ManagedObjectReference
+	var err error
+	if snapshotName != "" {
+		snapshot, err = cc.c.FindSnapshotContext(cc.ctx, vm, snapshotName, false)
+		if _, ok := errors.Cause(err).(vcon.NotFoundError); ok && createSnapshot {
+			err = nil
+		}
+	} else {
+		snapshot, err = cc.c.CurrentSnapshotContext(cc.ctx, vm)
+		snapshotName = linkedCloneSnapshotName
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot == nil && createSnapshot {
+		snapshot, err = cc.c.SnapshotCreateContext(cc.ctx, vm, snapshotName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// instanceConfiguration copies the configuration for one new VM, applying the
+// name template functions to the guest's host name
+func (cc *ClientCommand) instanceConfiguration(vmc *vcon.VirtualMachineConfiguration) *vcon.VirtualMachineConfiguration {
+	if vmc == nil || vmc.Customization == nil || vmc.Customization.Hostname == "" {
+		return vmc
+	}
+
+	instance := *vmc
+	customization := *vmc.Customization
+	customization.Hostname = cc.generateName(customization.Hostname)
+	instance.Customization = &customization
+
+	return &instance
+}
+
+// guestInfoFile is the template for a guestinfo key's data, read from a file
+type guestInfoFile struct {
+	key      string
+	path     string
+	template string
+}
+
+// guestInfoKeys lists the guestinfo keys in the order they are added to a
+// configuration
+var guestInfoKeys = []string{
+	vcon.GuestInfoUserData,
+	vcon.GuestInfoMetadata,
+	vcon.GuestInfoVendorData,
+	vcon.GuestInfoIgnition,
+}
+
+// readGuestInfoFiles reads the file for each guestinfo key which has one
+func readGuestInfoFiles(paths map[string]string) ([]guestInfoFile, error) {
+	var files []guestInfoFile
+	for _, key := range guestInfoKeys {
+		path := paths[key]
+		if path == "" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read %s file '%s': %s", key, path, err.Error())}
+		}
+
+		files = append(files, guestInfoFile{key: key, path: path, template: string(b)})
+	}
+
+	return files, nil
+}
+
+// guestInfoConfiguration copies the configuration for one new VM, adding the
+// guestinfo files.  Each file is rendered with the name template functions,
+// so that it may use the VM's Index.
+func (cc *ClientCommand) guestInfoConfiguration(vmc *vcon.VirtualMachineConfiguration, files []guestInfoFile, encoding string) (*vcon.VirtualMachineConfiguration, error) {
+	if len(files) == 0 {
+		return vmc, nil
+	}
+
+	instance := *vmc
+	instance.ExtraConfig = make(map[string]string, len(vmc.ExtraConfig)+2*len(files))
+	for key, value := range vmc.ExtraConfig {
+		instance.ExtraConfig[key] = value
+	}
+
+	for _, f := range files {
+		data, err := cc.renderTemplate(f.template)
+		if err != nil {
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to render %s file '%s': %s", f.key, f.path, err.Error())}
+		}
+
+		err = instance.AddGuestInfo(f.key, []byte(data), encoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &instance, nil
+}
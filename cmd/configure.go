@@ -1,56 +1,66 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 
 	"github.com/RallyTools/vcon"
 	"github.com/spf13/cobra"
 )
 
+const configureLongDescription = `Updates the configuration of a VM
+
+The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.  The VM must be powered off.
+
+The "CONFIGURATION" argument may be either a path to a file to read in, or a literal JSON string.  If no "CONFIGURATION" argument is provided, vcon will read from stdin until is receives an EOF.
+
+The TARGET may be a glob pattern, such as "/Engineering/TeamSharks/temporary VMs/ci-*", to configure several VMs.  With "--targets-from", the targets are read from a file, or from stdin with "-"; there is no TARGET argument, and the only argument is CONFIGURATION, which must be given if the targets are read from stdin.  ` + targetsFromHelp
+
 func createConfigureCommand() *cobra.Command {
-	targetIsRef := false
+	to := &targetOptions{}
 
 	cc := NewClientCommand("configure TARGET [CONFIGURATION]", "Updates the configuration of a VM")
-	cc.Args = cobra.RangeArgs(1, 2)
+	cc.Long = configureLongDescription
+	cc.Args = cobra.RangeArgs(0, 2)
 
 	cc.RunE = func(_ *cobra.Command, params []string) error {
-		target := params[0]
-
-		configuration, err := cc.readString(params[1:])
+		targets, params, err := splitTargetParams(params, to)
 		if err != nil {
 			return err
 		}
 
-		vmc := &vcon.VirtualMachineConfiguration{}
-		err = json.Unmarshal([]byte(configuration), vmc)
+		configuration, err := cc.readString(params)
 		if err != nil {
 			return err
 		}
 
-		vm, err := cc.c.FindVM(target, targetIsRef, "network")
+		vmc := &vcon.VirtualMachineConfiguration{}
+		err = json.Unmarshal([]byte(configuration), vmc)
 		if err != nil {
-			return err
+			return vcon.InvalidConfigurationError{Message: err.Error()}
 		}
 
-		ps, err := cc.c.GetPowerState(vm)
-		if err != nil {
-			return err
-		}
+		return cc.forEachTarget(targets, to, nil, func(ctx context.Context, index int, vm *vcon.VirtualMachine) (interface{}, error) {
+			ps, err := cc.c.GetPowerStateContext(ctx, vm)
+			if err != nil {
+				return nil, err
+			}
 
-		if ps != vcon.PoweredOff {
-			return errors.New("Cannot adjust configuration of a VM that is not powered off")
-		}
+			if ps != vcon.PoweredOff {
+				return nil, vcon.InvalidPowerStateError{Expected: vcon.PoweredOff, Actual: ps}
+			}
 
-		err = cc.c.Configure(vm, vmc)
-		if err != nil {
-			return err
-		}
+			var instance *vcon.VirtualMachineConfiguration
+			cc.withNameIndex(index, func() {
+				instance = cc.instanceConfiguration(vmc)
+			})
 
-		return nil
+			return nil, cc.c.ConfigureContext(ctx, vm, instance)
+		})
 	}
+	cc.reportDryRun()
 
-	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+	addTargetFlags(cc.Flags(), to)
 
 	return &cc.Command
 }
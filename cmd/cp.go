@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+)
+
+const cpLongDescription = `Copies files to or from a VM's guest OS through VMware Tools
+
+Exactly one of SOURCE and DESTINATION is in the guest, written as "TARGET:PATH".  The TARGET is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+The DESTINATION is the path of the copy; an existing file is overwritten.  A directory is copied with its contents, as a tar archive which is unpacked on the other side, so the guest must have "tar".  Permissions are kept on POSIX guests.  The VM must be running, with VMware Tools.  With "--verbose", the progress of each transfer is printed.
+`
+
+func createCpCommand() *cobra.Command {
+	targetIsRef := false
+
+	cc := NewClientCommand("cp SOURCE DESTINATION", "Copies files to or from a VM's guest OS")
+	cc.Args = cobra.ExactArgs(2)
+	cc.Long = cpLongDescription
+
+	cc.RunE = func(_ *cobra.Command, params []string) error {
+		source, destination := params[0], params[1]
+
+		sourceTarget, sourcePath, sourceInGuest := splitGuestPath(source)
+		destinationTarget, destinationPath, destinationInGuest := splitGuestPath(destination)
+		if sourceInGuest == destinationInGuest {
+			return vcon.InvalidConfigurationError{Message: "Exactly one of SOURCE and DESTINATION must be written as TARGET:PATH"}
+		}
+
+		target := sourceTarget
+		if destinationInGuest {
+			target = destinationTarget
+		}
+
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
+		if err != nil {
+			return err
+		}
+
+		creds, err := guestCredentials(cc.Flags())
+		if err != nil {
+			return err
+		}
+
+		var result *vcon.CopyResult
+		if destinationInGuest {
+			result, err = cc.c.CopyToGuestContext(cc.ctx, vm, creds, source, destinationPath)
+		} else {
+			result, err = cc.c.CopyFromGuestContext(cc.ctx, vm, creds, sourcePath, destination)
+		}
+		if err != nil {
+			return err
+		}
+
+		return cc.writeToConsole(result)
+	}
+
+	addGuestCredentialFlags(cc.Flags(), "copy files")
+
+	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+
+	return &cc.Command
+}
+
+// splitGuestPath splits a "TARGET:PATH" argument at its first colon.  An
+// argument without a colon is a local path.
+func splitGuestPath(arg string) (target, path string, inGuest bool) {
+	i := strings.Index(arg, ":")
+	if i == -1 {
+		return "", arg, false
+	}
+	return arg[:i], arg[i+1:], true
+}
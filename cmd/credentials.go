@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/RallyTools/vcon"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// passwordProvider gets the password for a vSphere user.  Providers are only
+// consulted when no password was given and there is no session to reuse.
+// The password must never be written to the console.
+type passwordProvider interface {
+	Password(username string) (string, error)
+}
+
+// passwordSettings names the settings which give a password, or where to get
+// it from
+type passwordSettings struct {
+	password string
+	file     string
+	command  string
+	stdin    string
+}
+
+// vSpherePasswordSettings are the settings for the vSphere user's password
+var vSpherePasswordSettings = passwordSettings{
+	password: passwordKey,
+	file:     passwordFileKey,
+	command:  passwordCommandKey,
+	stdin:    passwordStdinKey,
+}
+
+// guestPasswordSettings are the settings for the guest OS user's password
+var guestPasswordSettings = passwordSettings{
+	password: guestPasswordKey,
+	file:     guestPasswordFileKey,
+	command:  guestPasswordCommandKey,
+	stdin:    guestPasswordStdinKey,
+}
+
+// rootFlags are the global flags, so that the password sources given on the
+// command line can be told apart from those in the config file
+var rootFlags *pflag.FlagSet
+
+// selectPasswordProvider picks the source for the password.  As for any other
+// setting, a source given on the command line takes precedence over one in
+// the environment, which takes precedence over one in the config file.  Two
+// sources on the command line, or two in the environment, are an error.  In
+// the config file, a password takes precedence over a password file, which
+// takes precedence over a password command, which takes precedence over
+// reading stdin; since these are ordinary settings, each profile may choose
+// its own source.  When the password itself is given, it is returned, and
+// there is no provider.  If there is no source, the user is prompted, when
+// prompt is set.
+func selectPasswordProvider(flags *pflag.FlagSet, ps passwordSettings, prompt bool) (string, passwordProvider, error) {
+	keys := []string{ps.password, ps.file, ps.command, ps.stdin}
+
+	// A setting only counts as a source if it has a value; i.e.,
+	// "--password-stdin=false" gives no source.
+	given := func(key string) bool {
+		if key == ps.stdin {
+			return viper.GetBool(key)
+		}
+		return viper.GetString(key) != ""
+	}
+
+	levels := []struct {
+		where string
+		isSet func(key string) bool
+	}{
+		{"on the command line", func(key string) bool {
+			return flags != nil && flags.Lookup(key) != nil && flags.Changed(key)
+		}},
+		{"in the environment", func(key string) bool {
+			return os.Getenv(envName(key)) != ""
+		}},
+		{"in the config file", func(key string) bool {
+			return true
+		}},
+	}
+	for i, level := range levels {
+		set := []string{}
+		for _, key := range keys {
+			if level.isSet(key) && given(key) {
+				set = append(set, key)
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		// The config file's sources are taken in order of precedence
+		if len(set) > 1 && i != len(levels)-1 {
+			return "", nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Only one password source may be given %s, but %s were given", level.where, strings.Join(set, ", "))}
+		}
+
+		switch set[0] {
+		case ps.password:
+			return viper.GetString(ps.password), nil, nil
+		case ps.file:
+			return "", passwordFileProvider{path: viper.GetString(ps.file)}, nil
+		case ps.command:
+			return "", passwordCommandProvider{command: viper.GetString(ps.command)}, nil
+		default:
+			return "", passwordStdinProvider{}, nil
+		}
+	}
+
+	if prompt {
+		return "", passwordPromptProvider{}, nil
+	}
+	return "", nil, nil
+}
+
+// addGuestCredentialFlags adds the flags for the guest OS user, and the
+// sources of its password, as for the vSphere user's.  The action describes
+// what the guest user does, i.e., "run the command".
+func addGuestCredentialFlags(flags *pflag.FlagSet, action string) {
+	flags.String(guestUsernameKey, "", fmt.Sprintf("guest OS user to %s as", action))
+	flags.String(guestPasswordKey, "", "guest OS user password")
+	flags.String(guestPasswordFileKey, "", "file which contains the guest OS user password")
+	flags.String(guestPasswordCommandKey, "", "command which prints the guest OS user password")
+	flags.Bool(guestPasswordStdinKey, false, "reads the guest OS user password from the first line of stdin")
+
+	for _, key := range guestCredentialKeys() {
+		viper.BindEnv(key)
+	}
+}
+
+// guestCredentialKeys are the settings for the guest OS user
+func guestCredentialKeys() []string {
+	ps := guestPasswordSettings
+	return []string{guestUsernameKey, ps.password, ps.file, ps.command, ps.stdin}
+}
+
+// guestCredentials gets the guest OS user and its password, from the flags
+// added by addGuestCredentialFlags, the environment, or the config file.
+// Several commands share these flags, so they are bound to the running
+// command's flags here, rather than when the command is created.
+func guestCredentials(flags *pflag.FlagSet) (*vcon.GuestCredentials, error) {
+	for _, key := range guestCredentialKeys() {
+		viper.BindPFlag(key, flags.Lookup(key))
+	}
+
+	creds := &vcon.GuestCredentials{
+		Username: viper.GetString(guestUsernameKey),
+	}
+
+	password, provider, err := selectPasswordProvider(flags, guestPasswordSettings, false)
+	if err != nil {
+		return nil, err
+	}
+	if provider != nil {
+		password, err = provider.Password(creds.Username)
+		if err != nil {
+			return nil, err
+		}
+	}
+	creds.Password = password
+
+	return creds, nil
+}
+
+// envName is the environment variable for a setting, i.e., VCON_PASSWORD_FILE
+// for "password-file"
+func envName(key string) string {
+	return "VCON_" + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// passwordFileProvider reads the password from the first line of a file
+type passwordFileProvider struct {
+	path string
+}
+
+func (p passwordFileProvider) Password(_ string) (string, error) {
+	path, err := homedir.Expand(p.path)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read password file '%s': %s", p.path, err.Error())}
+	}
+
+	return firstLine(b), nil
+}
+
+// passwordCommandProvider runs a command, such as a password manager's CLI,
+// and uses the first line of its output as the password.  The command shares
+// vcon's stdin and stderr, so that it may prompt the user.
+type passwordCommandProvider struct {
+	command string
+}
+
+func (p passwordCommandProvider) Password(username string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", p.command)
+	} else {
+		cmd = exec.Command("sh", "-c", p.command)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("VCON_USERNAME=%s", username))
+
+	err := cmd.Run()
+	if err != nil {
+		// The output is not included, in case it holds part of the secret.
+		return "", errors.Wrapf(err, "Password command failed")
+	}
+
+	return firstLine(stdout.Bytes()), nil
+}
+
+// passwordStdinProvider reads the password from the first line of stdin, for
+// non-interactive use such as CI jobs
+type passwordStdinProvider struct{}
+
+func (passwordStdinProvider) Password(_ string) (string, error) {
+	// Read a byte at a time, so that nothing after the first line is consumed;
+	// the rest of stdin may be input for the command.
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line.WriteByte(b[0])
+		}
+		if err != nil {
+			if line.Len() > 0 {
+				break
+			}
+			return "", errors.Wrapf(err, "Failed to read password from stdin")
+		}
+	}
+
+	return firstLine(line.Bytes()), nil
+}
+
+// passwordPromptProvider requests the password from the user, without echoing
+// it to the terminal
+type passwordPromptProvider struct{}
+
+func (passwordPromptProvider) Password(username string) (string, error) {
+	fmt.Printf("Password for %s: ", username)
+	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Printf("\n")
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Proceeding...\n")
+
+	return string(bytePassword), nil
+}
+
+// firstLine returns the first line of the text, without its line ending
+func firstLine(b []byte) string {
+	s := string(b)
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimSuffix(s, "\r")
+}
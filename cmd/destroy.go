@@ -1,53 +1,116 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/RallyTools/vcon"
 	"github.com/spf13/cobra"
 )
 
 const destroyLongDescription = `Destroys a VM
 
 The "force" argument will attempt to shut down a VM if it is running.  A running VM cannot be destroyed.
-The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.`
+The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+` + targetsHelp + `
+
+With "--file", there is no TARGET; instead, every VM in the folders of the environment manifest (as for "vcon apply") which belongs to the environment is powered off and destroyed.  VMs which do not belong to the environment are left alone, even if the manifest names them.  The VMs are reported as a JSON array, as for "vcon apply".
+
+Note that unlike "vcon apply", "-f" is short for "--force" here, not "--file"; "--file" must be spelled out.  A TARGET which looks like a manifest file is refused.`
 
 func createDestroyCommand() *cobra.Command {
 	force := false
-	targetIsRef := false
+	to := &targetOptions{}
+	file := ""
 
-	cc := NewClientCommand("destroy TARGET", "Destroys a VM")
+	cc := NewClientCommand("destroy TARGET...", "Destroys VMs")
 	cc.Long = destroyLongDescription
-	cc.Args = cobra.ExactArgs(1)
 
 	cc.RunE = func(_ *cobra.Command, params []string) error {
-		target := params[0]
-
-		vm, err := cc.c.FindVM(target, targetIsRef)
-		if err != nil {
+		if file != "" {
+			if len(params) != 0 || to.targetsFrom != "" {
+				return vcon.InvalidConfigurationError{Message: "A TARGET may not be given with --file"}
+			}
+			return cc.destroyEnvironment(file)
+		}
+		if len(params) == 0 && to.targetsFrom == "" {
+			return vcon.InvalidConfigurationError{Message: "A TARGET is required"}
+		}
+		if err := checkNotManifests(params); err != nil {
 			return err
 		}
 
-		if force {
-			err = cc.c.EnsureOff(vm)
+		return cc.forEachTarget(params, to, nil, noTargetResult(func(ctx context.Context, vm *vcon.VirtualMachine) error {
+			if force {
+				err := cc.c.EnsureOffContext(ctx, vm)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := cc.c.DestroyContext(ctx, vm)
 			if err != nil {
 				return err
 			}
-		}
-
-		err = cc.c.Destroy(vm)
-		if err != nil {
-			return err
-		}
 
-		if cc.c.Verbose {
-			fmt.Printf("OK\n")
-		}
+			if cc.c.Verbose {
+				fmt.Printf("OK\n")
+			}
 
-		return nil
+			return nil
+		}))
 	}
+	cc.reportDryRun()
 
 	cc.Flags().BoolVarP(&force, forceKey, "f", force, "will stop a running VM in order to destroy")
-	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+	addTargetFlags(cc.Flags(), to)
+	cc.Flags().StringVar(&file, "file", file, "environment manifest whose VMs are destroyed, instead of TARGET")
 
 	return &cc.Command
 }
+
+// destroyEnvironment powers off and destroys the VMs of an environment
+// manifest
+func (cc *ClientCommand) destroyEnvironment(file string) error {
+	env, err := cc.readEnvironment(file)
+	if err != nil {
+		return err
+	}
+
+	plan, err := cc.c.PlanEnvironmentDestroyContext(cc.ctx, env)
+	if err != nil {
+		return err
+	}
+
+	results, err := cc.c.ApplyPlanContext(cc.ctx, plan)
+	if werr := cc.writeToConsole(results); werr != nil && err == nil {
+		err = werr
+	}
+
+	return err
+}
+
+// checkNotManifests refuses a TARGET which is a local YAML or JSON file, since
+// "vcon destroy -f env.yaml" is more likely a mistake for "--file" than a VM
+// named like a file
+func checkNotManifests(params []string) error {
+	for _, param := range params {
+		switch strings.ToLower(filepath.Ext(param)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		if fi, err := os.Stat(param); err == nil && !fi.IsDir() {
+			return vcon.InvalidConfigurationError{
+				Message: fmt.Sprintf("TARGET '%s' is a manifest file; use --file to destroy an environment (-f is short for --force)", param),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Formats for reporting errors
+const (
+	errorFormatJSON = "json"
+	errorFormatText = "text"
+)
+
+// errorReport is the JSON form of an error, written to stderr when the
+// `--output-errors=json` option is used
+type errorReport struct {
+	Code      int    `json:"code"`
+	Class     string `json:"class,omitempty"`
+	Message   string `json:"message"`
+	Fault     string `json:"fault,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Ref       string `json:"ref,omitempty"`
+	Step      string `json:"step,omitempty"`
+}
+
+// WriteErrorToConsole reports an error from a command.  By default, the error
+// message is written to stdout; if JSON error output was requested, an
+// errorReport is written to stderr instead.
+func WriteErrorToConsole(err error) {
+	if viper.GetString(outputErrorsKey) != errorFormatJSON {
+		fmt.Println(err)
+		return
+	}
+
+	bytes, jerr := json.Marshal(newErrorReport(err))
+	if jerr != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	os.Stderr.Write(bytes)
+	os.Stderr.WriteString("\n")
+}
+
+// newErrorReport walks the chain of causes for the error, picking up the most
+// specific error class, the vSphere fault, and the failed operation
+func newErrorReport(err error) *errorReport {
+	type causer interface {
+		Cause() error
+	}
+
+	r := &errorReport{
+		Code:    vcon.ExitCode(err),
+		Message: err.Error(),
+	}
+
+	for e := err; e != nil; {
+		if _, ok := e.(vcon.ErrorCoder); ok {
+			t := reflect.TypeOf(e)
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			r.Class = t.Name()
+		}
+
+		switch e := e.(type) {
+		case vcon.OperationError:
+			if r.Operation == "" {
+				r.Operation = e.Operation
+				r.Ref = e.Ref
+				r.Step = e.Step
+			}
+		case vcon.TaskFailedError:
+			r.Fault = e.Fault
+		case vcon.PermissionDeniedError:
+			r.Fault = e.Fault
+		case vcon.InvalidConfigurationError:
+			r.Fault = e.Fault
+		}
+
+		c, ok := e.(causer)
+		if !ok {
+			break
+		}
+		e = c.Cause()
+	}
+
+	return r
+}
+
+// initErrorFormat validates the error format, and silences cobra's usage
+// message when errors are reported as JSON
+func initErrorFormat(rootCmd *cobra.Command) {
+	switch viper.GetString(outputErrorsKey) {
+	case errorFormatJSON:
+		rootCmd.SilenceUsage = true
+	case errorFormatText:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown error format '%s'; using '%s'\n", viper.GetString(outputErrorsKey), errorFormatText)
+		viper.Set(outputErrorsKey, errorFormatText)
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+const execLongDescription = `Runs a command in a VM's guest OS through VMware Tools
+
+The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+The command follows "--", and is run by the guest's shell (/bin/sh, or cmd.exe on Windows) as the guest user.  The VM must be running, with VMware Tools.  The exit code, stdout, and stderr are reported as JSON once the command finishes; the command must finish within the timeout.
+`
+
+func createExecCommand() *cobra.Command {
+	targetIsRef := false
+
+	cc := NewClientCommand("exec TARGET -- COMMAND [ARGS...]", "Runs a command in a VM's guest OS")
+	cc.Args = cobra.MinimumNArgs(2)
+	cc.Long = execLongDescription
+
+	cc.RunE = func(_ *cobra.Command, params []string) error {
+		target := params[0]
+
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
+		if err != nil {
+			return err
+		}
+
+		creds, err := guestCredentials(cc.Flags())
+		if err != nil {
+			return err
+		}
+
+		result, err := cc.c.ExecContext(cc.ctx, vm, creds, params[1:])
+		if err != nil {
+			return err
+		}
+
+		return cc.writeToConsole(result)
+	}
+
+	addGuestCredentialFlags(cc.Flags(), "run the command")
+	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+
+	return &cc.Command
+}
@@ -1,27 +1,110 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/RallyTools/vcon"
 	"github.com/spf13/cobra"
 )
 
+const infoLongDescription = `Retrieves information about a VM
+
+The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+The report includes the VM's power state, guest OS and hostname, VMware Tools status, boot and creation times, host, cluster, resource pool, datastores and disk usage, UUIDs, and hardware version.  With "--fields", such as "--fields powerState,host,ips", only those fields are reported, and lookups for the others are skipped; in particular, vcon only waits for a running VM's IPs when "ips" or "configuration" is requested.
+
+` + targetsHelp
+
 func createInfoCommand() *cobra.Command {
-	targetIsRef := false
+	to := &targetOptions{}
+	fields := []string{}
 
-	cc := NewClientCommand("info TARGET", "Retrieves information about a VM")
-	cc.Args = cobra.ExactArgs(1)
+	cc := NewClientCommand("info TARGET...", "Retrieves information about VMs")
+	cc.Long = infoLongDescription
 
 	cc.RunE = func(_ *cobra.Command, params []string) error {
-		target := params[0]
+		if len(params) == 0 && to.targetsFrom == "" {
+			return vcon.InvalidConfigurationError{Message: "A TARGET is required"}
+		}
 
-		vm, err := cc.c.FindVM(target, targetIsRef, "network", "summary")
-		if err != nil {
+		if err := checkInfoFields(fields); err != nil {
 			return err
 		}
 
-		return cc.writeVMInfoToConsole(vm)
+		return cc.forEachTarget(params, to, nil, func(ctx context.Context, _ int, vm *vcon.VirtualMachine) (interface{}, error) {
+			vmi := cc.c.ReportVMFieldsContext(ctx, vm, fields)
+			if len(fields) == 0 {
+				return vmi, nil
+			}
+			return selectFields(vmi, fields)
+		})
 	}
 
-	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+	addTargetFlags(cc.Flags(), to)
+	cc.Flags().StringSliceVar(&fields, "fields", fields, "only report these fields, by their JSON names")
 
 	return &cc.Command
 }
+
+// infoFields are the JSON names of the fields of vcon.VirtualMachineInfo
+func infoFields() []string {
+	t := reflect.TypeOf(vcon.VirtualMachineInfo{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// checkInfoFields makes certain that each of the requested fields is reported
+// by "info"
+func checkInfoFields(fields []string) error {
+	known := infoFields()
+	for _, field := range fields {
+		found := false
+		for _, name := range known {
+			if field == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return vcon.InvalidConfigurationError{Message: fmt.Sprintf("Unknown field '%s'; the fields are %s", field, strings.Join(known, ", "))}
+		}
+	}
+
+	return nil
+}
+
+// selectFields reduces the value to an object with only the requested fields,
+// as they are named in JSON.  Fields which are omitted from the JSON when
+// empty are left out.
+func selectFields(v interface{}, fields []string) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize to JSON")
+	}
+
+	all := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&all); err != nil {
+		return nil, fmt.Errorf("Failed to serialize to JSON")
+	}
+
+	selected := map[string]interface{}{}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+
+	return selected, nil
+}
@@ -2,98 +2,231 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"syscall"
 
+	"github.com/RallyTools/vcon"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh/terminal"
 	yaml "gopkg.in/yaml.v2"
 )
 
+const initLongDescription = `Adds or edits a profile in the '.vcon.[json|yaml]' configuration file in your home directory
+
+Each profile keeps the settings for one vSphere instance and data center.  The profile is named by the PROFILE argument, or by the '--profile' option; if neither is given, the "default" profile is edited.  The current value of each setting is shown, and is kept if the answer is left blank.  Other profiles, and any other settings in the file, are left as they are.
+
+The first profile added becomes the default profile, which is used when no profile is requested.  The '--default' flag makes the edited profile the default.`
+
 func createInitCommand() *cobra.Command {
+	makeDefault := false
+
 	cmd := &cobra.Command{
-		Use:   "init",
-		Short: "Creates a '.vcon.[json|yaml]' configuration file in your home directory",
-		RunE: func(_ *cobra.Command, _ []string) error {
-			type config struct {
-				Username   string `json:"username,omitempty" yaml:"username,omitempty"`
-				Password   string `json:"password,omitempty" yaml:"password,omitempty"`
-				VSphere    string `json:"vsphere,omitempty" yaml:"vsphere,omitempty"`
-				Datacenter string `json:"datacenter,omitempty" yaml:"datacenter,omitempty"`
-				Datastore  string `json:"datastore,omitempty" yaml:"datastore,omitempty"`
+		Use:   "init [PROFILE]",
+		Short: "Adds or edits a profile in the '.vcon.[json|yaml]' configuration file in your home directory",
+		Long:  initLongDescription,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, params []string) error {
+			profile := viper.GetString(profileKey)
+			if len(params) > 0 {
+				profile = params[0]
 			}
-
-			cfg := config{}
-
-			fmt.Printf("Config file flag: '%s'\n", cfgFile)
-			if cfgFile == "" {
-				home, _ := homedir.Dir()
-				cfgFile = path.Join(home, ".vcon.yaml")
+			if profile == "" {
+				profile = "default"
 			}
-			f, err := os.OpenFile(cfgFile, os.O_CREATE&os.O_WRONLY, 0)
-			if err != nil {
+			if err := checkProfileName(profile); err != nil {
 				return err
 			}
 
-			s := getValueFromUser("Please enter your username for vSphere", "Username")
-			if s != "" {
-				cfg.Username = s
+			file := cfgFile
+			if file == "" {
+				file = viper.ConfigFileUsed()
 			}
-
-			if cfg.Username != "" {
-				fmt.Printf("\n")
-				fmt.Printf("Please enter the password for user '%s'\n", cfg.Username)
-				fmt.Printf("NOTE: your username will be stored in PLAIN TEXT; leave blank for shared or insecure systems!\n")
-				fmt.Printf("Password: ")
-				bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
-				fmt.Printf("\n")
-				if err != nil {
-					return err
-				}
-				cfg.Password = string(bytePassword)
+			if file == "" {
+				home, _ := homedir.Dir()
+				file = path.Join(home, ".vcon.yaml")
 			}
 
-			s = getValueFromUser("Please enter the machine name or IP address of your vSphere installation", "Address")
-			if s != "" {
-				cfg.VSphere = s
+			cfg, err := readConfigFile(file)
+			if err != nil {
+				return err
 			}
 
-			s = getValueFromUser("Please enter the name of your vSphere data center", "Data center")
-			if s != "" {
-				cfg.Datacenter = s
-			}
+			profiles, _ := getConfigValue(cfg, profilesKey).(yaml.MapSlice)
+			settings, _ := getConfigValue(profiles, profile).(yaml.MapSlice)
+
+			fmt.Printf("Editing profile '%s' in '%s'\n", profile, file)
 
-			s = getValueFromUser("Please enter the name of your vSphere data store", "Data store")
-			if s != "" {
-				cfg.Datastore = s
+			prompts := []struct {
+				key     string
+				message string
+				prompt  string
+			}{
+				{usernameKey, "Please enter your username for vSphere", "Username"},
+				{vSphereKey, "Please enter the machine name or IP address of your vSphere installation", "Address"},
+				{datacenterKey, "Please enter the name of your vSphere data center", "Data center"},
+				{datastoreKey, "Please enter the name of your vSphere data store", "Data store"},
+				{destinationKey, "Please enter the folder where new VMs are placed", "Destination"},
+				{resourcePoolKey, "Please enter the resource pool for new VMs", "Resource pool"},
 			}
+			for _, p := range prompts {
+				current, _ := getConfigValue(settings, p.key).(string)
+				s := getValueFromUser(p.message, p.prompt, current)
+				if s != "" {
+					settings = setConfigValue(settings, p.key, s)
+				}
 
-			encoder := yaml.NewEncoder(f)
-			err = encoder.Encode(cfg)
-			if err != nil {
-				return err
+				if p.key == usernameKey && s != "" {
+					current, _ := getConfigValue(settings, passwordCommandKey).(string)
+					command := getValueFromUser("Please enter a command which prints the password, such as a password manager's CLI; leave blank to store the password instead", "Password command", current)
+					if command == "" {
+						command = current
+					}
+					if command != "" {
+						// A stored password would take precedence over the command.
+						settings = setConfigValue(settings, passwordCommandKey, command)
+						settings = removeConfigValue(settings, passwordKey)
+						continue
+					}
+
+					fmt.Printf("\n")
+					fmt.Printf("Please enter the password for user '%s'\n", s)
+					fmt.Printf("NOTE: your password will be stored in PLAIN TEXT; leave blank for shared or insecure systems!\n")
+					fmt.Printf("Password: ")
+					bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+					fmt.Printf("\n")
+					if err != nil {
+						return err
+					}
+					if len(bytePassword) > 0 {
+						settings = setConfigValue(settings, passwordKey, string(bytePassword))
+					}
+				}
 			}
 
-			err = f.Close()
-			if err != nil {
-				return err
+			profiles = setConfigValue(profiles, profile, settings)
+			cfg = setConfigValue(cfg, profilesKey, profiles)
+			if makeDefault || getConfigValue(cfg, defaultProfileKey) == nil {
+				cfg = setConfigValue(cfg, defaultProfileKey, profile)
 			}
 
-			return nil
+			return writeConfigFile(file, cfg)
 		},
 	}
 
+	cmd.Flags().BoolVar(&makeDefault, "default", false, "makes the profile the default profile")
+
 	return cmd
 }
 
-func getValueFromUser(message, prompt string) string {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf("\n%s\n%s: ", message, prompt)
-	str, err := reader.ReadString('\n')
+// readConfigFile reads a JSON or YAML config file, keeping the order of its
+// settings.  A missing file is treated as empty.
+func readConfigFile(file string) (yaml.MapSlice, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return yaml.MapSlice{}, nil
+		}
+		return nil, err
+	}
+
+	// JSON is valid YAML, so either kind of file can be read as YAML.
+	cfg := yaml.MapSlice{}
+	err = yaml.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read config file '%s': %s", file, err.Error())}
+	}
+
+	return cfg, nil
+}
+
+// writeConfigFile writes the config as JSON or YAML, depending on the file's
+// extension.  The file may contain passwords, so it is only readable by the
+// current user.
+func writeConfigFile(file string, cfg yaml.MapSlice) error {
+	var b []byte
+	var err error
+	if strings.ToLower(filepath.Ext(file)) == ".json" {
+		b, err = json.MarshalIndent(toJSONValue(cfg), "", "  ")
+		b = append(b, '\n')
+	} else {
+		b, err = yaml.Marshal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+func getConfigValue(ms yaml.MapSlice, key string) interface{} {
+	for _, item := range ms {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func setConfigValue(ms yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range ms {
+		if fmt.Sprint(item.Key) == key {
+			ms[i].Value = value
+			return ms
+		}
+	}
+	return append(ms, yaml.MapItem{Key: key, Value: value})
+}
+
+func removeConfigValue(ms yaml.MapSlice, key string) yaml.MapSlice {
+	for i, item := range ms {
+		if fmt.Sprint(item.Key) == key {
+			return append(ms[:i], ms[i+1:]...)
+		}
+	}
+	return ms
+}
+
+// toJSONValue converts YAML maps, which may have keys of any type, into maps
+// which can be encoded as JSON
+func toJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			m[fmt.Sprint(item.Key)] = toJSONValue(item.Value)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = toJSONValue(e)
+		}
+		return a
+	}
+	return v
+}
+
+// stdinReader is shared by the prompts, so that input buffered by one prompt
+// is not lost to the next
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// getValueFromUser prompts for a value.  If there is a current value, it is
+// shown with the prompt, and an empty answer keeps it.
+func getValueFromUser(message, prompt, current string) string {
+	if current != "" {
+		fmt.Printf("\n%s\n%s [%s]: ", message, prompt, current)
+	} else {
+		fmt.Printf("\n%s\n%s: ", message, prompt)
+	}
+	str, err := stdinReader.ReadString('\n')
 	if err != nil {
 		return ""
 	}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+)
+
+// leaseReport is the JSON form of a VM's lease
+type leaseReport struct {
+	Expires *time.Time `json:"expires"`
+	Ref     string     `json:"ref"`
+}
+
+func createLeaseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lease [extend|show]",
+		Short: "Manages the leases of VMs cloned with a TTL",
+	}
+
+	cmd.AddCommand(
+		createLeaseExtendCommand(),
+		createLeaseShowCommand(),
+	)
+
+	return cmd
+}
+
+func createLeaseExtendCommand() *cobra.Command {
+	targetIsRef := false
+	ttlOption := ""
+
+	cc := NewClientCommand("extend TARGET", "Extends the lease of a VM, so that it expires after the TTL from now")
+	cc.Args = cobra.ExactArgs(1)
+
+	cc.RunE = func(_ *cobra.Command, params []string) error {
+		target := params[0]
+
+		ttl, err := parseAge(ttlKey, ttlOption)
+		if err != nil {
+			return err
+		}
+		if ttl == 0 {
+			return vcon.InvalidConfigurationError{Message: "A TTL is required to extend a lease"}
+		}
+
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
+		if err != nil {
+			return err
+		}
+
+		expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+		err = cc.c.SetLeaseContext(cc.ctx, vm, expires)
+		if err != nil {
+			return err
+		}
+
+		return cc.writeToConsole(&leaseReport{Expires: &expires, Ref: vm.Ref.Value})
+	}
+	cc.reportDryRun()
+
+	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+
+	cc.Flags().StringVar(&ttlOption, ttlKey, ttlOption, "new lease for the VM, from now, i.e., \"4h\" or \"2d\"")
+	cc.MarkFlagRequired(ttlKey)
+
+	return &cc.Command
+}
+
+func createLeaseShowCommand() *cobra.Command {
+	targetIsRef := false
+
+	cc := NewClientCommand("show TARGET", "Shows when the lease of a VM expires")
+	cc.Args = cobra.ExactArgs(1)
+
+	cc.RunE = func(_ *cobra.Command, params []string) error {
+		target := params[0]
+
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
+		if err != nil {
+			return err
+		}
+
+		expires, err := cc.c.GetLeaseContext(cc.ctx, vm)
+		if err != nil {
+			return err
+		}
+
+		return cc.writeToConsole(&leaseReport{Expires: expires, Ref: vm.Ref.Value})
+	}
+
+	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+
+	return &cc.Command
+}
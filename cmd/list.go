@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+)
+
+const listLongDescription = `Lists the VMs in a folder
+
+The FOLDER is a path like those used to identify VMs; if it is omitted, the data center's root folder is listed.  Each VM is reported in the same form as the "info" command, as a JSON array.  The filters are combined, so a VM must match all of them to be listed.
+
+Ages are given as durations such as "90m", "36h", or "7d", and are measured from the VM's creation date, which is only recorded by vSphere 6.7 and later.`
+
+func createListCommand() *cobra.Command {
+	recursive := false
+	name := ""
+	nameRegexp := ""
+	power := ""
+	template := false
+	annotation := ""
+	olderThan := ""
+	newerThan := ""
+
+	cc := NewClientCommand("list [FOLDER]", "Lists the VMs in a folder")
+	cc.Long = listLongDescription
+	cc.Args = cobra.MaximumNArgs(1)
+
+	cc.RunE = func(cmd *cobra.Command, params []string) error {
+		folder := ""
+		if len(params) > 0 {
+			folder = params[0]
+		}
+
+		filter := &vcon.VirtualMachineFilter{
+			Name:       name,
+			Annotation: annotation,
+		}
+
+		if nameRegexp != "" {
+			re, err := regexp.Compile(nameRegexp)
+			if err != nil {
+				return vcon.InvalidConfigurationError{Message: fmt.Sprintf("name-regex '%s' is invalid: %s", nameRegexp, err.Error())}
+			}
+			filter.NameRegexp = re
+		}
+
+		switch power {
+		case "":
+		case powerOn:
+			filter.PowerState = vcon.PoweredOn
+		case powerOff:
+			filter.PowerState = vcon.PoweredOff
+		case suspend, "suspended":
+			filter.PowerState = vcon.Suspended
+		default:
+			return vcon.InvalidConfigurationError{
+				Message: fmt.Sprintf("power state '%s' is invalid; must be \"on\", \"off\", or \"suspended\"", power),
+			}
+		}
+
+		if cmd.Flags().Changed("template") {
+			filter.Template = &template
+		}
+
+		var err error
+		filter.OlderThan, err = parseAge("older-than", olderThan)
+		if err != nil {
+			return err
+		}
+		filter.NewerThan, err = parseAge("newer-than", newerThan)
+		if err != nil {
+			return err
+		}
+
+		vmis, err := cc.c.ListVMsContext(cc.ctx, folder, recursive, filter)
+		if err != nil {
+			return err
+		}
+
+		return cc.writeToConsole(vmis)
+	}
+
+	cc.Flags().BoolVarP(&recursive, "recursive", "r", recursive, "includes VMs in subfolders")
+	cc.Flags().StringVar(&name, "name", name, "glob pattern for VM names, i.e., \"test-*\"")
+	cc.Flags().StringVar(&nameRegexp, "name-regex", nameRegexp, "regular expression for VM names")
+	cc.Flags().StringVar(&power, "power", power, "power state of VMs; \"on\", \"off\", or \"suspended\"")
+	cc.Flags().BoolVar(&template, "template", template, "lists only templates, or with \"--template=false\", only VMs which are not templates")
+	cc.Flags().StringVar(&annotation, "annotation", annotation, "text which must appear in the VMs' notes")
+	cc.Flags().StringVar(&olderThan, "older-than", olderThan, "lists only VMs created longer ago than this, i.e., \"7d\"")
+	cc.Flags().StringVar(&newerThan, "newer-than", newerThan, "lists only VMs created more recently than this, i.e., \"12h\"")
+
+	return &cc.Command
+}
+
+// parseAge parses a duration, which may also be given in days, i.e., "7d"
+func parseAge(option, age string) (time.Duration, error) {
+	if age == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(age, "d"), 64)
+		if err == nil && days > 0 {
+			return time.Duration(days * float64(24*time.Hour)), nil
+		}
+	} else {
+		d, err := time.ParseDuration(age)
+		if err == nil && d > 0 {
+			return d, nil
+		}
+	}
+
+	return 0, vcon.InvalidConfigurationError{Message: fmt.Sprintf("%s '%s' is not a valid age, such as \"36h\" or \"7d\"", option, age)}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RallyTools/vcon"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		age      string
+		expected time.Duration
+		err      bool
+	}{
+		{age: "", expected: 0},
+		{age: "36h", expected: 36 * time.Hour},
+		{age: "90m", expected: 90 * time.Minute},
+		{age: "1h30m", expected: 90 * time.Minute},
+		{age: "7d", expected: 7 * 24 * time.Hour},
+		{age: "1.5d", expected: 36 * time.Hour},
+		{age: "0d", err: true},
+		{age: "-1d", err: true},
+		{age: "0s", err: true},
+		{age: "-2h", err: true},
+		{age: "d", err: true},
+		{age: "7", err: true},
+		{age: "7w", err: true},
+		{age: "week", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.age, func(t *testing.T) {
+			d, err := parseAge("--older-than", tt.age)
+			if tt.err {
+				if _, ok := err.(vcon.InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, d)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"context"
+
+	"github.com/RallyTools/vcon"
 	"github.com/spf13/cobra"
 )
 
@@ -12,21 +15,25 @@ The "NOTES" argument may be either a path to a file to read in, or a literal str
 If no "NOTES" argument is provided, vcon will read from stdin until is receives an EOF.
 
 Notes will be appended to any existing notes, separated by newlines.  If the "--overwrite" flag is set, all existing notes are replaced.
-`
+
+The TARGET may be a glob pattern, such as "/Engineering/TeamSharks/temporary VMs/ci-*", to add the notes to several VMs.  With "--targets-from", the targets are read from a file, or from stdin with "-"; there is no TARGET argument, and the only argument is NOTES, which must be given if the targets are read from stdin.  ` + targetsFromHelp
 
 func createNoteCommand() *cobra.Command {
 	overwrite := false
-	targetIsRef := false
+	to := &targetOptions{}
 
 	cc := NewClientCommand("note TARGET [NOTES]", "Appends notes to a VM")
-	cc.Args = cobra.RangeArgs(1, 2)
+	cc.Args = cobra.RangeArgs(0, 2)
 	cc.Long = noteLongDescription
 
 	cc.RunE = func(_ *cobra.Command, params []string) error {
-		target := params[0]
+		targets, params, err := splitTargetParams(params, to)
+		if err != nil {
+			return err
+		}
 
 		// Get a reader; either Stdin or a specified path
-		note, err := cc.readString(params[1:])
+		note, err := cc.readString(params)
 		if err != nil {
 			return err
 		}
@@ -35,21 +42,14 @@ func createNoteCommand() *cobra.Command {
 		if !overwrite {
 			props = []string{"config.annotation"}
 		}
-		vm, err := cc.c.FindVM(target, targetIsRef, props...)
-		if err != nil {
-			return err
-		}
-
-		err = cc.c.AssignNote(vm, note, overwrite)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return cc.forEachTarget(targets, to, props, noTargetResult(func(ctx context.Context, vm *vcon.VirtualMachine) error {
+			return cc.c.AssignNoteContext(ctx, vm, note, overwrite)
+		}))
 	}
+	cc.reportDryRun()
 
 	cc.Flags().BoolVar(&overwrite, "overwrite", overwrite, "determines whether to replace notes instead of appending")
-	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+	addTargetFlags(cc.Flags(), to)
 
 	return &cc.Command
 }
@@ -0,0 +1,483 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/RallyTools/vcon"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Formats for the output of commands
+const (
+	outputJSON        = "json"
+	outputJSONCompact = "json-compact"
+	outputYAML        = "yaml"
+	outputTable       = "table"
+	outputTemplate    = "template"
+	outputJSONPath    = "jsonpath"
+)
+
+// outputFormat is how a command's results are written, as selected with
+// "--output"
+type outputFormat struct {
+	kind     string
+	tmpl     *template.Template
+	jsonPath []jsonPathStep
+}
+
+// parseOutputFormat reads a format like "yaml" or "template={{ .Ref }}".  The
+// template may use the same functions as name templates.
+func parseOutputFormat(format string, funcs template.FuncMap) (*outputFormat, error) {
+	kind := format
+	arg := ""
+	if i := strings.Index(format, "="); i != -1 {
+		kind = format[:i]
+		arg = trimQuotes(format[i+1:])
+	}
+
+	of := &outputFormat{kind: kind}
+	switch kind {
+	case "":
+		of.kind = outputJSON
+	case outputJSON, outputJSONCompact, outputYAML, outputTable:
+		if arg != "" {
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("output '%s' is invalid; \"%s\" does not take an argument", format, kind)}
+		}
+	case outputTemplate:
+		tmpl, err := template.New("output").Funcs(funcs).Parse(arg)
+		if err != nil {
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("output template '%s' is invalid: %s", arg, err.Error())}
+		}
+		of.tmpl = tmpl
+	case outputJSONPath:
+		steps, err := parseJSONPath(arg)
+		if err != nil {
+			return nil, err
+		}
+		of.jsonPath = steps
+	default:
+		return nil, vcon.InvalidConfigurationError{
+			Message: fmt.Sprintf("output '%s' is invalid; must be \"json\", \"json-compact\", \"yaml\", \"table\", \"template=TEMPLATE\", or \"jsonpath=EXPRESSION\"", format),
+		}
+	}
+
+	return of, nil
+}
+
+// trimQuotes removes one pair of matching quotes around an argument, as left
+// by a shell which did not remove them, i.e., "template='{{ .Ref }}'".  Quotes
+// within the argument are kept.
+func trimQuotes(arg string) string {
+	if len(arg) >= 2 && (arg[0] == '\'' || arg[0] == '"') && arg[len(arg)-1] == arg[0] {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
+
+// write writes the value in the format
+func (of *outputFormat) write(w io.Writer, v interface{}) error {
+	switch of.kind {
+	case outputJSONCompact:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("Failed to serialize to JSON")
+		}
+		w.Write(b)
+		io.WriteString(w, "\n")
+	case outputYAML:
+		ov, err := orderedValue(v)
+		if err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(ov)
+		if err != nil {
+			return fmt.Errorf("Failed to serialize to YAML")
+		}
+		w.Write(b)
+	case outputTable:
+		ov, err := orderedValue(v)
+		if err != nil {
+			return err
+		}
+		return writeTable(w, ov)
+	case outputTemplate:
+		return of.writeTemplate(w, v)
+	case outputJSONPath:
+		ov, err := orderedValue(v)
+		if err != nil {
+			return err
+		}
+		for _, node := range evaluateJSONPath(of.jsonPath, ov) {
+			io.WriteString(w, scalarString(node))
+			io.WriteString(w, "\n")
+		}
+	default:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to serialize to JSON")
+		}
+		w.Write(b)
+		io.WriteString(w, "\n")
+	}
+
+	return nil
+}
+
+// writeTemplate executes the template for the value, or for each element if
+// the value is an array, so that `list` can report one line per VM
+func (of *outputFormat) writeTemplate(w io.Writer, v interface{}) error {
+	values := []interface{}{v}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		values = make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = rv.Index(i).Interface()
+		}
+	}
+
+	for _, value := range values {
+		var sb strings.Builder
+		err := of.tmpl.Execute(&sb, value)
+		if err != nil {
+			return errors.Wrap(err, "Template execution error")
+		}
+
+		s := sb.String()
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		io.WriteString(w, s)
+	}
+
+	return nil
+}
+
+// writeOutput writes the value to stdout in the format selected with
+// "--output"
+func (cc *ClientCommand) writeOutput(v interface{}) error {
+	of := cc.output
+	if of == nil {
+		of = &outputFormat{kind: outputJSON}
+	}
+
+	return of.write(os.Stdout, v)
+}
+
+// orderedValue converts the value to the form it has in JSON, but with
+// objects as yaml.MapSlice, so that their fields stay in the same order
+func orderedValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize to JSON")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decodeOrdered(dec)
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			arr := []interface{}{}
+			for dec.More() {
+				elem, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, elem)
+			}
+			_, err = dec.Token()
+			return arr, err
+		}
+
+		obj := yaml.MapSlice{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, yaml.MapItem{Key: key, Value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			return i, nil
+		}
+		return tok.Float64()
+	}
+
+	return tok, nil
+}
+
+// marshalOrdered writes an ordered value as compact JSON
+func marshalOrdered(v interface{}) string {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			key, _ := json.Marshal(item.Key)
+			parts[i] = string(key) + ":" + marshalOrdered(item.Value)
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			parts[i] = marshalOrdered(elem)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// scalarString formats a value for a table cell or a line of output; strings
+// are written as they are, and objects and arrays as compact JSON
+func scalarString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case yaml.MapSlice, []interface{}:
+		return marshalOrdered(v)
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// writeTable writes an array as a table with a row for each element, or any
+// other value as a table with one row.  Nested objects are flattened into
+// columns like "CONFIGURATION.CPUS", and arrays of strings or numbers are
+// joined with commas.
+func writeTable(w io.Writer, v interface{}) error {
+	rows, ok := v.([]interface{})
+	if !ok {
+		rows = []interface{}{v}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns := []string{}
+	seen := map[string]bool{}
+	cells := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		cells[i] = map[string]string{}
+		flattenRow("", row, func(column, value string) {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+			cells[i][column] = value
+		})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range cells {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// flattenRow calls add for each column of a table row
+func flattenRow(prefix string, v interface{}, add func(column, value string)) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			column := fmt.Sprintf("%v", item.Key)
+			if prefix != "" {
+				column = prefix + "." + column
+			}
+			flattenRow(column, item.Value, add)
+		}
+		return
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, elem := range v {
+			switch elem.(type) {
+			case yaml.MapSlice, []interface{}:
+				add(prefix, marshalOrdered(v))
+				return
+			}
+			values[i] = scalarString(elem)
+		}
+		add(prefix, strings.Join(values, ","))
+		return
+	}
+
+	if prefix == "" {
+		prefix = "value"
+	}
+	add(prefix, scalarString(v))
+}
+
+// jsonPathStep is one step of a JSONPath expression, like ".name", "[0]", or
+// "[*]".  A recursive step, like "..name", applies to every descendant.
+type jsonPathStep struct {
+	name      string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+// parseJSONPath reads a JSONPath expression, such as "$[*].ref" or
+// "{.configuration.cpus}".  The leading "$" may be omitted.
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	invalid := func(reason string) error {
+		return vcon.InvalidConfigurationError{Message: fmt.Sprintf("jsonpath '%s' is invalid; %s", expr, reason)}
+	}
+
+	s := strings.TrimSpace(expr)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimPrefix(s, "$")
+	if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+
+	steps := []jsonPathStep{}
+	for s != "" {
+		step := jsonPathStep{}
+		if strings.HasPrefix(s, "..") {
+			// "..name" is the name in any descendant, and "..[0]" the index
+			step.recursive = true
+			s = s[1:]
+			if strings.HasPrefix(s, ".[") {
+				s = s[1:]
+			}
+		}
+
+		if s[0] == '.' {
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end == -1 {
+				end = len(s)
+			}
+			step.name = s[:end]
+			s = s[end:]
+			if step.name == "" {
+				return nil, invalid("a name is missing")
+			}
+			step.wildcard = step.name == "*"
+			steps = append(steps, step)
+			continue
+		}
+
+		if s[0] != '[' {
+			return nil, invalid(fmt.Sprintf("unexpected '%s'", s))
+		}
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return nil, invalid("a ']' is missing")
+		}
+		inner := strings.TrimSpace(s[1:end])
+		s = s[end+1:]
+
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			step.name = inner[1 : len(inner)-1]
+		default:
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, invalid(fmt.Sprintf("'[%s]' is not an index, a quoted name, or '*'", inner))
+			}
+			step.index = index
+			step.isIndex = true
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// evaluateJSONPath returns the values selected by the steps, in order
+func evaluateJSONPath(steps []jsonPathStep, root interface{}) []interface{} {
+	nodes := []interface{}{root}
+	for _, step := range steps {
+		if step.recursive {
+			all := []interface{}{}
+			for _, node := range nodes {
+				all = appendDescendants(all, node)
+			}
+			nodes = all
+		}
+
+		next := []interface{}{}
+		for _, node := range nodes {
+			switch node := node.(type) {
+			case yaml.MapSlice:
+				for _, item := range node {
+					if step.wildcard || (!step.isIndex && fmt.Sprintf("%v", item.Key) == step.name) {
+						next = append(next, item.Value)
+					}
+				}
+			case []interface{}:
+				switch {
+				case step.wildcard:
+					next = append(next, node...)
+				case step.isIndex:
+					i := step.index
+					if i < 0 {
+						i += len(node)
+					}
+					if i >= 0 && i < len(node) {
+						next = append(next, node[i])
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+
+	return nodes
+}
+
+// appendDescendants appends the value and all of the values nested in it
+func appendDescendants(nodes []interface{}, v interface{}) []interface{} {
+	nodes = append(nodes, v)
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			nodes = appendDescendants(nodes, item.Value)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			nodes = appendDescendants(nodes, elem)
+		}
+	}
+
+	return nodes
+}
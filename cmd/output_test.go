@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/RallyTools/vcon"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		kind   string
+		output string
+		err    bool
+	}{
+		{format: "", kind: outputJSON},
+		{format: "json", kind: outputJSON},
+		{format: "json-compact", kind: outputJSONCompact},
+		{format: "yaml", kind: outputYAML},
+		{format: "table", kind: outputTable},
+		{format: "template={{ .Ref }}", kind: outputTemplate, output: "vm-1"},
+		{format: "template='{{ .Ref }}'", kind: outputTemplate, output: "vm-1"},
+		{format: `template="{{ .Ref }}"`, kind: outputTemplate, output: "vm-1"},
+		{format: "template={{ .Name }}'s VM", kind: outputTemplate, output: "a's VM"},
+		{format: "template='{{ .Name }}'s VM'", kind: outputTemplate, output: "a's VM"},
+		{format: "template='{{ .Ref }}", kind: outputTemplate, output: "'vm-1"},
+		{format: "template={{ .Ref ", err: true},
+		{format: "jsonpath=$[*].ref", kind: outputJSONPath},
+		{format: "jsonpath=[", err: true},
+		{format: "json=x", err: true},
+		{format: "xml", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			of, err := parseOutputFormat(tt.format, template.FuncMap{})
+			if tt.err {
+				if _, ok := err.(vcon.InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if of.kind != tt.kind {
+				t.Errorf("expected kind %q, got %q", tt.kind, of.kind)
+			}
+			if tt.output != "" {
+				var sb strings.Builder
+				err := of.tmpl.Execute(&sb, struct{ Name, Ref string }{"a", "vm-1"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if sb.String() != tt.output {
+					t.Errorf("expected %q, got %q", tt.output, sb.String())
+				}
+			}
+		})
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr  string
+		steps []jsonPathStep
+		err   bool
+	}{
+		{expr: "", steps: []jsonPathStep{}},
+		{expr: "$", steps: []jsonPathStep{}},
+		{expr: "name", steps: []jsonPathStep{{name: "name"}}},
+		{expr: "$.configuration.cpus", steps: []jsonPathStep{{name: "configuration"}, {name: "cpus"}}},
+		{expr: "{.configuration.cpus}", steps: []jsonPathStep{{name: "configuration"}, {name: "cpus"}}},
+		{expr: "$[*].ref", steps: []jsonPathStep{{wildcard: true}, {name: "ref"}}},
+		{expr: "$.*", steps: []jsonPathStep{{name: "*", wildcard: true}}},
+		{expr: "$[0]", steps: []jsonPathStep{{index: 0, isIndex: true}}},
+		{expr: "$[-1]", steps: []jsonPathStep{{index: -1, isIndex: true}}},
+		{expr: "$['a.b']", steps: []jsonPathStep{{name: "a.b"}}},
+		{expr: `$["a"]`, steps: []jsonPathStep{{name: "a"}}},
+		{expr: "$..ref", steps: []jsonPathStep{{name: "ref", recursive: true}}},
+		{expr: "$..[0]", steps: []jsonPathStep{{index: 0, isIndex: true, recursive: true}}},
+		{expr: "$[0", err: true},
+		{expr: "$[x]", err: true},
+		{expr: "$.", err: true},
+		{expr: "$a", steps: []jsonPathStep{{name: "a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			steps, err := parseJSONPath(tt.expr)
+			if tt.err {
+				if _, ok := err.(vcon.InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(steps, tt.steps) {
+				t.Errorf("expected %+v, got %+v", tt.steps, steps)
+			}
+		})
+	}
+}
+
+func TestEvaluateJSONPath(t *testing.T) {
+	doc, err := orderedValue([]interface{}{
+		map[string]interface{}{"name": "a", "ref": "vm-1", "configuration": map[string]interface{}{"cpus": 2}},
+		map[string]interface{}{"name": "b", "ref": "vm-2", "configuration": map[string]interface{}{"cpus": 4}},
+		map[string]interface{}{"name": "c", "tags": []interface{}{"x", "y"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{expr: "$[*].ref", expected: []string{"vm-1", "vm-2"}},
+		{expr: "$[0].name", expected: []string{"a"}},
+		{expr: "$[-1].name", expected: []string{"c"}},
+		{expr: "$[-3].name", expected: []string{"a"}},
+		{expr: "$[-4].name", expected: []string{}},
+		{expr: "$[3].name", expected: []string{}},
+		{expr: "$[*].configuration.cpus", expected: []string{"2", "4"}},
+		{expr: "$..cpus", expected: []string{"2", "4"}},
+		{expr: "$..tags[-1]", expected: []string{"y"}},
+		{expr: "$[2].tags[*]", expected: []string{"x", "y"}},
+		{expr: "$[2].tags", expected: []string{`["x","y"]`}},
+		{expr: "$[0].configuration", expected: []string{`{"cpus":2}`}},
+		{expr: "$[0].missing", expected: []string{}},
+		{expr: "$.name", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			steps, err := parseJSONPath(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			actual := []string{}
+			for _, node := range evaluateJSONPath(steps, doc) {
+				actual = append(actual, scalarString(node))
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{
+			name: "array of objects",
+			value: []interface{}{
+				map[string]interface{}{"name": "a", "configuration": map[string]interface{}{"cpus": 2}},
+				map[string]interface{}{"name": "bb", "ips": []interface{}{"10.0.0.1", "10.0.0.2"}},
+			},
+			expected: "CONFIGURATION.CPUS  NAME  IPS\n" +
+				"2                   a     \n" +
+				"                    bb    10.0.0.1,10.0.0.2\n",
+		},
+		{
+			name:     "one object",
+			value:    map[string]interface{}{"ref": "vm-1", "powerState": "poweredOn"},
+			expected: "POWERSTATE  REF\npoweredOn   vm-1\n",
+		},
+		{
+			name:     "nested array of objects",
+			value:    map[string]interface{}{"disks": []interface{}{map[string]interface{}{"name": "d"}}},
+			expected: "DISKS\n[{\"name\":\"d\"}]\n",
+		},
+		{
+			name:     "scalar",
+			value:    "vm-1",
+			expected: "VALUE\nvm-1\n",
+		},
+		{
+			name:     "empty array",
+			value:    []interface{}{},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ov, err := orderedValue(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := writeTable(&buf, ov); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	type vm struct {
+		Name string
+		Ref  string
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		value    interface{}
+		expected string
+		err      bool
+	}{
+		{
+			name:     "one value",
+			template: "{{ .Name }} ({{ .Ref }})",
+			value:    vm{Name: "a", Ref: "vm-1"},
+			expected: "a (vm-1)\n",
+		},
+		{
+			name:     "one line per element",
+			template: "{{ .Ref }}",
+			value:    []vm{{Name: "a", Ref: "vm-1"}, {Name: "b", Ref: "vm-2"}},
+			expected: "vm-1\nvm-2\n",
+		},
+		{
+			name:     "trailing newline is kept",
+			template: "{{ .Ref }}\n",
+			value:    []vm{{Ref: "vm-1"}},
+			expected: "vm-1\n",
+		},
+		{
+			name:     "functions",
+			template: "{{ Upper .Name }}",
+			value:    vm{Name: "a"},
+			expected: "A\n",
+		},
+		{
+			name:     "execution error",
+			template: "{{ .Missing }}",
+			value:    vm{Name: "a"},
+			err:      true,
+		},
+	}
+
+	funcs := template.FuncMap{"Upper": strings.ToUpper}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			of, err := parseOutputFormat("template="+tt.template, funcs)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			err = of.writeTemplate(&buf, tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/RallyTools/vcon"
 	"github.com/spf13/cobra"
 )
 
@@ -12,40 +14,70 @@ const (
 	suspend  = "suspend"
 )
 
+const powerLongDescription = `Sets the power state of VMs
+
+The "STATE" argument is "on", "off", or "suspend".  The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+` + targetsHelp
+
 func createPowerCommand() *cobra.Command {
-	targetIsRef := false
+	to := &targetOptions{}
+	waitFor := []string{}
 
-	cc := NewClientCommand("power STATE TARGET", "Sets the power state of a VM")
-	cc.Args = cobra.ExactArgs(2)
+	cc := NewClientCommand("power STATE TARGET...", "Sets the power state of VMs")
+	cc.Long = powerLongDescription
+	cc.Args = cobra.MinimumNArgs(1)
 	cc.ValidArgs = []string{"on", "off", "suspend"}
 
 	cc.RunE = func(_ *cobra.Command, params []string) error {
 		state := params[0]
-		target := params[1]
-
-		vm, err := cc.c.FindVM(target, targetIsRef)
-		if err != nil {
-			return err
+		if len(params) == 1 && to.targetsFrom == "" {
+			return vcon.InvalidConfigurationError{Message: "A TARGET is required"}
 		}
 
-		switch state {
-		case powerOff:
-			err = cc.c.EnsureOff(vm)
-		case powerOn:
-			err = cc.c.EnsureOn(vm)
-		case suspend:
-			err = cc.c.Suspend(vm)
-		default:
-			err = fmt.Errorf("state '%s' is invalid; must be \"on\", \"off\", or \"suspend\"", state)
+		if len(waitFor) != 0 && state != powerOn {
+			return vcon.InvalidConfigurationError{Message: "--wait-for may only be used with \"on\""}
 		}
+		conditions, err := parseWaitConditions(waitFor)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		if state != powerOff && state != powerOn && state != suspend {
+			return vcon.InvalidConfigurationError{
+				Message: fmt.Sprintf("state '%s' is invalid; must be \"on\", \"off\", or \"suspend\"", state),
+			}
+		}
+
+		return cc.forEachTarget(params[1:], to, nil, noTargetResult(func(ctx context.Context, vm *vcon.VirtualMachine) error {
+			var err error
+			switch state {
+			case powerOff:
+				err = cc.c.EnsureOffContext(ctx, vm)
+			case powerOn:
+				err = cc.c.EnsureOnContext(ctx, vm)
+			case suspend:
+				err = cc.c.SuspendContext(ctx, vm)
+			}
+			if err != nil {
+				return err
+			}
+
+			if len(conditions) != 0 {
+				_, err = cc.c.WaitForContext(ctx, vm, conditions)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}))
 	}
+	cc.reportDryRun()
+
+	cc.Flags().StringArrayVar(&waitFor, "wait-for", waitFor, "condition to wait for after powering on, as for \"vcon wait\"; may be repeated")
 
-	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+	addTargetFlags(cc.Flags(), to)
 
 	return &cc.Command
 }
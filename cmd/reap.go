@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const reapLongDescription = `Destroys the VMs in a folder whose leases have expired
+
+VMs get leases when they are cloned with "--ttl".  Each VM with an expired lease is powered off and destroyed, and a JSON array reports what happened to each.  VMs without a lease are never touched.  A failure to destroy one VM does not stop the others from being destroyed.
+
+With "--dry-run", the VMs are only reported.`
+
+func createReapCommand() *cobra.Command {
+	recursive := false
+
+	cc := NewClientCommand("reap [FOLDER]", "Destroys the VMs in a folder whose leases have expired")
+	cc.Long = reapLongDescription
+	cc.Args = cobra.MaximumNArgs(1)
+
+	cc.RunE = func(_ *cobra.Command, params []string) error {
+		folder := ""
+		if len(params) > 0 {
+			folder = params[0]
+		}
+
+		results, err := cc.c.ReapContext(cc.ctx, folder, recursive, viper.GetBool(dryRunKey))
+		if results != nil {
+			if werr := cc.writeToConsole(results); werr != nil && err == nil {
+				err = werr
+			}
+		}
+
+		return err
+	}
+
+	cc.Flags().BoolVarP(&recursive, "recursive", "r", recursive, "includes VMs in subfolders")
+
+	return &cc.Command
+}
@@ -1,45 +1,76 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"fmt"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+)
+
+const relocateLongDescription = `Moves and/or renames the TARGET vm
+
+The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+` + targetsHelp + `  When several VMs are renamed, the name should be a template using {{ Index }}, which counts the VMs from 1; if any name would be given to more than one VM, no VM is changed.`
 
 func createRelocateCommand() *cobra.Command {
 	destination := ""
 	name := ""
-	targetIsRef := false
+	to := &targetOptions{}
 
-	cc := NewClientCommand("relocate TARGET", "Moves and/or renames the TARGET vm")
-	cc.Args = cobra.ExactArgs(1)
+	cc := NewClientCommand("relocate TARGET...", "Moves and/or renames the TARGET vm")
+	cc.Long = relocateLongDescription
 
 	cc.RunE = func(_ *cobra.Command, params []string) error {
-		target := params[0]
+		if len(params) == 0 && to.targetsFrom == "" {
+			return vcon.InvalidConfigurationError{Message: "A TARGET is required"}
+		}
 
 		if name == "" && destination == "" {
 			// There is nothing to do here.
 			return nil
 		}
 
-		vm, err := cc.c.FindVM(target, targetIsRef)
-		if err != nil {
-			return err
-		}
+		// The names are rendered before any VM is changed, so that a template
+		// which gives two VMs the same name changes none of them
+		names := []string{}
+		renderNames := func(count int) error {
+			if name == "" {
+				return nil
+			}
 
-		if name != "" {
-			name = cc.generateVMName(name)
-		}
+			names = make([]string, count)
+			seen := map[string]bool{}
+			for i := range names {
+				cc.withNameIndex(i, func() {
+					names[i] = cc.generateVMName(name)
+				})
+				if seen[names[i]] {
+					return vcon.InvalidConfigurationError{Message: fmt.Sprintf("The name '%s' was generated more than once; use {{ Index }} in the name template", names[i])}
+				}
+				seen[names[i]] = true
+			}
 
-		err = cc.c.Relocate(vm, name, destination)
-		if err != nil {
-			return err
+			return nil
 		}
 
-		return nil
+		return cc.forEachTargetChecked(params, to, nil, renderNames, func(ctx context.Context, index int, vm *vcon.VirtualMachine) (interface{}, error) {
+			newName := ""
+			if name != "" {
+				newName = names[index]
+			}
+
+			return nil, cc.c.RelocateContext(ctx, vm, newName, destination)
+		})
 	}
+	cc.reportDryRun()
 
 	cc.Flags().StringVarP(&destination, destinationKey, "d", destination, "destination folder for VM; if no destination is specified, the VM will not move")
 
 	cc.Flags().StringVarP(&name, nameKey, "n", name, "name of VM; if no name is specified, the name will not change")
 
-	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+	addTargetFlags(cc.Flags(), to)
 
 	return &cc.Command
 }
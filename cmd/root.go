@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/RallyTools/vcon"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -11,23 +15,38 @@ import (
 
 var cfgFile string
 
+// profileErr records a problem with the selected profile, which is reported
+// when a command tries to connect
+var profileErr error
+
 // InitializeCommands sets up the cobra commands
 func InitializeCommands() *cobra.Command {
 	rootCmd := createRootCommand()
 
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, func() {
+		initErrorFormat(rootCmd)
+	})
 	rootCmd.AddCommand(
+		createApplyCommand(),
 		createCloneCommand(),
 		createConfigureCommand(),
+		createCpCommand(),
 		createDestroyCommand(),
+		createExecCommand(),
 		createInfoCommand(),
 		createInitCommand(),
+		createLeaseCommand(),
+		createListCommand(),
+		createLoginCommand(),
+		createLogoutCommand(),
 		createNoteCommand(),
 		createPowerCommand(),
+		createReapCommand(),
 		createRelocateCommand(),
 		createSnapshotCommand(),
 		createTestCommand(),
 		createVersionCommand(),
+		createWaitCommand(),
 	)
 
 	return rootCmd
@@ -42,15 +61,23 @@ func createRootCommand() *cobra.Command {
 		Use:   "vcon",
 		Short: "vcon performs vSphere management tasks",
 		Long:  longRootDescription,
+		// Errors are reported by WriteErrorToConsole
+		SilenceErrors: true,
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.HelpFunc()(cmd, args)
 		},
 	}
 
 	viper.SetEnvPrefix("VCON")
+	// Options like "dry-run" are read from variables like VCON_DRY_RUN
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 
 	cmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.vcon.yaml)")
 
+	cmd.PersistentFlags().String(caFileKey, "", "PEM file of certificate authorities trusted to sign vSphere's certificate")
+	viper.BindEnv(caFileKey)
+	viper.BindPFlag(caFileKey, cmd.PersistentFlags().Lookup(caFileKey))
+
 	cmd.PersistentFlags().String(datacenterKey, "", "vSphere datacenter name")
 	viper.BindEnv(datacenterKey)
 	viper.BindPFlag(datacenterKey, cmd.PersistentFlags().Lookup(datacenterKey))
@@ -59,18 +86,62 @@ func createRootCommand() *cobra.Command {
 	viper.BindEnv(datastoreKey)
 	viper.BindPFlag(datastoreKey, cmd.PersistentFlags().Lookup(datastoreKey))
 
+	cmd.PersistentFlags().Bool(dryRunKey, false, "reports the vSphere requests which would change VMs, as JSON, instead of making them")
+	viper.BindEnv(dryRunKey)
+	viper.BindPFlag(dryRunKey, cmd.PersistentFlags().Lookup(dryRunKey))
+
+	cmd.PersistentFlags().Bool(insecureKey, false, "disables verification of vSphere's certificate")
+	viper.BindEnv(insecureKey)
+	viper.BindPFlag(insecureKey, cmd.PersistentFlags().Lookup(insecureKey))
+
+	cmd.PersistentFlags().Bool(persistSessionKey, true, "keeps the vSphere session for reuse by later commands")
+	viper.BindEnv(persistSessionKey)
+	viper.BindPFlag(persistSessionKey, cmd.PersistentFlags().Lookup(persistSessionKey))
+
 	cmd.PersistentFlags().Bool(promptForPasswordKey, true, "prompts for password when password is not provided")
 	viper.BindEnv(promptForPasswordKey)
 	viper.BindPFlag(promptForPasswordKey, cmd.PersistentFlags().Lookup(promptForPasswordKey))
 
+	cmd.PersistentFlags().String(profileKey, "", "name of the profile in the config file to use, instead of the default profile")
+	viper.BindEnv(profileKey)
+	viper.BindPFlag(profileKey, cmd.PersistentFlags().Lookup(profileKey))
+
+	cmd.PersistentFlags().StringP(outputKey, "o", outputJSON, "format for results; \"json\", \"json-compact\", \"yaml\", \"table\", \"template=TEMPLATE\", or \"jsonpath=EXPRESSION\"")
+	viper.BindEnv(outputKey)
+	viper.BindPFlag(outputKey, cmd.PersistentFlags().Lookup(outputKey))
+
+	cmd.PersistentFlags().String(outputErrorsKey, errorFormatText, "format for reporting errors; \"text\" on stdout, or \"json\" on stderr")
+	viper.BindEnv(outputErrorsKey)
+	viper.BindPFlag(outputErrorsKey, cmd.PersistentFlags().Lookup(outputErrorsKey))
+
 	cmd.PersistentFlags().StringP(passwordKey, "p", "", "vSphere user password")
 	viper.BindEnv(passwordKey)
 	viper.BindPFlag(passwordKey, cmd.PersistentFlags().Lookup(passwordKey))
 
+	cmd.PersistentFlags().String(passwordCommandKey, "", "command which prints the vSphere user password")
+	viper.BindEnv(passwordCommandKey)
+	viper.BindPFlag(passwordCommandKey, cmd.PersistentFlags().Lookup(passwordCommandKey))
+
+	cmd.PersistentFlags().String(passwordFileKey, "", "file which contains the vSphere user password")
+	viper.BindEnv(passwordFileKey)
+	viper.BindPFlag(passwordFileKey, cmd.PersistentFlags().Lookup(passwordFileKey))
+
+	cmd.PersistentFlags().Bool(passwordStdinKey, false, "reads the vSphere user password from the first line of stdin")
+	viper.BindEnv(passwordStdinKey)
+	viper.BindPFlag(passwordStdinKey, cmd.PersistentFlags().Lookup(passwordStdinKey))
+
+	cmd.PersistentFlags().String(thumbprintKey, "", "expected SHA-1 or SHA-256 thumbprint of vSphere's certificate")
+	viper.BindEnv(thumbprintKey)
+	viper.BindPFlag(thumbprintKey, cmd.PersistentFlags().Lookup(thumbprintKey))
+
 	cmd.PersistentFlags().IntP(timeoutKey, "t", 30, "timeout for operations, in seconds")
 	viper.BindEnv(timeoutKey)
 	viper.BindPFlag(timeoutKey, cmd.PersistentFlags().Lookup(timeoutKey))
 
+	cmd.PersistentFlags().Bool(trustOnFirstUseKey, false, "trusts and records an unverified certificate the first time a vSphere instance is seen")
+	viper.BindEnv(trustOnFirstUseKey)
+	viper.BindPFlag(trustOnFirstUseKey, cmd.PersistentFlags().Lookup(trustOnFirstUseKey))
+
 	cmd.PersistentFlags().StringP(usernameKey, "u", "", "vSphere user name")
 	viper.BindEnv(usernameKey)
 	viper.BindPFlag(usernameKey, cmd.PersistentFlags().Lookup(usernameKey))
@@ -82,6 +153,8 @@ func createRootCommand() *cobra.Command {
 	viper.BindEnv(vSphereKey)
 	viper.BindPFlag(vSphereKey, cmd.PersistentFlags().Lookup(vSphereKey))
 
+	rootFlags = cmd.PersistentFlags()
+
 	return cmd
 }
 
@@ -113,4 +186,58 @@ func initConfig() {
 
 	// It may be that the file doesn't exist; that's OK.
 	viper.ReadInConfig()
+
+	profileErr = applyProfile(selectedProfile())
+}
+
+// selectedProfile returns the name of the profile requested with `--profile`
+// or `VCON_PROFILE`, or else the config file's default profile
+func selectedProfile() string {
+	profile := viper.GetString(profileKey)
+	if profile == "" {
+		profile = viper.GetString(defaultProfileKey)
+	}
+	return profile
+}
+
+// applyProfile merges the settings of the named profile over the top-level
+// settings of the config file.  Command line options and environment
+// variables still take precedence over the profile.
+func applyProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	if err := checkProfileName(profile); err != nil {
+		return err
+	}
+
+	sub := viper.Sub(fmt.Sprintf("%s.%s", profilesKey, profile))
+	if sub == nil {
+		return vcon.InvalidConfigurationError{Message: fmt.Sprintf("Profile '%s' is not in the config file", profile)}
+	}
+
+	// JSON is valid YAML, so this suits either kind of config file.
+	b, err := json.Marshal(sub.AllSettings())
+	if err != nil {
+		return vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read profile '%s': %s", profile, err.Error())}
+	}
+
+	err = viper.MergeConfig(bytes.NewReader(b))
+	if err != nil {
+		return vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read profile '%s': %s", profile, err.Error())}
+	}
+
+	return nil
+}
+
+// checkProfileName makes certain that a profile name can be looked up.  The
+// settings are found by a key like "profiles.NAME", so a "." in the name
+// would select a nested key instead.
+func checkProfileName(profile string) error {
+	if strings.Contains(profile, ".") {
+		return vcon.InvalidConfigurationError{Message: fmt.Sprintf("Profile name '%s' is invalid; profile names may not contain '.'", profile)}
+	}
+
+	return nil
 }
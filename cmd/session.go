@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const loginLongDescription = `Logs in to vSphere and keeps the session for later commands
+
+If a session for the user and vSphere instance is already kept and is still valid, it is reused.  Otherwise, vcon logs in, prompting for the password if necessary.  Later commands reuse the session without needing the password until it expires or "vcon logout" is run.`
+
+func createLoginCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Logs in to vSphere and keeps the session for later commands",
+		Long:  loginLongDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := cancelOnInterrupt(context.Background())
+
+			_, err := connect(ctx, true)
+			if err != nil {
+				return vcon.ConnectionError{Err: err}
+			}
+
+			if viper.GetBool(verboseKey) {
+				fmt.Printf("OK\n")
+			}
+
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func createLogoutCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logout",
+		Short: "Ends the kept vSphere session, if any",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := cancelOnInterrupt(context.Background())
+
+			opts, err := clientOptions(true)
+			if err != nil {
+				return err
+			}
+
+			err = vcon.EndSession(ctx, opts)
+			if err != nil {
+				return err
+			}
+
+			if viper.GetBool(verboseKey) {
+				fmt.Printf("OK\n")
+			}
+
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -1,15 +1,13 @@
 package cmd
 
 import (
-	"errors"
-
 	"github.com/RallyTools/vcon"
 	"github.com/spf13/cobra"
 )
 
 func createSnapshotCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "snapshot [create|remove|revert]",
+		Use:   "snapshot [create|list|remove|revert]",
 		Short: "EXPERIMENTAL: Manipulates snapshots for a VM",
 	}
 
@@ -33,22 +31,22 @@ func createSnapshotCreateCommand() *cobra.Command {
 	cc.RunE = func(_ *cobra.Command, params []string) error {
 		target := params[0]
 
-		vm, err := cc.c.FindVM(target, targetIsRef)
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
 		if err != nil {
 			return err
 		}
 
 		// Should check that we are powered off.
-		ps, err := cc.c.GetPowerState(vm)
+		ps, err := cc.c.GetPowerStateContext(cc.ctx, vm)
 		if err != nil {
 			return err
 		}
 		if ps != vcon.PoweredOff {
-			return errors.New("Cannot get a snapshot of a running machine")
+			return vcon.InvalidPowerStateError{Expected: vcon.PoweredOff, Actual: ps}
 		}
 
 		name := cc.generateSnapshotName(name)
-		mo, err := cc.c.SnapshotCreate(vm, name)
+		mo, err := cc.c.SnapshotCreateContext(cc.ctx, vm, name)
 		if err != nil {
 			return err
 		}
@@ -58,6 +56,7 @@ func createSnapshotCreateCommand() *cobra.Command {
 
 		return nil
 	}
+	cc.reportDryRun()
 
 	cc.Flags().StringVarP(&name, nameKey, "n", name, "name of new snapshot; if no name is specified, one will be generated.")
 	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
@@ -74,14 +73,17 @@ func createSnapshotListCommand() *cobra.Command {
 	cc.RunE = func(_ *cobra.Command, params []string) error {
 		target := params[0]
 
-		vm, err := cc.c.FindVM(target, targetIsRef, "snapshot")
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef, "snapshot")
 		if err != nil {
 			return err
 		}
 
-		cc.c.SnapshotList(vm)
+		snapshots, err := cc.c.SnapshotListContext(cc.ctx, vm)
+		if err != nil {
+			return err
+		}
 
-		return nil
+		return cc.writeSnapshotListToConsole(snapshots)
 	}
 
 	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
@@ -110,23 +112,23 @@ func createSnapshotRemoveCommand() *cobra.Command {
 			snapshot = params[1]
 		}
 
-		vm, err := cc.c.FindVM(target, targetIsRef)
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
 		if err != nil {
 			return err
 		}
 
 		if snapshot != "" {
-			moRef, err := cc.c.FindSnapshot(vm, snapshot, snapshotIsRef)
+			moRef, err := cc.c.FindSnapshotContext(cc.ctx, vm, snapshot, snapshotIsRef)
 			if err != nil {
 				return err
 			}
 
-			err = cc.c.SnapshotRemove(vm, moRef)
+			err = cc.c.SnapshotRemoveContext(cc.ctx, vm, moRef)
 			if err != nil {
 				return err
 			}
 		} else {
-			err = cc.c.SnapshotRemoveAll(vm)
+			err = cc.c.SnapshotRemoveAllContext(cc.ctx, vm)
 			if err != nil {
 				return err
 			}
@@ -134,6 +136,7 @@ func createSnapshotRemoveCommand() *cobra.Command {
 
 		return nil
 	}
+	cc.reportDryRun()
 
 	cc.Flags().BoolVar(&snapshotIsRef, "snapshotIsRef", snapshotIsRef, "SNAPSHOT parameter is the snapshot's uuid")
 	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
@@ -157,21 +160,21 @@ func createSnapshotRevertCommand() *cobra.Command {
 	cc.RunE = func(_ *cobra.Command, params []string) error {
 		target := params[0]
 
-		vm, err := cc.c.FindVM(target, targetIsRef)
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
 		if err != nil {
 			return err
 		}
 
 		if len(params) == 1 {
-			err = cc.c.SnapshotRevert(vm)
+			err = cc.c.SnapshotRevertContext(cc.ctx, vm)
 		} else {
 			name := params[1]
-			snapshot, err := cc.c.FindSnapshot(vm, name, snapshotIsRef)
+			snapshot, err := cc.c.FindSnapshotContext(cc.ctx, vm, name, snapshotIsRef)
 			if err != nil {
 				return err
 			}
 
-			err = cc.c.SnapshotRevertTo(vm, snapshot)
+			err = cc.c.SnapshotRevertToContext(cc.ctx, vm, snapshot)
 		}
 		if err != nil {
 			return err
@@ -179,6 +182,7 @@ func createSnapshotRevertCommand() *cobra.Command {
 
 		return nil
 	}
+	cc.reportDryRun()
 
 	cc.Flags().BoolVar(&snapshotIsRef, "snapshotIsRef", snapshotIsRef, "SNAPSHOT parameter is the snapshot's uuid")
 	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/pflag"
+)
+
+// Help for commands which accept several targets, for their long descriptions
+const (
+	targetsHelp = `Several VMs may be given at once.  A TARGET may be a glob pattern, such as "/Engineering/TeamSharks/temporary VMs/ci-*", and with "--targets-from", targets are also read from a file, or from stdin with "-".  ` + targetsFromHelp
+
+	targetsFromHelp = `The file of targets may have one ref or path per line (a line like "vm-139" is taken as a ref), or it may be the JSON array reported by another vcon command, such as "list", in which case each element's "ref" is used, or else its "path".  Up to "--parallel" VMs are handled at once, with one vSphere session, and the outcome for each VM is reported in a JSON array; if any fails, vcon exits with the code for the first failure.`
+)
+
+// refPattern matches the refs of VMs, i.e., "vm-139"
+var refPattern = regexp.MustCompile(`^vm-[0-9]+$`)
+
+// targetOptions are the flags which select the VMs for a command which
+// accepts several targets
+type targetOptions struct {
+	targetIsRef bool
+	targetsFrom string
+	parallel    int
+}
+
+// addTargetFlags adds the flags for selecting several VMs
+func addTargetFlags(flags *pflag.FlagSet, to *targetOptions) {
+	to.parallel = 4
+	flags.BoolVar(&to.targetIsRef, "targetIsRef", to.targetIsRef, "TARGET parameter is the target VM's uuid")
+	flags.StringVar(&to.targetsFrom, "targets-from", to.targetsFrom, "file of more targets, one per line or as a JSON array; \"-\" reads stdin")
+	flags.IntVar(&to.parallel, "parallel", to.parallel, "number of VMs to handle at once, when there are several targets")
+}
+
+// targetReport is the outcome for one target, when there are several
+type targetReport struct {
+	Target string      `json:"target"`
+	Ref    string      `json:"ref,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// forEachTarget finds the VM for each target, with the requested properties,
+// and calls fn with it.  When there is only one target, named without a
+// pattern, the value returned by fn (if any) is written as usual.  Otherwise
+// the outcome for each target is written as a JSON array, and the first
+// failure is returned.
+func (cc *ClientCommand) forEachTarget(params []string, to *targetOptions, properties []string, fn vcon.VMFunc) error {
+	return cc.forEachTargetChecked(params, to, properties, nil, fn)
+}
+
+// forEachTargetChecked is like forEachTarget, but once the targets have been
+// expanded, check (if set) is called with the number of VMs, so that it may
+// refuse them before any VM is changed
+func (cc *ClientCommand) forEachTargetChecked(params []string, to *targetOptions, properties []string, check func(count int) error, fn vcon.VMFunc) error {
+	targets := make([]vcon.VMTarget, len(params))
+	for i, param := range params {
+		targets[i] = vcon.VMTarget{Path: param, IsRef: to.targetIsRef}
+	}
+
+	if to.targetsFrom != "" {
+		more, err := readTargets(to.targetsFrom, to.targetIsRef)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, more...)
+	}
+
+	expanded, err := cc.c.ExpandTargetsContext(cc.ctx, targets)
+	if err != nil {
+		return err
+	}
+
+	if check != nil {
+		if err := check(len(expanded)); err != nil {
+			return err
+		}
+	}
+
+	if to.targetsFrom == "" && len(targets) == 1 && len(expanded) == 1 && expanded[0] == targets[0] {
+		vm, err := cc.c.FindVMContext(cc.ctx, targets[0].Path, targets[0].IsRef, properties...)
+		if err != nil {
+			return err
+		}
+
+		v, err := fn(cc.ctx, 0, vm)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return nil
+		}
+		return cc.writeToConsole(v)
+	}
+
+	results, err := cc.c.ForEachVMContext(cc.ctx, expanded, to.parallel, properties, fn)
+
+	reports := make([]targetReport, len(results))
+	for i, r := range results {
+		reports[i] = targetReport{
+			Target: r.Target.Path,
+			Result: r.Value,
+		}
+		if r.VM != nil {
+			reports[i].Ref = r.VM.Ref.Value
+		}
+		if r.Err != nil {
+			reports[i].Error = r.Err.Error()
+		}
+	}
+
+	if werr := cc.writeToConsole(reports); werr != nil && err == nil {
+		err = werr
+	}
+
+	return err
+}
+
+// noTargetResult adapts a function which reports nothing to a vcon.VMFunc
+func noTargetResult(fn func(ctx context.Context, vm *vcon.VirtualMachine) error) vcon.VMFunc {
+	return func(ctx context.Context, _ int, vm *vcon.VirtualMachine) (interface{}, error) {
+		return nil, fn(ctx, vm)
+	}
+}
+
+// readTargets reads the targets in a file, or in stdin if the file is "-"
+func readTargets(file string, targetIsRef bool) ([]vcon.VMTarget, error) {
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read targets from '%s': %s", file, err.Error())}
+	}
+
+	content := strings.TrimSpace(string(b))
+	if strings.HasPrefix(content, "[") {
+		return parseTargetsJSON(file, []byte(content), targetIsRef)
+	}
+
+	targets := []vcon.VMTarget{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, vcon.VMTarget{
+			Path:  line,
+			IsRef: targetIsRef || refPattern.MatchString(line),
+		})
+	}
+
+	return targets, nil
+}
+
+// parseTargetsJSON reads a JSON array of refs or paths, or of objects with a
+// "ref" or "path", such as the output of `list` or `clone --count`
+func parseTargetsJSON(file string, b []byte, targetIsRef bool) ([]vcon.VMTarget, error) {
+	elements := []json.RawMessage{}
+	err := json.Unmarshal(b, &elements)
+	if err != nil {
+		return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read targets from '%s': %s", file, err.Error())}
+	}
+
+	targets := make([]vcon.VMTarget, 0, len(elements))
+	for i, element := range elements {
+		var s string
+		if json.Unmarshal(element, &s) == nil {
+			targets = append(targets, vcon.VMTarget{Path: s, IsRef: targetIsRef || refPattern.MatchString(s)})
+			continue
+		}
+
+		var obj struct {
+			Ref  string `json:"ref"`
+			Path string `json:"path"`
+		}
+		err := json.Unmarshal(element, &obj)
+		switch {
+		case err != nil:
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Failed to read target %d from '%s': %s", i+1, file, err.Error())}
+		case obj.Ref != "":
+			targets = append(targets, vcon.VMTarget{Path: obj.Ref, IsRef: true})
+		case obj.Path != "":
+			targets = append(targets, vcon.VMTarget{Path: obj.Path})
+		default:
+			return nil, vcon.InvalidConfigurationError{Message: fmt.Sprintf("Target %d from '%s' has no ref or path", i+1, file)}
+		}
+	}
+
+	return targets, nil
+}
+
+// splitTargetParams separates the TARGET from the other arguments of a
+// command like `note TARGET [NOTES]`, which reads the other argument from
+// stdin when it is missing.  With "--targets-from", there is no TARGET.
+func splitTargetParams(params []string, to *targetOptions) ([]string, []string, error) {
+	if to.targetsFrom == "" {
+		if len(params) == 0 {
+			return nil, nil, vcon.InvalidConfigurationError{Message: "A TARGET is required"}
+		}
+		return params[:1], params[1:], nil
+	}
+
+	if len(params) > 1 {
+		return nil, nil, vcon.InvalidConfigurationError{Message: "A TARGET may not be given with --targets-from"}
+	}
+	if len(params) == 0 && to.targetsFrom == "-" {
+		return nil, nil, vcon.InvalidConfigurationError{Message: "The targets and the input cannot both be read from stdin"}
+	}
+	return nil, params, nil
+}
+
+// withNameIndex calls fn with the Index of the name templates set for the
+// target at the index.  The templates are shared, so the VMs' names are
+// rendered one at a time.
+func (cc *ClientCommand) withNameIndex(index int, fn func()) {
+	cc.nameMu.Lock()
+	defer cc.nameMu.Unlock()
+
+	cc.nameIndex = index + 1
+	fn()
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RallyTools/vcon"
+)
+
+func TestParseTargetsJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		targetIsRef bool
+		expected    []vcon.VMTarget
+		err         bool
+	}{
+		{
+			name:     "empty",
+			json:     `[]`,
+			expected: []vcon.VMTarget{},
+		},
+		{
+			name: "strings",
+			json: `["vm-139", "/Engineering/web-1"]`,
+			expected: []vcon.VMTarget{
+				{Path: "vm-139", IsRef: true},
+				{Path: "/Engineering/web-1"},
+			},
+		},
+		{
+			name:        "strings are refs",
+			json:        `["vm-139", "5003a7c2"]`,
+			targetIsRef: true,
+			expected: []vcon.VMTarget{
+				{Path: "vm-139", IsRef: true},
+				{Path: "5003a7c2", IsRef: true},
+			},
+		},
+		{
+			name: "objects",
+			json: `[{"name": "web-1", "ref": "vm-139", "path": "/Engineering/web-1"}, {"path": "/Engineering/web-2"}]`,
+			expected: []vcon.VMTarget{
+				{Path: "vm-139", IsRef: true},
+				{Path: "/Engineering/web-2"},
+			},
+		},
+		{
+			name:     "mixed",
+			json:     `["web-1", {"ref": "vm-140"}]`,
+			expected: []vcon.VMTarget{{Path: "web-1"}, {Path: "vm-140", IsRef: true}},
+		},
+		{name: "not an array", json: `{"ref": "vm-139"}`, err: true},
+		{name: "not JSON", json: `vm-139`, err: true},
+		{name: "no ref or path", json: `[{"name": "web-1"}]`, err: true},
+		{name: "number", json: `[139]`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := parseTargetsJSON("targets.json", []byte(tt.json), tt.targetIsRef)
+			if tt.err {
+				if _, ok := err.(vcon.InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(targets, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, targets)
+			}
+		})
+	}
+}
+
+func TestSplitTargetParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      []string
+		targetsFrom string
+		targets     []string
+		rest        []string
+		err         bool
+	}{
+		{name: "target", params: []string{"web-1"}, targets: []string{"web-1"}, rest: []string{}},
+		{name: "target and notes", params: []string{"web-1", "notes"}, targets: []string{"web-1"}, rest: []string{"notes"}},
+		{name: "no target", params: []string{}, err: true},
+		{name: "targets from a file", params: []string{}, targetsFrom: "targets.txt", rest: []string{}},
+		{name: "targets from a file, and notes", params: []string{"notes"}, targetsFrom: "targets.txt", rest: []string{"notes"}},
+		{name: "target with targets from a file", params: []string{"web-1", "notes"}, targetsFrom: "targets.txt", err: true},
+		{name: "targets and notes from stdin", params: []string{}, targetsFrom: "-", err: true},
+		{name: "targets from stdin, and notes", params: []string{"notes"}, targetsFrom: "-", rest: []string{"notes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, rest, err := splitTargetParams(tt.params, &targetOptions{targetsFrom: tt.targetsFrom})
+			if tt.err {
+				if _, ok := err.(vcon.InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(targets) != len(tt.targets) || (len(targets) > 0 && !reflect.DeepEqual(targets, tt.targets)) {
+				t.Errorf("expected the targets %q, got %q", tt.targets, targets)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("expected the arguments %q, got %q", tt.rest, rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"github.com/RallyTools/vcon"
+	"github.com/spf13/cobra"
+)
+
+const waitLongDescription = `Waits until a VM is ready
+
+The "TARGET" argument is a path to the VM.  If the "--targetIsRef" flag is set, the TARGET should be the Mananged Object Reference for the VM.
+
+Each CONDITION is waited for in turn; if none is given, vcon waits for an IP address.  The conditions are:
+
+  tools-running               VMware Tools is running in the guest
+  ip                          the guest has an IP address
+  ip-on-network=NETWORK       the guest has an IP address on the named network
+  tcp:PORT                    the port accepts connections on the guest's IP address
+  http://{{ip}}:PORT/PATH     a GET of the URL succeeds; {{ip}} is the guest's IP address
+  guestinfo.KEY=VALUE         the guestinfo key has the value in the VM's extraConfig
+
+Values the guest sets itself, i.e., with vmware-rpctool, are generally not reported in the extraConfig, so a guestinfo condition cannot see them.
+
+A condition may end with "@DURATION", i.e., "tcp:22@5m", to wait for it longer than the timeout.  A JSON array reports when each condition was satisfied; if one is not, the array ends with its error, and vcon exits with the code for that error.
+`
+
+func createWaitCommand() *cobra.Command {
+	targetIsRef := false
+
+	cc := NewClientCommand("wait TARGET [CONDITION...]", "Waits until a VM is ready")
+	cc.Args = cobra.MinimumNArgs(1)
+	cc.Long = waitLongDescription
+
+	cc.RunE = func(_ *cobra.Command, params []string) error {
+		target := params[0]
+
+		specs := params[1:]
+		if len(specs) == 0 {
+			specs = []string{vcon.WaitIP}
+		}
+		conditions, err := parseWaitConditions(specs)
+		if err != nil {
+			return err
+		}
+
+		vm, err := cc.c.FindVMContext(cc.ctx, target, targetIsRef)
+		if err != nil {
+			return err
+		}
+
+		results, err := cc.c.WaitForContext(cc.ctx, vm, conditions)
+		if results == nil {
+			return err
+		}
+
+		if werr := cc.writeToConsole(results); werr != nil && err == nil {
+			err = werr
+		}
+
+		return err
+	}
+
+	cc.Flags().BoolVar(&targetIsRef, "targetIsRef", targetIsRef, "TARGET parameter is the target VM's uuid")
+
+	return &cc.Command
+}
+
+// parseWaitConditions reads the conditions given to `wait` or `--wait-for`
+func parseWaitConditions(specs []string) ([]*vcon.WaitCondition, error) {
+	conditions := make([]*vcon.WaitCondition, len(specs))
+	for i, spec := range specs {
+		wc, err := vcon.ParseWaitCondition(spec)
+		if err != nil {
+			return nil, err
+		}
+		conditions[i] = wc
+	}
+
+	return conditions, nil
+}
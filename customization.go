@@ -0,0 +1,222 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Customization describes how the guest OS is prepared when the VM is next
+// powered on; its host name, network settings, and identity.  Either a spec
+// saved in vCenter is named by SpecName, or the spec is built from the other
+// fields.
+type Customization struct {
+	// SpecName is the name of a customization spec saved in vCenter.  Only
+	// Hostname may be combined with it, to override the saved host name.
+	SpecName string `json:"specName,omitempty"`
+
+	// Hostname is the guest's host name.  If it is empty, the VM's name is
+	// used, which must then be a valid host name.
+	Hostname string `json:"hostname,omitempty"`
+
+	// Domain is the guest's DNS domain
+	Domain string `json:"domain,omitempty"`
+
+	// TimeZone is a Linux time zone, such as "America/Denver"
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// DNSServers and DNSSuffixes apply to all network adapters
+	DNSServers  []string `json:"dnsServers,omitempty"`
+	DNSSuffixes []string `json:"dnsSuffixes,omitempty"`
+
+	// NICs configure the VM's network adapters, in order.  Adapters without
+	// settings use DHCP.
+	NICs []CustomizationNIC `json:"nics,omitempty"`
+
+	// Windows is the sysprep identity for Windows guests.  If it is not set,
+	// the guest is customized as Linux.
+	Windows *WindowsIdentity `json:"windows,omitempty"`
+}
+
+// CustomizationNIC configures one network adapter.  If IP is empty, DHCP is
+// used.
+type CustomizationNIC struct {
+	IP       string   `json:"ip,omitempty"`
+	Netmask  string   `json:"netmask,omitempty"`
+	Gateways []string `json:"gateways,omitempty"`
+}
+
+// WindowsIdentity describes a Windows guest for sysprep
+type WindowsIdentity struct {
+	FullName      string `json:"fullName"`
+	OrgName       string `json:"orgName"`
+	ProductKey    string `json:"productKey,omitempty"`
+	AdminPassword string `json:"adminPassword,omitempty"`
+
+	// TimeZone is a Windows time zone index, such as 4 for Pacific time
+	TimeZone int32 `json:"timeZone,omitempty"`
+
+	// Either Workgroup or JoinDomain should be set; joining a domain needs
+	// the domain administrator's credentials
+	Workgroup           string `json:"workgroup,omitempty"`
+	JoinDomain          string `json:"joinDomain,omitempty"`
+	DomainAdmin         string `json:"domainAdmin,omitempty"`
+	DomainAdminPassword string `json:"domainAdminPassword,omitempty"`
+}
+
+// customize applies the customization to the VM.  It takes effect when the VM
+// is next powered on, so the VM should be powered off.
+func (c *Client) customize(ctx context.Context, vm *VirtualMachine, cust *Customization) error {
+	if c.Verbose {
+		fmt.Printf("Customizing guest...\n")
+	}
+
+	devices, err := vm.VM.Device(ctx)
+	if err = c.checkErr(ctx, err); err != nil {
+		return errors.Wrapf(err, "While getting devices")
+	}
+
+	spec, err := c.customizationSpec(ctx, cust, devices)
+	if err != nil {
+		return err
+	}
+
+	if c.dryRun("CustomizeVM_Task", vm.Ref, types.CustomizeVM_Task{This: vm.Ref, Spec: *spec}) {
+		return nil
+	}
+
+	task, err := vm.VM.Customize(ctx, *spec)
+	_, err = c.finishTask(ctx, task, err)
+	if err != nil {
+		return errors.Wrapf(err, "While customizing guest")
+	}
+
+	return nil
+}
+
+// customizationSpec builds the spec for a VM with the given devices, either
+// from the saved spec named by the customization, or from its settings
+func (c *Client) customizationSpec(ctx context.Context, cust *Customization, devices object.VirtualDeviceList) (*types.CustomizationSpec, error) {
+	if cust.SpecName != "" {
+		spec, err := c.savedCustomizationSpec(ctx, cust.SpecName)
+		if err != nil {
+			return nil, err
+		}
+
+		if cust.Hostname != "" {
+			setCustomizationHostname(spec, cust.Hostname)
+		}
+		return spec, nil
+	}
+
+	nicCount := len(devices.SelectByType((*types.VirtualEthernetCard)(nil)))
+	return buildCustomizationSpec(cust, nicCount)
+}
+
+func (c *Client) savedCustomizationSpec(ctx context.Context, name string) (*types.CustomizationSpec, error) {
+	csm := object.NewCustomizationSpecManager(c.Client.Client)
+
+	exists, err := csm.DoesCustomizationSpecExist(ctx, name)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, errors.Wrapf(err, "While finding customization spec '%s'", name)
+	}
+	if !exists {
+		return nil, NotFoundError{Kind: "customization spec", Path: name}
+	}
+
+	item, err := csm.GetCustomizationSpec(ctx, name)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, errors.Wrapf(err, "While getting customization spec '%s'", name)
+	}
+
+	return &item.Spec, nil
+}
+
+// buildCustomizationSpec makes a spec for a VM with the given number of
+// network adapters.  vSphere requires settings for every adapter.
+func buildCustomizationSpec(cust *Customization, nicCount int) (*types.CustomizationSpec, error) {
+	if len(cust.NICs) > nicCount {
+		return nil, InvalidConfigurationError{
+			Message: fmt.Sprintf("Customization has settings for %d network adapters, but the VM has %d", len(cust.NICs), nicCount),
+		}
+	}
+
+	var hostname types.BaseCustomizationName = &types.CustomizationVirtualMachineName{}
+	if cust.Hostname != "" {
+		hostname = &types.CustomizationFixedName{Name: cust.Hostname}
+	}
+
+	spec := &types.CustomizationSpec{
+		GlobalIPSettings: types.CustomizationGlobalIPSettings{
+			DnsServerList: cust.DNSServers,
+			DnsSuffixList: cust.DNSSuffixes,
+		},
+	}
+
+	for i := 0; i < nicCount; i++ {
+		settings := types.CustomizationIPSettings{
+			Ip: &types.CustomizationDhcpIpGenerator{},
+		}
+		if i < len(cust.NICs) && cust.NICs[i].IP != "" {
+			nic := cust.NICs[i]
+			settings.Ip = &types.CustomizationFixedIp{IpAddress: nic.IP}
+			settings.SubnetMask = nic.Netmask
+			settings.Gateway = nic.Gateways
+		}
+		if cust.Windows != nil {
+			// Windows only takes DNS settings per adapter
+			settings.DnsServerList = cust.DNSServers
+			settings.DnsDomain = cust.Domain
+		}
+
+		spec.NicSettingMap = append(spec.NicSettingMap, types.CustomizationAdapterMapping{Adapter: settings})
+	}
+
+	if w := cust.Windows; w != nil {
+		sysprep := &types.CustomizationSysprep{
+			GuiUnattended: types.CustomizationGuiUnattended{
+				TimeZone: w.TimeZone,
+			},
+			UserData: types.CustomizationUserData{
+				FullName:     w.FullName,
+				OrgName:      w.OrgName,
+				ComputerName: hostname,
+				ProductId:    w.ProductKey,
+			},
+			Identification: types.CustomizationIdentification{
+				JoinWorkgroup: w.Workgroup,
+				JoinDomain:    w.JoinDomain,
+				DomainAdmin:   w.DomainAdmin,
+			},
+		}
+		if w.AdminPassword != "" {
+			sysprep.GuiUnattended.Password = &types.CustomizationPassword{Value: w.AdminPassword, PlainText: true}
+		}
+		if w.DomainAdminPassword != "" {
+			sysprep.Identification.DomainAdminPassword = &types.CustomizationPassword{Value: w.DomainAdminPassword, PlainText: true}
+		}
+		spec.Identity = sysprep
+	} else {
+		spec.Identity = &types.CustomizationLinuxPrep{
+			HostName: hostname,
+			Domain:   cust.Domain,
+			TimeZone: cust.TimeZone,
+		}
+	}
+
+	return spec, nil
+}
+
+// setCustomizationHostname replaces the host name in a saved spec
+func setCustomizationHostname(spec *types.CustomizationSpec, hostname string) {
+	name := &types.CustomizationFixedName{Name: hostname}
+	switch identity := spec.Identity.(type) {
+	case *types.CustomizationLinuxPrep:
+		identity.HostName = name
+	case *types.CustomizationSysprep:
+		identity.UserData.ComputerName = name
+	}
+}
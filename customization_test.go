@@ -0,0 +1,165 @@
+package vcon
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestBuildCustomizationSpec(t *testing.T) {
+	dhcp := types.CustomizationAdapterMapping{
+		Adapter: types.CustomizationIPSettings{Ip: &types.CustomizationDhcpIpGenerator{}},
+	}
+
+	tests := []struct {
+		name     string
+		cust     Customization
+		nicCount int
+		expected *types.CustomizationSpec
+		err      bool
+	}{
+		{
+			name:     "defaults",
+			cust:     Customization{},
+			nicCount: 1,
+			expected: &types.CustomizationSpec{
+				Identity:      &types.CustomizationLinuxPrep{HostName: &types.CustomizationVirtualMachineName{}},
+				NicSettingMap: []types.CustomizationAdapterMapping{dhcp},
+			},
+		},
+		{
+			name: "linux",
+			cust: Customization{
+				Hostname:    "web",
+				Domain:      "example.com",
+				TimeZone:    "America/Denver",
+				DNSServers:  []string{"10.0.0.2"},
+				DNSSuffixes: []string{"example.com"},
+				NICs:        []CustomizationNIC{{IP: "10.0.0.10", Netmask: "255.255.255.0", Gateways: []string{"10.0.0.1"}}},
+			},
+			nicCount: 2,
+			expected: &types.CustomizationSpec{
+				Identity: &types.CustomizationLinuxPrep{
+					HostName: &types.CustomizationFixedName{Name: "web"},
+					Domain:   "example.com",
+					TimeZone: "America/Denver",
+				},
+				GlobalIPSettings: types.CustomizationGlobalIPSettings{
+					DnsServerList: []string{"10.0.0.2"},
+					DnsSuffixList: []string{"example.com"},
+				},
+				NicSettingMap: []types.CustomizationAdapterMapping{
+					{
+						Adapter: types.CustomizationIPSettings{
+							Ip:         &types.CustomizationFixedIp{IpAddress: "10.0.0.10"},
+							SubnetMask: "255.255.255.0",
+							Gateway:    []string{"10.0.0.1"},
+						},
+					},
+					dhcp,
+				},
+			},
+		},
+		{
+			name:     "adapter without an IP",
+			cust:     Customization{NICs: []CustomizationNIC{{Netmask: "255.255.255.0"}}},
+			nicCount: 1,
+			expected: &types.CustomizationSpec{
+				Identity:      &types.CustomizationLinuxPrep{HostName: &types.CustomizationVirtualMachineName{}},
+				NicSettingMap: []types.CustomizationAdapterMapping{dhcp},
+			},
+		},
+		{
+			name: "windows",
+			cust: Customization{
+				Hostname:   "web",
+				Domain:     "example.com",
+				DNSServers: []string{"10.0.0.2"},
+				Windows: &WindowsIdentity{
+					FullName:            "Admin",
+					OrgName:             "Example",
+					ProductKey:          "KEY",
+					AdminPassword:       "secret",
+					TimeZone:            4,
+					JoinDomain:          "corp",
+					DomainAdmin:         "corp\\admin",
+					DomainAdminPassword: "domain-secret",
+				},
+			},
+			nicCount: 1,
+			expected: &types.CustomizationSpec{
+				Identity: &types.CustomizationSysprep{
+					GuiUnattended: types.CustomizationGuiUnattended{
+						TimeZone: 4,
+						Password: &types.CustomizationPassword{Value: "secret", PlainText: true},
+					},
+					UserData: types.CustomizationUserData{
+						FullName:     "Admin",
+						OrgName:      "Example",
+						ComputerName: &types.CustomizationFixedName{Name: "web"},
+						ProductId:    "KEY",
+					},
+					Identification: types.CustomizationIdentification{
+						JoinDomain:          "corp",
+						DomainAdmin:         "corp\\admin",
+						DomainAdminPassword: &types.CustomizationPassword{Value: "domain-secret", PlainText: true},
+					},
+				},
+				GlobalIPSettings: types.CustomizationGlobalIPSettings{
+					DnsServerList: []string{"10.0.0.2"},
+				},
+				NicSettingMap: []types.CustomizationAdapterMapping{
+					{
+						Adapter: types.CustomizationIPSettings{
+							Ip:            &types.CustomizationDhcpIpGenerator{},
+							DnsServerList: []string{"10.0.0.2"},
+							DnsDomain:     "example.com",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "windows workgroup",
+			cust: Customization{
+				Windows: &WindowsIdentity{FullName: "Admin", OrgName: "Example", Workgroup: "WORKGROUP"},
+			},
+			nicCount: 0,
+			expected: &types.CustomizationSpec{
+				Identity: &types.CustomizationSysprep{
+					UserData: types.CustomizationUserData{
+						FullName:     "Admin",
+						OrgName:      "Example",
+						ComputerName: &types.CustomizationVirtualMachineName{},
+					},
+					Identification: types.CustomizationIdentification{JoinWorkgroup: "WORKGROUP"},
+				},
+			},
+		},
+		{
+			name:     "too many adapters",
+			cust:     Customization{NICs: []CustomizationNIC{{IP: "10.0.0.10"}, {IP: "10.0.0.11"}}},
+			nicCount: 1,
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := buildCustomizationSpec(&tt.cust, tt.nicCount)
+			if tt.err {
+				if _, ok := err.(InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Provisioning types for new disks
+const (
+	ProvisioningThin             = "thin"
+	ProvisioningThick            = "thick"
+	ProvisioningEagerZeroedThick = "eagerZeroedThick"
+)
+
+// Disk describes one of a VM's virtual disks.  When configuring a VM, a disk
+// with a Name changes that existing disk, and a disk without one is added.
+type Disk struct {
+	// Name identifies an existing disk by its controller and unit, such as
+	// "disk-1000-0", as reported by `info`
+	Name string `json:"name,omitempty"`
+
+	// SizeMB is the disk's capacity.  An existing disk can only grow.
+	SizeMB int64 `json:"sizeMB,omitempty"`
+
+	// Controller is the controller for a new disk; either a kind ("scsi",
+	// "ide", or "nvme") or a controller's name, such as "pvscsi-1000".  The
+	// first SCSI controller is used by default.
+	Controller string `json:"controller,omitempty"`
+
+	// Provisioning is "thin" (the default for new disks), "thick", or
+	// "eagerZeroedThick"
+	Provisioning string `json:"provisioning,omitempty"`
+
+	// Datastore holds a new disk's file; the client's data store is used by
+	// default
+	Datastore string `json:"datastore,omitempty"`
+
+	// Mode is a vSphere disk mode, such as "persistent" (the default for new
+	// disks) or "independent_nonpersistent"
+	Mode string `json:"mode,omitempty"`
+
+	// File is the disk's backing file path; it is only reported
+	File string `json:"file,omitempty"`
+
+	// Remove detaches the existing disk and deletes its file
+	Remove bool `json:"remove,omitempty"`
+}
+
+// diskChanges builds the device changes which apply the disk configuration to
+// the VM.  The devices are returned with the new disks added.
+func (c *Client) diskChanges(ctx context.Context, devices object.VirtualDeviceList, disks []Disk) ([]types.BaseVirtualDeviceConfigSpec, object.VirtualDeviceList, error) {
+	var changes []types.BaseVirtualDeviceConfigSpec
+	for _, disk := range disks {
+		var change *types.VirtualDeviceConfigSpec
+		var err error
+		if disk.Name == "" {
+			change, err = c.addDisk(ctx, devices, disk)
+			if err == nil {
+				// Keep the new disk in the list, so that the next one gets its
+				// own key and unit number
+				devices = append(devices, change.Device)
+			}
+		} else {
+			change, err = editDisk(devices, disk)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, devices, nil
+}
+
+// addDisk creates a new disk on its controller and data store
+func (c *Client) addDisk(ctx context.Context, devices object.VirtualDeviceList, disk Disk) (*types.VirtualDeviceConfigSpec, error) {
+	if disk.Remove {
+		return nil, InvalidConfigurationError{Message: "A disk to remove must be identified by name"}
+	}
+	if disk.SizeMB <= 0 {
+		return nil, InvalidConfigurationError{Message: "A new disk requires a size"}
+	}
+
+	controller, err := findDiskController(devices, disk.Controller)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := c.datastore
+	if disk.Datastore != "" {
+		ds, err = c.Finder.Datastore(ctx, disk.Datastore)
+		if err = c.checkErr(ctx, translateFindErr(err, "data store", disk.Datastore)); err != nil {
+			return nil, err
+		}
+	}
+
+	device := devices.CreateDisk(controller, ds.Reference(), "")
+	device.Key = devices.NewKey()
+	device.CapacityInKB = disk.SizeMB * 1024
+
+	backing := device.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	if disk.Mode != "" {
+		backing.DiskMode = disk.Mode
+	}
+	switch disk.Provisioning {
+	case "", ProvisioningThin:
+	case ProvisioningThick:
+		backing.ThinProvisioned = types.NewBool(false)
+	case ProvisioningEagerZeroedThick:
+		backing.ThinProvisioned = types.NewBool(false)
+		backing.EagerlyScrub = types.NewBool(true)
+	default:
+		return nil, InvalidConfigurationError{
+			Message: fmt.Sprintf("Disk provisioning '%s' is invalid; must be \"%s\", \"%s\", or \"%s\"", disk.Provisioning, ProvisioningThin, ProvisioningThick, ProvisioningEagerZeroedThick),
+		}
+	}
+
+	return &types.VirtualDeviceConfigSpec{
+		Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+		FileOperation: types.VirtualDeviceConfigSpecFileOperationCreate,
+		Device:        device,
+	}, nil
+}
+
+// editDisk grows, changes the mode of, or removes an existing disk.  Its
+// controller, data store, and provisioning cannot be changed.
+func editDisk(devices object.VirtualDeviceList, disk Disk) (*types.VirtualDeviceConfigSpec, error) {
+	device, ok := devices.Find(disk.Name).(*types.VirtualDisk)
+	if !ok {
+		return nil, NotFoundError{Kind: "disk", Path: disk.Name}
+	}
+
+	if disk.Remove {
+		return &types.VirtualDeviceConfigSpec{
+			Operation:     types.VirtualDeviceConfigSpecOperationRemove,
+			FileOperation: types.VirtualDeviceConfigSpecFileOperationDestroy,
+			Device:        device,
+		}, nil
+	}
+
+	sameController := true
+	if disk.Controller != "" {
+		var err error
+		sameController, err = diskControllerIs(devices, device, disk.Controller)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	current := reportDisk(devices, device)
+	if !sameController ||
+		(disk.Datastore != "" && disk.Datastore != current.Datastore) ||
+		(disk.Provisioning != "" && disk.Provisioning != current.Provisioning) {
+		return nil, InvalidConfigurationError{
+			Message: fmt.Sprintf("The controller, data store, and provisioning of disk '%s' cannot be changed", disk.Name),
+		}
+	}
+
+	if disk.SizeMB != 0 {
+		if disk.SizeMB < current.SizeMB {
+			return nil, InvalidConfigurationError{
+				Message: fmt.Sprintf("Disk '%s' cannot shrink from %d MB to %d MB", disk.Name, current.SizeMB, disk.SizeMB),
+			}
+		}
+		device.CapacityInKB = disk.SizeMB * 1024
+		device.CapacityInBytes = 0
+	}
+
+	if disk.Mode != "" {
+		backing, ok := device.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+		if !ok {
+			return nil, InvalidConfigurationError{
+				Message: fmt.Sprintf("The mode of disk '%s' cannot be changed", disk.Name),
+			}
+		}
+		backing.DiskMode = disk.Mode
+	}
+
+	return &types.VirtualDeviceConfigSpec{
+		Operation: types.VirtualDeviceConfigSpecOperationEdit,
+		Device:    device,
+	}, nil
+}
+
+// findDiskController finds the controller for a new disk, either by kind or
+// by name
+func findDiskController(devices object.VirtualDeviceList, name string) (types.BaseVirtualController, error) {
+	controller, err := devices.FindDiskController(name)
+	if err != nil {
+		return nil, InvalidConfigurationError{
+			Message: fmt.Sprintf("Disk controller '%s' cannot be used: %s", name, err),
+		}
+	}
+
+	return controller, nil
+}
+
+// diskControllerIs reports whether the disk is on the named controller, or on
+// a controller of the named kind
+func diskControllerIs(devices object.VirtualDeviceList, device *types.VirtualDisk, name string) (bool, error) {
+	controller := devices.FindByKey(device.ControllerKey)
+	switch name {
+	case "scsi":
+		_, ok := controller.(types.BaseVirtualSCSIController)
+		return ok, nil
+	case "ide":
+		_, ok := controller.(*types.VirtualIDEController)
+		return ok, nil
+	case "nvme":
+		_, ok := controller.(*types.VirtualNVMEController)
+		return ok, nil
+	}
+
+	if _, err := findDiskController(devices, name); err != nil {
+		return false, err
+	}
+	return controller != nil && devices.Name(controller) == name, nil
+}
+
+// reportDisks describes the disks among the VM's devices
+func reportDisks(devices object.VirtualDeviceList) []Disk {
+	disks := []Disk{}
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disks = append(disks, reportDisk(devices, device.(*types.VirtualDisk)))
+	}
+
+	return disks
+}
+
+// reportDisk describes one disk
+func reportDisk(devices object.VirtualDeviceList, device *types.VirtualDisk) Disk {
+	disk := Disk{
+		Name:   devices.Name(device),
+		SizeMB: device.CapacityInKB / 1024,
+	}
+	if device.CapacityInBytes != 0 {
+		disk.SizeMB = device.CapacityInBytes / (1024 * 1024)
+	}
+
+	if controller := devices.FindByKey(device.ControllerKey); controller != nil {
+		disk.Controller = devices.Name(controller)
+	}
+
+	if backing, ok := device.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+		disk.File = backing.GetVirtualDeviceFileBackingInfo().FileName
+
+		var path object.DatastorePath
+		if path.FromString(disk.File) {
+			disk.Datastore = path.Datastore
+		}
+	}
+
+	if backing, ok := device.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
+		disk.Mode = backing.DiskMode
+		switch {
+		case backing.ThinProvisioned != nil && *backing.ThinProvisioned:
+			disk.Provisioning = ProvisioningThin
+		case backing.EagerlyScrub != nil && *backing.EagerlyScrub:
+			disk.Provisioning = ProvisioningEagerZeroedThick
+		default:
+			disk.Provisioning = ProvisioningThick
+		}
+	}
+
+	return disk
+}
@@ -0,0 +1,28 @@
+package vcon
+
+import "testing"
+
+func TestMemberKey(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "web", expected: "web"},
+		{path: "/web", expected: "web"},
+		{path: "web/", expected: "web"},
+		{path: "team/web", expected: "team/web"},
+		{path: "/team//web", expected: "team/web"},
+		{path: "team/./web", expected: "team/web"},
+		{path: "team/other/../web", expected: "team/web"},
+		{path: "/../web", expected: "web"},
+		{path: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if actual := memberKey(tt.path); actual != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -1,9 +1,16 @@
 package vcon
 
 import (
-	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 // ErrorCoder gives errors a code to return with os.Exit
@@ -12,24 +19,66 @@ type ErrorCoder interface {
 	Code() int
 }
 
+// ExitCode finds the most specific ErrorCoder in the chain of causes for the
+// provided error, and returns its code.  If there is no ErrorCoder in the
+// chain, -1 is returned.
+func ExitCode(err error) int {
+	type causer interface {
+		Cause() error
+	}
+
+	code := -1
+	for err != nil {
+		if ec, ok := err.(ErrorCoder); ok {
+			code = ec.Code()
+		}
+
+		cause, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	return code
+}
+
 // ConnectionError occurs when vcon fails to establish a connection to vSphere
 // or the user has not provided a valid datacenter or datastore name
-type ConnectionError struct{}
+type ConnectionError struct {
+	Err error
+}
 
 func (ce ConnectionError) Error() string {
-	return "Failed to connect to vSphere"
+	if ce.Err == nil {
+		return "Failed to connect to vSphere"
+	}
+	return fmt.Sprintf("Failed to connect to vSphere: %s", ce.Err.Error())
+}
+
+// Cause returns the error which prevented the connection, if any
+func (ce ConnectionError) Cause() error {
+	return ce.Err
 }
 
 func (ce ConnectionError) Code() int {
 	return 1
 }
 
+// NotFoundError occurs when a VM, folder, snapshot, or other vSphere object
+// cannot be located.  The Kind describes the object; if it is empty, the
+// object is assumed to be a VM.
 type NotFoundError struct {
+	Kind string
 	Path string
 }
 
 func (nfe NotFoundError) Error() string {
-	return fmt.Sprintf("Failed to find VM identified by '%s'", nfe.Path)
+	kind := nfe.Kind
+	if kind == "" {
+		kind = "VM"
+	}
+	return fmt.Sprintf("Failed to find %s identified by '%s'", kind, nfe.Path)
 }
 
 func (nfe NotFoundError) Code() int {
@@ -43,14 +92,243 @@ type TimeoutExceededError struct {
 }
 
 func (tee TimeoutExceededError) Error() string {
-	return fmt.Sprintf("Timed out after %d seconds", tee.timeout)
-}
-
-// Cause returns the root cause, which will always be context.DeadlineExceeded
-func (tee TimeoutExceededError) Cause() error {
-	return context.DeadlineExceeded
+	return fmt.Sprintf("Timed out after %s", tee.timeout)
 }
 
 func (tee TimeoutExceededError) Code() int {
 	return 3
 }
+
+// CanceledError occurs when a collection of vSphere operations is abandoned
+// because its context was canceled, i.e., the user interrupted vcon
+type CanceledError struct{}
+
+func (ce CanceledError) Error() string {
+	return "Operation was canceled"
+}
+
+func (ce CanceledError) Code() int {
+	return 4
+}
+
+// AlreadyExistsError occurs when vSphere refuses to create or rename an object
+// because another object already has the requested name
+type AlreadyExistsError struct {
+	Name string
+}
+
+func (aee AlreadyExistsError) Error() string {
+	return fmt.Sprintf("An object named '%s' already exists", aee.Name)
+}
+
+func (aee AlreadyExistsError) Code() int {
+	return 5
+}
+
+// InvalidPowerStateError occurs when an operation cannot be performed while
+// the VM is in its current power state.  Expected may be empty if vSphere did
+// not report the required state.
+type InvalidPowerStateError struct {
+	Expected PowerState
+	Actual   PowerState
+}
+
+func (ipse InvalidPowerStateError) Error() string {
+	if ipse.Expected == "" {
+		return fmt.Sprintf("Operation is not allowed while the VM is %s", ipse.Actual)
+	}
+	return fmt.Sprintf("VM is %s, but must be %s", ipse.Actual, ipse.Expected)
+}
+
+func (ipse InvalidPowerStateError) Code() int {
+	return 6
+}
+
+// PermissionDeniedError occurs when vSphere rejects the user's credentials, or
+// the user lacks the privileges for an operation.  It also occurs when the
+// guest OS rejects the guest credentials.
+type PermissionDeniedError struct {
+	Fault   string
+	Message string
+}
+
+func (pde PermissionDeniedError) Error() string {
+	return fmt.Sprintf("Permission denied (%s): %s", pde.Fault, pde.Message)
+}
+
+func (pde PermissionDeniedError) Code() int {
+	return 7
+}
+
+// TaskFailedError occurs when vSphere reports a fault that does not belong to
+// any of the more specific error classes.  Fault is the name of the vSphere
+// fault type, i.e., "FileLocked".
+type TaskFailedError struct {
+	Fault   string
+	Message string
+}
+
+func (tfe TaskFailedError) Error() string {
+	return fmt.Sprintf("vSphere task failed (%s): %s", tfe.Fault, tfe.Message)
+}
+
+func (tfe TaskFailedError) Code() int {
+	return 8
+}
+
+// InvalidConfigurationError occurs when the user has provided options or a
+// VM configuration which vcon or vSphere cannot use.  Fault is the name of the
+// vSphere fault type, if vSphere rejected the configuration.
+type InvalidConfigurationError struct {
+	Fault   string
+	Message string
+}
+
+func (ice InvalidConfigurationError) Error() string {
+	if ice.Fault == "" {
+		return fmt.Sprintf("Invalid configuration: %s", ice.Message)
+	}
+	return fmt.Sprintf("Invalid configuration (%s): %s", ice.Fault, ice.Message)
+}
+
+func (ice InvalidConfigurationError) Code() int {
+	return 9
+}
+
+// CertificateError occurs when the certificate presented by vSphere cannot be
+// verified.  Thumbprint is the SHA-256 thumbprint of the certificate, which may
+// be used to pin it if it is trusted.
+type CertificateError struct {
+	Host       string
+	Thumbprint string
+	Reason     string
+}
+
+func (ce CertificateError) Error() string {
+	if ce.Thumbprint == "" {
+		return fmt.Sprintf("Failed to verify certificate of '%s': %s", ce.Host, ce.Reason)
+	}
+	return fmt.Sprintf("Failed to verify certificate of '%s' with thumbprint %s: %s", ce.Host, ce.Thumbprint, ce.Reason)
+}
+
+func (ce CertificateError) Code() int {
+	return 10
+}
+
+// OperationError records which Client operation failed, the Managed Object
+// Reference of the VM it was acting on (if known), and the step within the
+// operation that failed.  It does not have its own code; the code belongs to
+// its cause.
+type OperationError struct {
+	Operation string
+	Ref       string
+	Step      string
+	Err       error
+}
+
+func (oe OperationError) Error() string {
+	return oe.Err.Error()
+}
+
+// Cause returns the error which caused the operation to fail
+func (oe OperationError) Cause() error {
+	return oe.Err
+}
+
+// newOperationError wraps an error from a Client operation.  The step is the
+// chain of messages which were added while the error was returned, leaving
+// out the message of the root cause.
+func newOperationError(operation, ref string, err error) error {
+	root := errors.Cause(err)
+	step := strings.TrimSuffix(err.Error(), root.Error())
+	step = strings.TrimSuffix(step, ": ")
+
+	return OperationError{
+		Operation: operation,
+		Ref:       ref,
+		Step:      step,
+		Err:       err,
+	}
+}
+
+// translateErr converts SOAP and vSphere faults, including those of failed
+// tasks, into one of vcon's error types; other errors are returned as-is
+func translateErr(err error) error {
+	switch {
+	case soap.IsSoapFault(err):
+		f := soap.ToSoapFault(err)
+		if fault := f.VimFault(); fault != nil {
+			return translateFault(fault, f.String)
+		}
+	case soap.IsVimFault(err):
+		return translateFault(soap.ToVimFault(err), err.Error())
+	}
+
+	// A task which fails reports its fault when it is waited for
+	if te, ok := err.(task.Error); ok {
+		return translateFault(te.Fault(), te.LocalizedMessage)
+	}
+
+	return err
+}
+
+// translateFault converts a vSphere fault into one of vcon's error types
+func translateFault(fault interface{}, message string) error {
+	if fault == nil {
+		return TaskFailedError{Fault: "Unknown", Message: message}
+	}
+
+	// SOAP faults carry the fault by value, while task errors carry a pointer;
+	// normalize to a pointer so that the fault interfaces match.
+	v := reflect.ValueOf(fault)
+	if v.Kind() != reflect.Ptr {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		fault = p.Interface()
+	}
+
+	name := reflect.TypeOf(fault).Elem().Name()
+	if message == "" {
+		message = name
+	}
+
+	switch f := fault.(type) {
+	case *types.InvalidPowerState:
+		ipse := InvalidPowerStateError{
+			Actual: toPowerState(f.ExistingState),
+		}
+		if f.RequestedState != "" {
+			ipse.Expected = toPowerState(f.RequestedState)
+		}
+		return ipse
+	case *types.ManagedObjectNotFound:
+		return NotFoundError{Kind: f.Obj.Type, Path: f.Obj.Value}
+	case *types.FileNotFound:
+		return NotFoundError{Kind: "file", Path: f.File}
+	case *types.DuplicateName:
+		return AlreadyExistsError{Name: f.Name}
+	case *types.AlreadyExists:
+		return AlreadyExistsError{Name: f.Name}
+	case *types.FileAlreadyExists:
+		return AlreadyExistsError{Name: f.File}
+	case *types.InvalidLogin, types.BaseSecurityError, *types.InvalidGuestLogin, *types.GuestPermissionDenied:
+		return PermissionDeniedError{Fault: name, Message: message}
+	case types.BaseInvalidVmConfig, types.BaseInvalidArgument:
+		return InvalidConfigurationError{Fault: name, Message: message}
+	case *types.RequestCanceled:
+		return CanceledError{}
+	}
+
+	return TaskFailedError{Fault: name, Message: message}
+}
+
+// translateFindErr converts the Finder's "not found" errors into a
+// NotFoundError for the object kind and path that was requested
+func translateFindErr(err error, kind, path string) error {
+	switch err.(type) {
+	case *find.NotFoundError, *find.DefaultNotFoundError:
+		return NotFoundError{Kind: kind, Path: path}
+	}
+
+	return err
+}
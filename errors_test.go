@@ -0,0 +1,170 @@
+package vcon
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "no error", err: nil, expected: -1},
+		{name: "unknown error", err: fmt.Errorf("failed"), expected: -1},
+		{name: "connection", err: ConnectionError{}, expected: 1},
+		{name: "not found", err: NotFoundError{}, expected: 2},
+		{name: "timeout", err: TimeoutExceededError{}, expected: 3},
+		{name: "canceled", err: CanceledError{}, expected: 4},
+		{name: "already exists", err: AlreadyExistsError{}, expected: 5},
+		{name: "power state", err: InvalidPowerStateError{}, expected: 6},
+		{name: "permission denied", err: PermissionDeniedError{}, expected: 7},
+		{name: "task failed", err: TaskFailedError{}, expected: 8},
+		{name: "invalid configuration", err: InvalidConfigurationError{}, expected: 9},
+		{name: "certificate", err: CertificateError{}, expected: 10},
+		{name: "wrapped", err: errors.Wrap(NotFoundError{}, "While finding VM"), expected: 2},
+		{name: "operation", err: newOperationError("FindVM", "", errors.Wrap(TimeoutExceededError{}, "Timeout")), expected: 3},
+		{name: "most specific", err: ConnectionError{Err: errors.Wrap(CertificateError{}, "While connecting")}, expected: 10},
+		{name: "unknown cause", err: ConnectionError{Err: fmt.Errorf("refused")}, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := ExitCode(tt.err); actual != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTranslateFault(t *testing.T) {
+	tests := []struct {
+		name     string
+		fault    interface{}
+		message  string
+		expected error
+	}{
+		{
+			name:     "no fault",
+			message:  "error",
+			expected: TaskFailedError{Fault: "Unknown", Message: "error"},
+		},
+		{
+			name:     "power state",
+			fault:    &types.InvalidPowerState{RequestedState: types.VirtualMachinePowerStatePoweredOff, ExistingState: types.VirtualMachinePowerStatePoweredOn},
+			expected: InvalidPowerStateError{Expected: PoweredOff, Actual: PoweredOn},
+		},
+		{
+			name:     "power state by value",
+			fault:    types.InvalidPowerState{ExistingState: types.VirtualMachinePowerStateSuspended},
+			expected: InvalidPowerStateError{Actual: Suspended},
+		},
+		{
+			name:     "managed object not found",
+			fault:    &types.ManagedObjectNotFound{Obj: types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}},
+			expected: NotFoundError{Kind: "VirtualMachine", Path: "vm-1"},
+		},
+		{
+			name:     "file not found",
+			fault:    &types.FileNotFound{FileFault: types.FileFault{File: "/tmp/a"}},
+			expected: NotFoundError{Kind: "file", Path: "/tmp/a"},
+		},
+		{
+			name:     "duplicate name",
+			fault:    &types.DuplicateName{Name: "a"},
+			expected: AlreadyExistsError{Name: "a"},
+		},
+		{
+			name:     "already exists",
+			fault:    types.AlreadyExists{Name: "a"},
+			expected: AlreadyExistsError{Name: "a"},
+		},
+		{
+			name:     "file already exists",
+			fault:    &types.FileAlreadyExists{FileFault: types.FileFault{File: "/tmp/a"}},
+			expected: AlreadyExistsError{Name: "/tmp/a"},
+		},
+		{
+			name:     "invalid login",
+			fault:    &types.InvalidLogin{},
+			message:  "Cannot complete login",
+			expected: PermissionDeniedError{Fault: "InvalidLogin", Message: "Cannot complete login"},
+		},
+		{
+			name:     "no permission",
+			fault:    &types.NoPermission{},
+			expected: PermissionDeniedError{Fault: "NoPermission", Message: "NoPermission"},
+		},
+		{
+			name:     "invalid guest login",
+			fault:    &types.InvalidGuestLogin{},
+			expected: PermissionDeniedError{Fault: "InvalidGuestLogin", Message: "InvalidGuestLogin"},
+		},
+		{
+			name:     "invalid argument",
+			fault:    &types.InvalidArgument{InvalidProperty: "name"},
+			message:  "A specified parameter was not correct",
+			expected: InvalidConfigurationError{Fault: "InvalidArgument", Message: "A specified parameter was not correct"},
+		},
+		{
+			name:     "invalid VM config",
+			fault:    &types.InvalidDeviceSpec{},
+			expected: InvalidConfigurationError{Fault: "InvalidDeviceSpec", Message: "InvalidDeviceSpec"},
+		},
+		{
+			name:     "request canceled",
+			fault:    &types.RequestCanceled{},
+			expected: CanceledError{},
+		},
+		{
+			name:     "other",
+			fault:    &types.FileLocked{},
+			message:  "Unable to access file",
+			expected: TaskFailedError{Fault: "FileLocked", Message: "Unable to access file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := translateFault(tt.fault, tt.message)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %#v, got %#v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTranslateErr(t *testing.T) {
+	unknown := fmt.Errorf("failed")
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			name:     "task error",
+			err:      task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{Fault: &types.DuplicateName{Name: "a"}, LocalizedMessage: "The name 'a' already exists."}},
+			expected: AlreadyExistsError{Name: "a"},
+		},
+		{
+			name:     "unknown error",
+			err:      unknown,
+			expected: unknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := translateErr(tt.err)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %#v, got %#v", tt.expected, actual)
+			}
+		})
+	}
+}
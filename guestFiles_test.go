@@ -0,0 +1,163 @@
+package vcon
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is an entry for a test archive
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestReadTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		files   map[string]string
+		err     string
+	}{
+		{
+			name: "files and directories",
+			entries: []tarEntry{
+				{name: "dir/", typeflag: tar.TypeDir},
+				{name: "dir/a.txt", typeflag: tar.TypeReg, body: "a"},
+				{name: "b.txt", typeflag: tar.TypeReg, body: "b"},
+				{name: "dir/link", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+			},
+			files: map[string]string{"dir/a.txt": "a", "b.txt": "b", "dir/link": "a"},
+		},
+		{
+			name:    "entry outside the destination",
+			entries: []tarEntry{{name: "../escaped.txt", typeflag: tar.TypeReg, body: "x"}},
+			err:     "is outside the destination",
+		},
+		{
+			name: "absolute symlink outside the destination",
+			entries: []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "/etc"},
+				{name: "a/passwd", typeflag: tar.TypeReg, body: "x"},
+			},
+			err: "outside the destination",
+		},
+		{
+			name: "relative symlink outside the destination",
+			entries: []tarEntry{
+				{name: "dir/a", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+				{name: "dir/a/passwd", typeflag: tar.TypeReg, body: "x"},
+			},
+			err: "outside the destination",
+		},
+		{
+			name: "entry beneath a symlink",
+			entries: []tarEntry{
+				{name: "real/", typeflag: tar.TypeDir},
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "real"},
+				{name: "a/file", typeflag: tar.TypeReg, body: "x"},
+			},
+			err: "through a symbolic link",
+		},
+		{
+			name: "directory entry which is a symlink",
+			entries: []tarEntry{
+				{name: "real/", typeflag: tar.TypeDir},
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "real"},
+				{name: "a/", typeflag: tar.TypeDir},
+			},
+			err: "through a symbolic link",
+		},
+		{
+			name: "file replacing a symlink",
+			entries: []tarEntry{
+				{name: "target.txt", typeflag: tar.TypeReg, body: "target"},
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "target.txt"},
+				{name: "a", typeflag: tar.TypeReg, body: "a"},
+			},
+			files: map[string]string{"target.txt": "target", "a": "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "vcon-readTar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dir := filepath.Join(tmp, "dest")
+
+			err = readTar(buildTar(t, tt.entries), dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				if _, ok := err.(InvalidConfigurationError); !ok {
+					t.Errorf("expected an InvalidConfigurationError, got %T", err)
+				}
+				if _, err := os.Lstat(filepath.Join(tmp, "outside")); !os.IsNotExist(err) {
+					t.Errorf("expected nothing to be written outside the destination")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for name, content := range tt.files {
+				b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Errorf("failed to read %s: %v", name, err)
+					continue
+				}
+				if string(b) != content {
+					t.Errorf("%s: expected %q, got %q", name, content, string(b))
+				}
+			}
+		})
+	}
+}
+
+func TestExtractScriptWindows(t *testing.T) {
+	g := &guestOperations{windows: true}
+
+	expected := `(if not exist "C:\R&D" mkdir "C:\R&D") && tar -xf C:\Temp\a.tar -C C:\R^&D`
+	if actual := g.extractScript(`C:\Temp\a.tar`, `C:\R&D`); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
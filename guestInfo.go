@@ -0,0 +1,57 @@
+package vcon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+)
+
+// Keys for data passed to the guest through extraConfig, as read by
+// cloud-init's VMware data source and by Ignition
+const (
+	GuestInfoUserData   = "guestinfo.userdata"
+	GuestInfoMetadata   = "guestinfo.metadata"
+	GuestInfoVendorData = "guestinfo.vendordata"
+	GuestInfoIgnition   = "guestinfo.ignition.config.data"
+)
+
+// Encodings for guestinfo data
+const (
+	GuestInfoBase64     = "base64"
+	GuestInfoGzipBase64 = "gzip+base64"
+)
+
+// AddGuestInfo encodes the data into the configuration's extraConfig, under
+// the key.  The encoding is recorded under the key with an ".encoding"
+// suffix, so that the guest can decode it.
+func (vmc *VirtualMachineConfiguration) AddGuestInfo(key string, data []byte, encoding string) error {
+	var encoded string
+	switch encoding {
+	case GuestInfoBase64:
+		encoded = base64.StdEncoding.EncodeToString(data)
+	case GuestInfoGzipBase64:
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		_, err := w.Write(data)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		encoded = base64.StdEncoding.EncodeToString(b.Bytes())
+	default:
+		return InvalidConfigurationError{
+			Message: fmt.Sprintf("guestinfo encoding '%s' is invalid; must be \"%s\" or \"%s\"", encoding, GuestInfoBase64, GuestInfoGzipBase64),
+		}
+	}
+
+	if vmc.ExtraConfig == nil {
+		vmc.ExtraConfig = map[string]string{}
+	}
+	vmc.ExtraConfig[key] = encoded
+	vmc.ExtraConfig[key+".encoding"] = encoding
+
+	return nil
+}
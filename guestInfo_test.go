@@ -0,0 +1,74 @@
+package vcon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAddGuestInfo(t *testing.T) {
+	data := []byte("#cloud-config\nhostname: web\n")
+
+	tests := []struct {
+		name     string
+		key      string
+		encoding string
+		err      bool
+	}{
+		{name: "base64", key: GuestInfoUserData, encoding: GuestInfoBase64},
+		{name: "gzip", key: GuestInfoMetadata, encoding: GuestInfoGzipBase64},
+		{name: "unknown encoding", key: GuestInfoUserData, encoding: "gzip", err: true},
+		{name: "no encoding", key: GuestInfoUserData, encoding: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmc := &VirtualMachineConfiguration{}
+			err := vmc.AddGuestInfo(tt.key, data, tt.encoding)
+			if tt.err {
+				if _, ok := err.(InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				if len(vmc.ExtraConfig) != 0 {
+					t.Errorf("expected no extraConfig, got %v", vmc.ExtraConfig)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(vmc.ExtraConfig) != 2 || vmc.ExtraConfig[tt.key+".encoding"] != tt.encoding {
+				t.Fatalf("expected the data and its encoding, got %v", vmc.ExtraConfig)
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(vmc.ExtraConfig[tt.key])
+			if err != nil {
+				t.Fatalf("expected base64, got %q", vmc.ExtraConfig[tt.key])
+			}
+			if tt.encoding == GuestInfoGzipBase64 {
+				r, err := gzip.NewReader(bytes.NewReader(decoded))
+				if err != nil {
+					t.Fatalf("expected gzip data: %v", err)
+				}
+				if decoded, err = ioutil.ReadAll(r); err != nil {
+					t.Fatalf("expected gzip data: %v", err)
+				}
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("expected %q, got %q", data, decoded)
+			}
+		})
+	}
+
+	// Existing settings are kept
+	vmc := &VirtualMachineConfiguration{ExtraConfig: map[string]string{"a": "1"}}
+	if err := vmc.AddGuestInfo(GuestInfoVendorData, data, GuestInfoBase64); err != nil {
+		t.Fatal(err)
+	}
+	if vmc.ExtraConfig["a"] != "1" || len(vmc.ExtraConfig) != 3 {
+		t.Errorf("expected the existing extraConfig to be kept, got %v", vmc.ExtraConfig)
+	}
+}
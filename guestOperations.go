@@ -0,0 +1,460 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/progress"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// GuestCredentials are the guest OS user's name and password, used to run
+// programs in the guest through VMware Tools
+type GuestCredentials struct {
+	Username string
+	Password string
+}
+
+// ExecResult is the outcome of a command run in the guest
+type ExecResult struct {
+	ExitCode int32  `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// execPollInterval is how often the guest is asked whether the command has
+// finished
+const execPollInterval = time.Second
+
+// Exec runs a command in the guest OS through VMware Tools, and waits for it
+// to finish.  The command is run by the guest's shell, with its output
+// captured in temporary files in the guest.  The VM must be running, with
+// VMware Tools.
+func (c *Client) Exec(vm *VirtualMachine, creds *GuestCredentials, command []string) (*ExecResult, error) {
+	return c.ExecContext(context.Background(), vm, creds, command)
+}
+
+// ExecContext is like Exec, but uses the provided context.  If the context is
+// canceled, the command is terminated.
+func (c *Client) ExecContext(ctx context.Context, vm *VirtualMachine, creds *GuestCredentials, command []string) (*ExecResult, error) {
+	if c.Verbose {
+		fmt.Printf("Running command in guest...\n")
+	}
+
+	var result *ExecResult
+	err := func() error {
+		if len(command) == 0 {
+			return InvalidConfigurationError{Message: "A command is required"}
+		}
+
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		g, err := c.newGuestOperations(ctx, vm, creds)
+		if err != nil {
+			return err
+		}
+
+		result, err = g.run(ctx, g.quote(command))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrap(err, "Timeout while running command in guest")
+		default:
+			// unknown error
+			err = errors.Wrap(err, "Got error while running command in guest")
+		}
+		return nil, newOperationError("Exec", vm.Ref.Value, err)
+	}
+
+	return result, nil
+}
+
+// guestOperations makes requests of the guest operations managers, on behalf
+// of a guest user
+type guestOperations struct {
+	c       *Client
+	vm      types.ManagedObjectReference
+	auth    *types.NamePasswordAuthentication
+	files   types.ManagedObjectReference
+	procs   types.ManagedObjectReference
+	windows bool
+}
+
+func (c *Client) newGuestOperations(ctx context.Context, vm *VirtualMachine, creds *GuestCredentials) (*guestOperations, error) {
+	if creds == nil || creds.Username == "" {
+		return nil, InvalidConfigurationError{Message: "Guest credentials are required"}
+	}
+	if c.DryRun {
+		return nil, InvalidConfigurationError{Message: "Guest operations cannot be dry run"}
+	}
+
+	ref := c.Client.ServiceContent.GuestOperationsManager
+	if ref == nil {
+		return nil, InvalidConfigurationError{Message: "vSphere does not support guest operations"}
+	}
+
+	pc := property.DefaultCollector(c.Client.Client)
+	var gom mo.GuestOperationsManager
+	err := pc.RetrieveOne(ctx, *ref, []string{"fileManager", "processManager"}, &gom)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, err
+	}
+
+	var moVM mo.VirtualMachine
+	err = pc.RetrieveOne(ctx, vm.VM.Reference(), []string{"guest.guestFamily"}, &moVM)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, err
+	}
+
+	return &guestOperations{
+		c:  c,
+		vm: vm.VM.Reference(),
+		auth: &types.NamePasswordAuthentication{
+			Username: creds.Username,
+			Password: creds.Password,
+		},
+		files:   *gom.FileManager,
+		procs:   *gom.ProcessManager,
+		windows: moVM.Guest != nil && moVM.Guest.GuestFamily == string(types.VirtualMachineGuestOsFamilyWindowsGuest),
+	}, nil
+}
+
+// run runs the script with the guest's shell, and waits for it to finish.
+// Its output is captured in temporary files in the guest.
+func (g *guestOperations) run(ctx context.Context, script string) (*ExecResult, error) {
+	stdout, err := g.createTempFile(ctx, "vcon-stdout", ".txt")
+	if err != nil {
+		return nil, errors.Wrap(err, "While creating file for stdout")
+	}
+	defer g.deleteFile(stdout)
+
+	stderr, err := g.createTempFile(ctx, "vcon-stderr", ".txt")
+	if err != nil {
+		return nil, errors.Wrap(err, "While creating file for stderr")
+	}
+	defer g.deleteFile(stderr)
+
+	pid, err := g.start(ctx, g.shellSpec(script, stdout, stderr))
+	if err != nil {
+		return nil, errors.Wrap(err, "While starting command")
+	}
+
+	exitCode, err := g.wait(ctx, pid)
+	if err != nil {
+		return nil, errors.Wrap(err, "While waiting for command")
+	}
+
+	result := &ExecResult{ExitCode: exitCode}
+	result.Stdout, err = g.readFile(ctx, stdout)
+	if err != nil {
+		return nil, errors.Wrap(err, "While reading stdout")
+	}
+	result.Stderr, err = g.readFile(ctx, stderr)
+	if err != nil {
+		return nil, errors.Wrap(err, "While reading stderr")
+	}
+
+	return result, nil
+}
+
+func (g *guestOperations) createTempFile(ctx context.Context, prefix, suffix string) (string, error) {
+	res, err := methods.CreateTemporaryFileInGuest(ctx, g.c.Client.Client, &types.CreateTemporaryFileInGuest{
+		This:   g.files,
+		Vm:     g.vm,
+		Auth:   g.auth,
+		Prefix: prefix,
+		Suffix: suffix,
+	})
+	if err = g.c.checkErr(ctx, err); err != nil {
+		return "", err
+	}
+
+	return res.Returnval, nil
+}
+
+// deleteFile removes a temporary file.  It is used for clean up, so it is
+// not bound by the command's context, and failures are ignored.
+func (g *guestOperations) deleteFile(path string) {
+	ctx, cancelFn := g.c.withTimeout(context.Background())
+	defer cancelFn()
+
+	methods.DeleteFileInGuest(ctx, g.c.Client.Client, &types.DeleteFileInGuest{
+		This:     g.files,
+		Vm:       g.vm,
+		Auth:     g.auth,
+		FilePath: path,
+	})
+}
+
+func (g *guestOperations) readFile(ctx context.Context, path string) (string, error) {
+	r, _, _, err := g.download(ctx, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err = g.c.checkErr(ctx, err); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// download opens the guest file for reading, and reports its size and
+// attributes.  If s is set, it receives the progress of the read.
+func (g *guestOperations) download(ctx context.Context, path string, s progress.Sinker) (io.ReadCloser, int64, types.BaseGuestFileAttributes, error) {
+	res, err := methods.InitiateFileTransferFromGuest(ctx, g.c.Client.Client, &types.InitiateFileTransferFromGuest{
+		This:          g.files,
+		Vm:            g.vm,
+		Auth:          g.auth,
+		GuestFilePath: path,
+	})
+	if err = g.c.checkErr(ctx, err); err != nil {
+		return nil, 0, nil, err
+	}
+
+	// The URL's host may be "*", meaning the host vcon is connected to
+	u, err := g.c.Client.Client.ParseURL(res.Returnval.Url)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	r, _, err := g.c.Client.Client.Download(ctx, u, &soap.DefaultDownload)
+	if err = g.c.checkErr(ctx, err); err != nil {
+		return nil, 0, nil, err
+	}
+
+	if s != nil {
+		pr := progress.NewReader(ctx, s, r, res.Returnval.Size)
+		r = &progressReadCloser{Reader: pr, closer: r, done: pr.Done}
+	}
+
+	return r, res.Returnval.Size, res.Returnval.Attributes, nil
+}
+
+// upload writes the guest file from the reader, which has the given size.
+// If s is set, it receives the progress of the write.
+func (g *guestOperations) upload(ctx context.Context, r io.Reader, size int64, path string, attrs types.BaseGuestFileAttributes, s progress.Sinker) error {
+	url, err := methods.InitiateFileTransferToGuest(ctx, g.c.Client.Client, &types.InitiateFileTransferToGuest{
+		This:           g.files,
+		Vm:             g.vm,
+		Auth:           g.auth,
+		GuestFilePath:  path,
+		FileAttributes: attrs,
+		FileSize:       size,
+		Overwrite:      true,
+	})
+	if err = g.c.checkErr(ctx, err); err != nil {
+		return err
+	}
+
+	u, err := g.c.Client.Client.ParseURL(url.Returnval)
+	if err != nil {
+		return err
+	}
+
+	param := soap.DefaultUpload
+	param.ContentLength = size
+	param.Progress = s
+	err = g.c.Client.Client.Upload(ctx, r, u, &param)
+	return g.c.checkErr(ctx, err)
+}
+
+// progressReadCloser reports the progress of a download until it is closed
+type progressReadCloser struct {
+	io.Reader
+	closer io.Closer
+	done   func(error)
+}
+
+func (p *progressReadCloser) Close() error {
+	p.done(nil)
+	return p.closer.Close()
+}
+
+// shellSpec runs the script with the guest's shell, redirecting its output to
+// the files
+func (g *guestOperations) shellSpec(script string, stdout, stderr string) *types.GuestProgramSpec {
+	if g.windows {
+		return &types.GuestProgramSpec{
+			ProgramPath: `C:\Windows\System32\cmd.exe`,
+			Arguments:   fmt.Sprintf(`/c "%s > %s 2> %s"`, script, g.quote([]string{stdout}), g.quote([]string{stderr})),
+		}
+	}
+
+	return &types.GuestProgramSpec{
+		ProgramPath: "/bin/sh",
+		Arguments:   fmt.Sprintf("-c %s", g.quote([]string{fmt.Sprintf("%s > %s 2> %s", script, g.quote([]string{stdout}), g.quote([]string{stderr}))})),
+	}
+}
+
+func (g *guestOperations) start(ctx context.Context, spec *types.GuestProgramSpec) (int64, error) {
+	res, err := methods.StartProgramInGuest(ctx, g.c.Client.Client, &types.StartProgramInGuest{
+		This: g.procs,
+		Vm:   g.vm,
+		Auth: g.auth,
+		Spec: spec,
+	})
+	if err = g.c.checkErr(ctx, err); err != nil {
+		return 0, err
+	}
+
+	return res.Returnval, nil
+}
+
+// wait polls the guest until the process ends, and returns its exit code.  If
+// the context is done first, the process is terminated.
+func (g *guestOperations) wait(ctx context.Context, pid int64) (int32, error) {
+	for {
+		res, err := methods.ListProcessesInGuest(ctx, g.c.Client.Client, &types.ListProcessesInGuest{
+			This: g.procs,
+			Vm:   g.vm,
+			Auth: g.auth,
+			Pids: []int64{pid},
+		})
+		if err = g.c.checkErr(ctx, err); err != nil {
+			if ctx.Err() != nil {
+				g.terminate(pid)
+			}
+			return 0, err
+		}
+
+		if len(res.Returnval) == 0 {
+			return 0, NotFoundError{Kind: "guest process", Path: fmt.Sprintf("%d", pid)}
+		}
+		if res.Returnval[0].EndTime != nil {
+			return res.Returnval[0].ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			g.terminate(pid)
+			return 0, g.c.checkErr(ctx, nil)
+		case <-time.After(execPollInterval):
+		}
+	}
+}
+
+// terminate stops a process which is still running after the command was
+// canceled or timed out
+func (g *guestOperations) terminate(pid int64) {
+	ctx, cancelFn := g.c.withTimeout(context.Background())
+	defer cancelFn()
+
+	methods.TerminateProcessInGuest(ctx, g.c.Client.Client, &types.TerminateProcessInGuest{
+		This: g.procs,
+		Vm:   g.vm,
+		Auth: g.auth,
+		Pid:  pid,
+	})
+}
+
+// quote joins the arguments for the guest's shell
+func (g *guestOperations) quote(args []string) string {
+	if g.windows {
+		return quoteWindows(args)
+	}
+	return quotePOSIX(args)
+}
+
+// quotePOSIX joins the arguments for a POSIX shell, quoting each one
+func quotePOSIX(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// cmdMetacharacters are the characters cmd.exe interprets outside quotes
+const cmdMetacharacters = `()%!^"<>&|`
+
+// quoteWindows joins the arguments for cmd.exe.  The first is the program, or
+// a path cmd.exe reads itself, which it only finds in real quotes; the rest
+// are quoted the way programs split their command lines, and then every
+// metacharacter, including those quotes, is escaped with a caret, so cmd.exe
+// passes them on rather than interpreting them.
+func quoteWindows(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if i == 0 {
+			quoted[i] = quoteWindowsPath(arg)
+			continue
+		}
+		quoted[i] = escapeCmd(quoteWindowsArg(arg))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// quoteWindowsPath quotes a program or file path for cmd.exe.  Within quotes,
+// cmd.exe still expands %, so it is escaped outside of them.
+func quoteWindowsPath(path string) string {
+	if path != "" && !strings.ContainsAny(path, " \t"+cmdMetacharacters) {
+		return path
+	}
+
+	return `"` + strings.Replace(path, "%", `"^%"`, -1) + `"`
+}
+
+// quoteWindowsArg quotes an argument the way CommandLineToArgvW splits it:
+// backslashes are only escaped when they precede a quote
+func quoteWindowsArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	backslashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			sb.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			sb.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		sb.WriteRune(r)
+	}
+	sb.WriteString(strings.Repeat(`\`, 2*backslashes))
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+// escapeCmd escapes each of cmd.exe's metacharacters with a caret
+func escapeCmd(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(cmdMetacharacters, r) {
+			sb.WriteByte('^')
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
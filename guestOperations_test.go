@@ -0,0 +1,53 @@
+package vcon
+
+import "testing"
+
+func TestQuotePOSIX(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{name: "no arguments", args: []string{}, expected: ""},
+		{name: "one argument", args: []string{"ls"}, expected: "'ls'"},
+		{name: "several arguments", args: []string{"ls", "-l", "/tmp"}, expected: "'ls' '-l' '/tmp'"},
+		{name: "empty argument", args: []string{"echo", ""}, expected: "'echo' ''"},
+		{name: "spaces", args: []string{"echo", "a b"}, expected: "'echo' 'a b'"},
+		{name: "single quotes", args: []string{"echo", "it's"}, expected: `'echo' 'it'\''s'`},
+		{name: "shell syntax", args: []string{"echo", "$HOME; rm -rf / `x` \"y\""}, expected: "'echo' '$HOME; rm -rf / `x` \"y\"'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := quotePOSIX(tt.args); actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestQuoteWindows(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{name: "no arguments", args: []string{}, expected: ""},
+		{name: "several arguments", args: []string{"dir", "/b", `C:\Temp`}, expected: `dir /b C:\Temp`},
+		{name: "empty argument", args: []string{"echo", ""}, expected: `echo ^"^"`},
+		{name: "spaces", args: []string{"echo", "a b"}, expected: `echo ^"a b^"`},
+		{name: "quotes", args: []string{"echo", `say "hi"`}, expected: `echo ^"say \^"hi\^"^"`},
+		{name: "backslashes", args: []string{"echo", `C:\a b\`, `a\"b`}, expected: `echo ^"C:\a b\\^" ^"a\\\^"b^"`},
+		{name: "metacharacters", args: []string{"echo", "a&b|c<d>e^f", "(%PATH%)!"}, expected: `echo a^&b^|c^<d^>e^^f ^(^%PATH^%^)^!`},
+		{name: "program with spaces", args: []string{`C:\Program Files (x86)\app.exe`, "x"}, expected: `"C:\Program Files (x86)\app.exe" x`},
+		{name: "path with percent", args: []string{`C:\100%\out.txt`}, expected: `"C:\100"^%"\out.txt"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := quoteWindows(tt.args); actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// LeaseExpiryKey is the extraConfig key which keeps the time when a VM's
+// lease expires, in RFC 3339 format
+const LeaseExpiryKey = "vcon.lease.expires"
+
+// ReapResult describes what happened to a VM with an expired lease
+type ReapResult struct {
+	Expires   time.Time `json:"expires"`
+	Path      string    `json:"path"`
+	Ref       string    `json:"ref"`
+	Destroyed bool      `json:"destroyed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// GetLease returns the time when the VM's lease expires, or nil if the VM has
+// no lease
+func (c *Client) GetLease(vm *VirtualMachine) (*time.Time, error) {
+	return c.GetLeaseContext(context.Background(), vm)
+}
+
+// GetLeaseContext is like GetLease, but uses the provided context
+func (c *Client) GetLeaseContext(ctx context.Context, vm *VirtualMachine) (*time.Time, error) {
+	var expires *time.Time
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		moVM := mo.VirtualMachine{}
+		err := vm.VM.Properties(ctx, vm.Ref, []string{"config.extraConfig"}, &moVM)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrapf(err, "While getting extra configuration")
+		}
+
+		expires = leaseExpiry(&moVM)
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrap(err, "Timeout while attempting to get lease")
+		default:
+			// unknown error
+			err = errors.Wrap(err, "Got error while getting lease")
+		}
+		return nil, newOperationError("GetLease", vm.Ref.Value, err)
+	}
+
+	return expires, nil
+}
+
+// SetLease records the time when the VM's lease expires.  Once it has expired,
+// the VM may be destroyed by Reap.
+func (c *Client) SetLease(vm *VirtualMachine, expires time.Time) error {
+	return c.SetLeaseContext(context.Background(), vm, expires)
+}
+
+// SetLeaseContext is like SetLease, but uses the provided context
+func (c *Client) SetLeaseContext(ctx context.Context, vm *VirtualMachine, expires time.Time) error {
+	if c.Verbose {
+		fmt.Printf("Setting lease to expire at %s...\n", expires.Format(time.RFC3339))
+	}
+
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		config := types.VirtualMachineConfigSpec{
+			ExtraConfig: []types.BaseOptionValue{leaseOption(expires)},
+		}
+		if c.dryRun("ReconfigVM_Task", vm.Ref, types.ReconfigVM_Task{This: vm.Ref, Spec: config}) {
+			return nil
+		}
+		task, err := vm.VM.Reconfigure(ctx, config)
+		_, err = c.finishTask(ctx, task, err)
+		if err != nil {
+			return errors.Wrapf(err, "While setting lease")
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrap(err, "Timeout while attempting to set lease")
+		default:
+			// unknown error
+			err = errors.Wrap(err, "Got error while setting lease")
+		}
+		return newOperationError("SetLease", vm.Ref.Value, err)
+	}
+
+	return nil
+}
+
+// Reap finds the VMs in a folder whose leases have expired, and powers off
+// and destroys them.  If dryRun is set, the VMs are only reported.  A failure
+// to destroy one VM does not stop the others from being destroyed; it is
+// recorded in that VM's result, and the first such error is returned.
+func (c *Client) Reap(folder string, recursive, dryRun bool) ([]ReapResult, error) {
+	return c.ReapContext(context.Background(), folder, recursive, dryRun)
+}
+
+// ReapContext is like Reap, but uses the provided context
+func (c *Client) ReapContext(ctx context.Context, folder string, recursive, dryRun bool) ([]ReapResult, error) {
+	vmis, err := c.ListVMsContext(ctx, folder, recursive, &VirtualMachineFilter{Expired: true})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ReapResult{}
+	var firstErr error
+	for _, vmi := range vmis {
+		result := ReapResult{
+			Expires: *vmi.LeaseExpires,
+			Path:    vmi.Path,
+			Ref:     vmi.Ref,
+		}
+
+		if !dryRun {
+			err = c.reapVM(ctx, vmi.Ref)
+			if err != nil {
+				result.Error = err.Error()
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else {
+				result.Destroyed = true
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, firstErr
+}
+
+func (c *Client) reapVM(ctx context.Context, ref string) error {
+	if c.Verbose {
+		fmt.Printf("Reaping VM %s...\n", ref)
+	}
+
+	vm, err := c.FindVMContext(ctx, ref, true)
+	if err != nil {
+		return err
+	}
+
+	err = c.EnsureOffContext(ctx, vm)
+	if err != nil {
+		return err
+	}
+
+	return c.DestroyContext(ctx, vm)
+}
+
+// leaseOption is the extraConfig entry which records when a lease expires
+func leaseOption(expires time.Time) *types.OptionValue {
+	return &types.OptionValue{
+		Key:   LeaseExpiryKey,
+		Value: expires.UTC().Format(time.RFC3339),
+	}
+}
+
+// leaseExpiry finds the lease in the VM's extraConfig.  An unreadable lease is
+// ignored, rather than risk destroying a VM which should be kept.
+func leaseExpiry(vm *mo.VirtualMachine) *time.Time {
+	if vm.Config == nil {
+		return nil
+	}
+
+	for _, bov := range vm.Config.ExtraConfig {
+		ov := bov.GetOptionValue()
+		if ov.Key != LeaseExpiryKey {
+			continue
+		}
+
+		s, ok := ov.Value.(string)
+		if !ok {
+			return nil
+		}
+		expires, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil
+		}
+		return &expires
+	}
+
+	return nil
+}
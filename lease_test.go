@@ -0,0 +1,69 @@
+package vcon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestLeaseExpiry(t *testing.T) {
+	expires := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		config   *types.VirtualMachineConfigInfo
+		expected *time.Time
+	}{
+		{name: "no configuration"},
+		{name: "no lease", config: &types.VirtualMachineConfigInfo{}},
+		{
+			name: "lease",
+			config: &types.VirtualMachineConfigInfo{
+				ExtraConfig: []types.BaseOptionValue{
+					&types.OptionValue{Key: "guestinfo.userdata", Value: "data"},
+					leaseOption(expires),
+				},
+			},
+			expected: &expires,
+		},
+		{
+			name: "lease with a zone",
+			config: &types.VirtualMachineConfigInfo{
+				ExtraConfig: []types.BaseOptionValue{
+					&types.OptionValue{Key: LeaseExpiryKey, Value: "2018-06-01T08:00:00-04:00"},
+				},
+			},
+			expected: &expires,
+		},
+		{
+			name: "unreadable lease",
+			config: &types.VirtualMachineConfigInfo{
+				ExtraConfig: []types.BaseOptionValue{
+					&types.OptionValue{Key: LeaseExpiryKey, Value: "tomorrow"},
+				},
+			},
+		},
+		{
+			name: "lease which is not a string",
+			config: &types.VirtualMachineConfigInfo{
+				ExtraConfig: []types.BaseOptionValue{
+					&types.OptionValue{Key: LeaseExpiryKey, Value: 1},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := leaseExpiry(&mo.VirtualMachine{Config: tt.config})
+			switch {
+			case tt.expected == nil && actual != nil:
+				t.Errorf("expected no lease, got %v", actual)
+			case tt.expected != nil && (actual == nil || !actual.Equal(*tt.expected)):
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
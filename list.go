@@ -0,0 +1,304 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// VirtualMachineFilter selects the VMs reported by ListVMs.  A VM must match
+// every field which is set; the zero value matches all VMs.
+type VirtualMachineFilter struct {
+	// Name is a glob pattern, as for path.Match, for the VM's name
+	Name string
+
+	// NameRegexp is a regular expression for the VM's name
+	NameRegexp *regexp.Regexp
+
+	// PowerState is the VM's power state
+	PowerState PowerState
+
+	// Template is whether the VM is a template
+	Template *bool
+
+	// Annotation is a substring of the VM's notes
+	Annotation string
+
+	// OlderThan and NewerThan bound the time since the VM was created.  A VM
+	// without a creation date, which is only recorded by vSphere 6.7 and
+	// later, does not match either bound.
+	OlderThan time.Duration
+	NewerThan time.Duration
+
+	// Expired is whether the VM has a lease which has expired
+	Expired bool
+}
+
+var listProperties = []string{
+	"config.annotation",
+	"config.createDate",
+	"config.extraConfig",
+	"config.template",
+	"guest.net",
+	"name",
+	"network",
+	"runtime.powerState",
+	"summary.config.memorySizeMB",
+	"summary.config.numCpu",
+}
+
+// ListVMs reports the VMs in a folder, which is given as a path like those
+// accepted by FindVM.  An empty path is the data center's root VM folder.  If
+// recursive is set, VMs in subfolders are included.  Unlike ReportVM, this
+// does not wait for the IP addresses of running VMs; the addresses reported
+// are those currently known by VMware Tools.
+func (c *Client) ListVMs(folder string, recursive bool, filter *VirtualMachineFilter) ([]VirtualMachineInfo, error) {
+	return c.ListVMsContext(context.Background(), folder, recursive, filter)
+}
+
+// ListVMsContext is like ListVMs, but uses the provided context
+func (c *Client) ListVMsContext(ctx context.Context, folder string, recursive bool, filter *VirtualMachineFilter) ([]VirtualMachineInfo, error) {
+	if c.Verbose {
+		fmt.Printf("Listing VMs in folder: %s...\n", folder)
+	}
+
+	if filter == nil {
+		filter = &VirtualMachineFilter{}
+	}
+
+	result := []VirtualMachineInfo{}
+	err := func() error {
+		if err := filter.validate(); err != nil {
+			return err
+		}
+
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		folderPath := strings.TrimSuffix(c.makeInventoryPath(folder), "/")
+		_, err := c.Finder.Folder(ctx, folderPath)
+		if err := c.checkErr(ctx, translateFindErr(err, "folder", folder)); err != nil {
+			return errors.Wrapf(err, "Failed to find folder '%s'", folder)
+		}
+
+		pattern := "*"
+		if recursive {
+			pattern = "..."
+		}
+		vms, err := c.Finder.VirtualMachineList(ctx, path.Join(folderPath, pattern))
+		if err != nil {
+			if _, ok := err.(*find.NotFoundError); ok {
+				// The folder is empty
+				return nil
+			}
+		}
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrap(err, "While listing VMs")
+		}
+
+		if len(vms) == 0 {
+			return nil
+		}
+
+		paths := map[types.ManagedObjectReference]string{}
+		refs := make([]types.ManagedObjectReference, 0, len(vms))
+		for _, vm := range vms {
+			paths[vm.Reference()] = vm.InventoryPath
+			refs = append(refs, vm.Reference())
+		}
+
+		pc := property.DefaultCollector(c.Client.Client)
+		moVMs := []mo.VirtualMachine{}
+		err = pc.Retrieve(ctx, refs, listProperties, &moVMs)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrap(err, "While getting properties")
+		}
+
+		now := time.Now()
+		matches := []mo.VirtualMachine{}
+		for _, moVM := range moVMs {
+			if filter.matches(&moVM, now) {
+				matches = append(matches, moVM)
+			}
+		}
+
+		networkNames, err := c.networkNames(ctx, matches)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrap(err, "While getting network names")
+		}
+
+		for i := range matches {
+			result = append(result, c.listInfo(&matches[i], paths[matches[i].Self], networkNames))
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Path < result[j].Path
+		})
+
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrapf(err, "Timeout while listing VMs in '%s'", folder)
+		default:
+			// unknown error
+			err = errors.Wrapf(err, "Got error while listing VMs in '%s'", folder)
+		}
+		return nil, newOperationError("ListVMs", "", err)
+	}
+
+	return result, nil
+}
+
+// validate checks the filter before any VMs are listed, so that a malformed
+// pattern is reported rather than matching nothing
+func (f *VirtualMachineFilter) validate() error {
+	if f.Name != "" {
+		if _, err := path.Match(f.Name, ""); err != nil {
+			return InvalidConfigurationError{Message: fmt.Sprintf("Name pattern '%s' is malformed", f.Name)}
+		}
+	}
+
+	return nil
+}
+
+func (f *VirtualMachineFilter) matches(vm *mo.VirtualMachine, now time.Time) bool {
+	if f.Name != "" {
+		matched, err := path.Match(f.Name, vm.Name)
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	if f.NameRegexp != nil && !f.NameRegexp.MatchString(vm.Name) {
+		return false
+	}
+
+	if f.PowerState != "" && toPowerState(vm.Runtime.PowerState) != f.PowerState {
+		return false
+	}
+
+	var template bool
+	var annotation string
+	var createDate *time.Time
+	if vm.Config != nil {
+		template = vm.Config.Template
+		annotation = vm.Config.Annotation
+		createDate = vm.Config.CreateDate
+	}
+
+	if f.Template != nil && *f.Template != template {
+		return false
+	}
+
+	if f.Annotation != "" && !strings.Contains(annotation, f.Annotation) {
+		return false
+	}
+
+	if f.OlderThan != 0 || f.NewerThan != 0 {
+		if createDate == nil {
+			return false
+		}
+		age := now.Sub(*createDate)
+		if f.OlderThan != 0 && age <= f.OlderThan {
+			return false
+		}
+		if f.NewerThan != 0 && age >= f.NewerThan {
+			return false
+		}
+	}
+
+	if f.Expired {
+		expires := leaseExpiry(vm)
+		if expires == nil || expires.After(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// networkNames gets the names of all the networks used by the VMs, in one
+// request
+func (c *Client) networkNames(ctx context.Context, vms []mo.VirtualMachine) (map[types.ManagedObjectReference]string, error) {
+	names := map[types.ManagedObjectReference]string{}
+
+	refs := []types.ManagedObjectReference{}
+	seen := map[types.ManagedObjectReference]bool{}
+	for _, vm := range vms {
+		for _, ref := range vm.Network {
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	if len(refs) == 0 {
+		return names, nil
+	}
+
+	// Distributed port groups are also read into mo.Network, which is enough
+	// for their names.
+	pc := property.DefaultCollector(c.Client.Client)
+	networks := []mo.Network{}
+	err := pc.Retrieve(ctx, refs, []string{"name"}, &networks)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, network := range networks {
+		names[network.Self] = network.Name
+	}
+
+	return names, nil
+}
+
+func (c *Client) listInfo(vm *mo.VirtualMachine, inventoryPath string, networkNames map[types.ManagedObjectReference]string) VirtualMachineInfo {
+	cpuCount := int(vm.Summary.Config.NumCpu)
+	memorySize := int(vm.Summary.Config.MemorySizeMB)
+
+	d := VirtualMachineInfo{
+		Configuration: &VirtualMachineConfiguration{
+			CPUs:   &cpuCount,
+			Memory: &memorySize,
+		},
+		IPs:        []string{},
+		IsRunning:  vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff,
+		Path:       c.makePath(inventoryPath),
+		PowerState: toPowerState(vm.Runtime.PowerState),
+		Ref:        vm.Self.Value,
+	}
+
+	if vm.Config != nil {
+		d.Annotation = vm.Config.Annotation
+		d.Created = vm.Config.CreateDate
+	}
+
+	if len(vm.Network) > 0 {
+		if name, ok := networkNames[vm.Network[0]]; ok {
+			d.Configuration.Network = &name
+		}
+	}
+
+	d.LeaseExpires = leaseExpiry(vm)
+
+	if vm.Guest != nil {
+		for _, nic := range vm.Guest.Net {
+			d.IPs = append(d.IPs, nic.IpAddress...)
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,83 @@
+package vcon
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestVirtualMachineFilterMatches(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) *time.Time {
+		created := now.AddDate(0, 0, -days)
+		return &created
+	}
+	newVM := func(name string, ps types.VirtualMachinePowerState, config *types.VirtualMachineConfigInfo) mo.VirtualMachine {
+		vm := mo.VirtualMachine{Config: config}
+		vm.Name = name
+		vm.Runtime.PowerState = ps
+		return vm
+	}
+
+	vms := []mo.VirtualMachine{
+		newVM("web-1", types.VirtualMachinePowerStatePoweredOn, &types.VirtualMachineConfigInfo{
+			Annotation: "Owner: alice",
+			CreateDate: daysAgo(1),
+		}),
+		newVM("web-2", types.VirtualMachinePowerStatePoweredOff, &types.VirtualMachineConfigInfo{
+			CreateDate: daysAgo(30),
+			ExtraConfig: []types.BaseOptionValue{
+				leaseOption(now.Add(-time.Hour)),
+			},
+		}),
+		newVM("db-1", types.VirtualMachinePowerStateSuspended, &types.VirtualMachineConfigInfo{
+			ExtraConfig: []types.BaseOptionValue{
+				leaseOption(now.Add(time.Hour)),
+			},
+		}),
+		newVM("template", types.VirtualMachinePowerStatePoweredOff, &types.VirtualMachineConfigInfo{
+			Template: true,
+		}),
+		newVM("unconfigured", types.VirtualMachinePowerStatePoweredOff, nil),
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		filter   VirtualMachineFilter
+		expected []string
+	}{
+		{name: "everything", expected: []string{"web-1", "web-2", "db-1", "template", "unconfigured"}},
+		{name: "name", filter: VirtualMachineFilter{Name: "web-*"}, expected: []string{"web-1", "web-2"}},
+		{name: "bad name pattern", filter: VirtualMachineFilter{Name: "["}, expected: []string{}},
+		{name: "name regexp", filter: VirtualMachineFilter{NameRegexp: regexp.MustCompile("-1$")}, expected: []string{"web-1", "db-1"}},
+		{name: "power state", filter: VirtualMachineFilter{PowerState: PoweredOff}, expected: []string{"web-2", "template", "unconfigured"}},
+		{name: "template", filter: VirtualMachineFilter{Template: &yes}, expected: []string{"template"}},
+		{name: "not a template", filter: VirtualMachineFilter{Template: &no}, expected: []string{"web-1", "web-2", "db-1", "unconfigured"}},
+		{name: "annotation", filter: VirtualMachineFilter{Annotation: "alice"}, expected: []string{"web-1"}},
+		{name: "older than", filter: VirtualMachineFilter{OlderThan: 7 * 24 * time.Hour}, expected: []string{"web-2"}},
+		{name: "newer than", filter: VirtualMachineFilter{NewerThan: 7 * 24 * time.Hour}, expected: []string{"web-1"}},
+		{name: "between", filter: VirtualMachineFilter{OlderThan: time.Hour, NewerThan: 60 * 24 * time.Hour}, expected: []string{"web-1", "web-2"}},
+		{name: "expired", filter: VirtualMachineFilter{Expired: true}, expected: []string{"web-2"}},
+		{name: "every field", filter: VirtualMachineFilter{Name: "web-*", PowerState: PoweredOn, Annotation: "Owner"}, expected: []string{"web-1"}},
+		{name: "nothing", filter: VirtualMachineFilter{Name: "web-*", PowerState: Suspended}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := []string{}
+			for i := range vms {
+				if tt.filter.matches(&vms[i], now) {
+					actual = append(actual, vms[i].Name)
+				}
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/RallyTools/vcon"
@@ -10,11 +9,10 @@ import (
 
 func main() {
 	rootCmd := cmd.InitializeCommands()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		if cerr, ok := err.(vcon.ErrorCoder); ok {
-			os.Exit(cerr.Code())
-		}
-		os.Exit(-1)
+	err := rootCmd.Execute()
+	cmd.Disconnect()
+	if err != nil {
+		cmd.WriteErrorToConsole(err)
+		os.Exit(vcon.ExitCode(err))
 	}
 }
@@ -0,0 +1,159 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+)
+
+// VMTarget identifies a VM by path, as for FindVM, or by ref if IsRef is set
+type VMTarget struct {
+	Path  string
+	IsRef bool
+}
+
+// TargetResult reports what happened to one of the targets of ForEachVM
+type TargetResult struct {
+	Target VMTarget
+
+	// VM is the target's VM, if it was found
+	VM *VirtualMachine
+
+	// Value is what the function returned for the VM
+	Value interface{}
+	Err   error
+}
+
+// VMFunc is called by ForEachVM for each VM.  The index is the position of
+// the VM's target.
+type VMFunc func(ctx context.Context, index int, vm *VirtualMachine) (interface{}, error)
+
+// globCharacters are those which make a path a pattern, as for path.Match
+const globCharacters = "*?["
+
+// ExpandTargets replaces each target whose path is a glob pattern, such as
+// `/Engineering/TeamSharks/temporary VMs/ci-*`, with the paths of the VMs
+// which match it, in order.  Each path segment may be a pattern.  A pattern
+// which matches no VMs is kept as it is, so that a VM whose name merely
+// contains a "[" is still found, and a pattern which was expected to match
+// is reported as not found.  Repeated targets are dropped.
+func (c *Client) ExpandTargets(targets []VMTarget) ([]VMTarget, error) {
+	return c.ExpandTargetsContext(context.Background(), targets)
+}
+
+// ExpandTargetsContext is like ExpandTargets, but uses the provided context
+func (c *Client) ExpandTargetsContext(ctx context.Context, targets []VMTarget) ([]VMTarget, error) {
+	expanded := []VMTarget{}
+	seen := map[VMTarget]bool{}
+	add := func(t VMTarget) {
+		if !seen[t] {
+			seen[t] = true
+			expanded = append(expanded, t)
+		}
+	}
+
+	for _, t := range targets {
+		if t.IsRef || !strings.ContainsAny(t.Path, globCharacters) {
+			add(t)
+			continue
+		}
+
+		if c.Verbose {
+			fmt.Printf("Finding VMs matching: %s...\n", t.Path)
+		}
+
+		var paths []string
+		err := func() error {
+			ctx, cancelFn := c.withTimeout(ctx)
+			defer cancelFn()
+
+			vms, err := c.Finder.VirtualMachineList(ctx, c.makeInventoryPath(t.Path))
+			if _, ok := err.(*find.NotFoundError); ok {
+				return nil
+			}
+			if err := c.checkErr(ctx, err); err != nil {
+				return err
+			}
+
+			for _, vm := range vms {
+				paths = append(paths, c.makePath(vm.InventoryPath))
+			}
+			sort.Strings(paths)
+
+			return nil
+		}()
+
+		if err != nil {
+			switch errors.Cause(err).(type) {
+			case TimeoutExceededError:
+				// handle specifically
+				err = errors.Wrapf(err, "Timeout while finding VMs matching '%s'", t.Path)
+			default:
+				// unknown error
+				err = errors.Wrapf(err, "Got error while finding VMs matching '%s'", t.Path)
+			}
+			return nil, newOperationError("ExpandTargets", "", err)
+		}
+
+		if len(paths) == 0 {
+			add(t)
+			continue
+		}
+		for _, p := range paths {
+			add(VMTarget{Path: p})
+		}
+	}
+
+	return expanded, nil
+}
+
+// ForEachVM finds the VM of each target, with the requested properties, and
+// calls fn with it.  Up to concurrency VMs are handled at once; if it is 0,
+// all of them are.  A failure for one target does not stop the others; it is
+// recorded in that target's result, and the first such error is returned.
+// Glob patterns are not expanded; see ExpandTargets.
+func (c *Client) ForEachVM(targets []VMTarget, concurrency int, properties []string, fn VMFunc) ([]TargetResult, error) {
+	return c.ForEachVMContext(context.Background(), targets, concurrency, properties, fn)
+}
+
+// ForEachVMContext is like ForEachVM, but uses the provided context
+func (c *Client) ForEachVMContext(ctx context.Context, targets []VMTarget, concurrency int, properties []string, fn VMFunc) ([]TargetResult, error) {
+	if concurrency <= 0 || concurrency > len(targets) {
+		concurrency = len(targets)
+	}
+
+	results := make([]TargetResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		results[i].Target = t
+
+		wg.Add(1)
+		go func(i int, t VMTarget) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := &results[i]
+			r.VM, r.Err = c.FindVMContext(ctx, t.Path, t.IsRef, properties...)
+			if r.Err == nil {
+				r.Value, r.Err = fn(ctx, i, r.VM)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r.Err != nil {
+			return results, r.Err
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,182 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// DryRunRequest is a vSphere request which a Client in dry-run mode did not
+// submit.  Request is the request's body, such as a types.ReconfigVM_Task
+// with its VirtualMachineConfigSpec.
+type DryRunRequest struct {
+	Method  string      `json:"method"`
+	Ref     string      `json:"ref"`
+	Request interface{} `json:"request"`
+}
+
+// dryRunState keeps what a Client in dry-run mode would have done, so that
+// later operations see its effects
+type dryRunState struct {
+	mu       sync.Mutex
+	requests []DryRunRequest
+
+	// powerStates are the power states VMs would have, by ref
+	powerStates map[string]types.VirtualMachinePowerState
+
+	// clones are the sources of VMs which would have been cloned, by the
+	// placeholder ref given to each new VM
+	clones map[string]types.ManagedObjectReference
+}
+
+// DryRunRequests returns the requests which were not submitted because the
+// Client is in dry-run mode, in order
+func (c *Client) DryRunRequests() []DryRunRequest {
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	requests := make([]DryRunRequest, len(c.dryRunState.requests))
+	copy(requests, c.dryRunState.requests)
+	return requests
+}
+
+// dryRun records the request, and reports whether the Client is in dry-run
+// mode; if it is, the request must not be submitted
+func (c *Client) dryRun(method string, ref types.ManagedObjectReference, request interface{}) bool {
+	if !c.DryRun {
+		return false
+	}
+
+	if c.Verbose {
+		fmt.Printf("Dry run; not calling %s on %s\n", method, ref.Value)
+	}
+
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	c.dryRunState.requests = append(c.dryRunState.requests, DryRunRequest{
+		Method:  method,
+		Ref:     ref.Value,
+		Request: request,
+	})
+
+	return true
+}
+
+// powerState gets the VM's power state, or in dry-run mode, the state it
+// would have been left in
+func (c *Client) powerState(ctx context.Context, vm *VirtualMachine) (types.VirtualMachinePowerState, error) {
+	if ps, ok := c.dryRunPowerState(vm); ok {
+		return ps, nil
+	}
+
+	return vm.VM.PowerState(ctx)
+}
+
+// dryRunPowerState returns the state a VM would have been left in, if it has
+// been changed in dry-run mode
+func (c *Client) dryRunPowerState(vm *VirtualMachine) (types.VirtualMachinePowerState, bool) {
+	if !c.DryRun {
+		return "", false
+	}
+
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	ps, ok := c.dryRunState.powerStates[vm.Ref.Value]
+	return ps, ok
+}
+
+// setDryRunPowerState records the state a VM would have been left in
+func (c *Client) setDryRunPowerState(vm *VirtualMachine, ps types.VirtualMachinePowerState) {
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	if c.dryRunState.powerStates == nil {
+		c.dryRunState.powerStates = map[string]types.VirtualMachinePowerState{}
+	}
+	c.dryRunState.powerStates[vm.Ref.Value] = ps
+}
+
+// dryRunClone stands in for a VM which would have been cloned.  It has a
+// placeholder ref, but reads come from the source, which the clone would
+// have matched.  The clone would start powered off.
+func (c *Client) dryRunClone(source *VirtualMachine, name string) *VirtualMachine {
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	if c.dryRunState.clones == nil {
+		c.dryRunState.clones = map[string]types.ManagedObjectReference{}
+	}
+	if c.dryRunState.powerStates == nil {
+		c.dryRunState.powerStates = map[string]types.VirtualMachinePowerState{}
+	}
+
+	ref := types.ManagedObjectReference{
+		Type:  "VirtualMachine",
+		Value: fmt.Sprintf("(dry run %d: %s)", len(c.dryRunState.clones)+1, name),
+	}
+	c.dryRunState.clones[ref.Value] = c.dryRunSource(source.VM.Reference())
+	c.dryRunState.powerStates[ref.Value] = types.VirtualMachinePowerStatePoweredOff
+
+	return &VirtualMachine{
+		Ref: ref,
+		VM:  object.NewVirtualMachine(c.Client.Client, c.dryRunState.clones[ref.Value]),
+	}
+}
+
+// dryRunSource returns the ref to read in place of the provided one; for a VM
+// which would have been cloned, it is the clone's source.  The state must be
+// locked by the caller.
+func (c *Client) dryRunSource(ref types.ManagedObjectReference) types.ManagedObjectReference {
+	if source, ok := c.dryRunState.clones[ref.Value]; ok {
+		return source
+	}
+	return ref
+}
+
+// dryRunCloned reports whether the VM stands in for one which would have been
+// cloned
+func (c *Client) dryRunCloned(vm *VirtualMachine) bool {
+	if !c.DryRun {
+		return false
+	}
+
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	_, ok := c.dryRunState.clones[vm.Ref.Value]
+	return ok
+}
+
+// resolveDryRunRef returns the ref to read in place of the provided one, as
+// for dryRunSource
+func (c *Client) resolveDryRunRef(ref types.ManagedObjectReference) types.ManagedObjectReference {
+	if !c.DryRun {
+		return ref
+	}
+
+	c.dryRunState.mu.Lock()
+	defer c.dryRunState.mu.Unlock()
+
+	return c.dryRunSource(ref)
+}
+
+// editDeviceRequest is the request which VirtualMachine.EditDevice makes for
+// a device which is not a disk
+func editDeviceRequest(vm *VirtualMachine, device types.BaseVirtualDevice) types.ReconfigVM_Task {
+	return types.ReconfigVM_Task{
+		This: vm.Ref,
+		Spec: types.VirtualMachineConfigSpec{
+			DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+				&types.VirtualDeviceConfigSpec{
+					Operation: types.VirtualDeviceConfigSpecOperationEdit,
+					Device:    device,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,615 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// EnvironmentKey is the extraConfig key which records the environment that a
+// VM belongs to, so that VMs removed from the environment can be found
+const EnvironmentKey = "vcon.environment"
+
+// Actions in a PlannedChange
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDestroy = "destroy"
+	ActionNone    = "none"
+)
+
+// Environment describes a set of VMs which should exist
+type Environment struct {
+	// Name is recorded on each VM under EnvironmentKey
+	Name string `json:"name"`
+
+	VMs []EnvironmentVM `json:"vms"`
+}
+
+// EnvironmentVM describes one VM of an Environment
+type EnvironmentVM struct {
+	Name   string `json:"name"`
+	Folder string `json:"folder"`
+
+	// Source is the template or VM the VM is cloned from, and ResourcePool is
+	// where it is placed, as for Clone
+	Source       string `json:"source"`
+	ResourcePool string `json:"resourcePool,omitempty"`
+
+	// Linked creates a linked clone from the source's current snapshot
+	Linked bool `json:"linked,omitempty"`
+
+	// Configuration is applied when the VM is created.  For an existing VM,
+	// only its cpus, memory, and network are compared and changed.
+	Configuration *VirtualMachineConfiguration `json:"configuration,omitempty"`
+
+	// Note replaces the VM's notes, if it is set
+	Note *string `json:"note,omitempty"`
+
+	// PowerState is the state the VM is left in; if it is empty, the state is
+	// not changed, and a new VM is left off
+	PowerState PowerState `json:"powerState,omitempty"`
+
+	// Snapshots are created if the VM does not have a snapshot of that name.
+	// Snapshots are never removed.
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+// PlannedChange describes what applying an environment does to one VM, and
+// once it has been applied, what happened
+type PlannedChange struct {
+	Action  string   `json:"action"`
+	Path    string   `json:"path"`
+	Ref     string   `json:"ref,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+
+	Applied    bool   `json:"applied,omitempty"`
+	RolledBack bool   `json:"rolledBack,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	environment string
+	vm          *EnvironmentVM
+	current     *environmentMember
+}
+
+// environmentMember is the current state of a VM which belongs to, or is
+// named by, an environment
+type environmentMember struct {
+	ref        types.ManagedObjectReference
+	path       string
+	owner      string
+	annotation string
+	powerState PowerState
+	cpus       int
+	memory     int
+	networks   []string
+	snapshots  map[string]bool
+}
+
+var environmentProperties = []string{
+	"config.annotation",
+	"config.extraConfig",
+	"name",
+	"network",
+	"runtime.powerState",
+	"snapshot",
+	"summary.config.memorySizeMB",
+	"summary.config.numCpu",
+}
+
+// PlanEnvironment compares the environment with the VMs in its folders, and
+// plans the changes which would make them match.  VMs are matched by name.
+// If prune is set, VMs in those folders which belong to the environment, but
+// are no longer in it, are planned to be destroyed.  Nothing is changed until
+// the plan is passed to ApplyPlan.
+func (c *Client) PlanEnvironment(env *Environment, prune bool) ([]PlannedChange, error) {
+	return c.PlanEnvironmentContext(context.Background(), env, prune)
+}
+
+// PlanEnvironmentContext is like PlanEnvironment, but uses the provided
+// context
+func (c *Client) PlanEnvironmentContext(ctx context.Context, env *Environment, prune bool) ([]PlannedChange, error) {
+	if c.Verbose {
+		fmt.Printf("Planning environment '%s'...\n", env.Name)
+	}
+
+	members, err := c.environmentMembers(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := []PlannedChange{}
+	wanted := map[string]bool{}
+	for i := range env.VMs {
+		vm := &env.VMs[i]
+		vmPath := path.Join(vm.Folder, vm.Name)
+		key := memberKey(vmPath)
+		if wanted[key] {
+			return nil, InvalidConfigurationError{Message: fmt.Sprintf("The environment has more than one VM named '%s'", vmPath)}
+		}
+		wanted[key] = true
+
+		pc := PlannedChange{
+			Path:        vmPath,
+			environment: env.Name,
+			vm:          vm,
+		}
+
+		current, ok := members[key]
+		if !ok {
+			pc.Action = ActionCreate
+			plan = append(plan, pc)
+			continue
+		}
+
+		if current.owner != "" && current.owner != env.Name {
+			return nil, InvalidConfigurationError{Message: fmt.Sprintf("VM '%s' belongs to environment '%s'", vmPath, current.owner)}
+		}
+
+		pc.Ref = current.ref.Value
+		pc.current = current
+		pc.Changes = current.changes(env.Name, vm)
+		pc.Action = ActionNone
+		if len(pc.Changes) != 0 {
+			pc.Action = ActionUpdate
+		}
+		plan = append(plan, pc)
+	}
+
+	if prune {
+		plan = append(plan, orphans(env, members, wanted)...)
+	}
+
+	return plan, nil
+}
+
+// PlanEnvironmentDestroy plans to destroy every VM of the environment which
+// exists, along with any VMs in its folders which belong to it
+func (c *Client) PlanEnvironmentDestroy(env *Environment) ([]PlannedChange, error) {
+	return c.PlanEnvironmentDestroyContext(context.Background(), env)
+}
+
+// PlanEnvironmentDestroyContext is like PlanEnvironmentDestroy, but uses the
+// provided context
+func (c *Client) PlanEnvironmentDestroyContext(ctx context.Context, env *Environment) ([]PlannedChange, error) {
+	if c.Verbose {
+		fmt.Printf("Planning destruction of environment '%s'...\n", env.Name)
+	}
+
+	members, err := c.environmentMembers(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := []PlannedChange{}
+	wanted := map[string]bool{}
+	for _, vm := range env.VMs {
+		vmPath := path.Join(vm.Folder, vm.Name)
+		key := memberKey(vmPath)
+		current, ok := members[key]
+		if !ok || wanted[key] {
+			continue
+		}
+		wanted[key] = true
+
+		if current.owner != "" && current.owner != env.Name {
+			return nil, InvalidConfigurationError{Message: fmt.Sprintf("VM '%s' belongs to environment '%s'", vmPath, current.owner)}
+		}
+
+		plan = append(plan, destroyChange(env, current))
+	}
+
+	return append(plan, orphans(env, members, wanted)...), nil
+}
+
+// ApplyPlan makes the planned changes, in order.  A VM which fails while it
+// is being created is destroyed, so that applying the environment again
+// starts it afresh.  Applying stops at the first failure; the plan is
+// returned with what happened to each VM, along with the error.
+func (c *Client) ApplyPlan(plan []PlannedChange) ([]PlannedChange, error) {
+	return c.ApplyPlanContext(context.Background(), plan)
+}
+
+// ApplyPlanContext is like ApplyPlan, but uses the provided context
+func (c *Client) ApplyPlanContext(ctx context.Context, plan []PlannedChange) ([]PlannedChange, error) {
+	results := make([]PlannedChange, len(plan))
+	copy(results, plan)
+
+	for i := range results {
+		pc := &results[i]
+		if pc.Action == ActionNone {
+			continue
+		}
+
+		if c.Verbose {
+			fmt.Printf("Applying %s of '%s'...\n", pc.Action, pc.Path)
+		}
+
+		var err error
+		switch pc.Action {
+		case ActionCreate:
+			err = c.applyCreate(ctx, pc)
+		case ActionUpdate:
+			err = c.applyUpdate(ctx, pc)
+		case ActionDestroy:
+			err = c.applyDestroy(ctx, pc)
+		default:
+			err = InvalidConfigurationError{Message: fmt.Sprintf("Action '%s' is invalid", pc.Action)}
+		}
+		if err != nil {
+			pc.Error = err.Error()
+			return results, err
+		}
+
+		pc.Applied = true
+	}
+
+	return results, nil
+}
+
+func (c *Client) applyCreate(ctx context.Context, pc *PlannedChange) error {
+	source, err := c.FindVMContext(ctx, pc.vm.Source, false)
+	if err != nil {
+		return err
+	}
+
+	var newVM *VirtualMachine
+	if pc.vm.Linked {
+		newVM, err = c.LinkedCloneContext(ctx, source, nil, pc.vm.Name, pc.vm.Folder, pc.vm.ResourcePool)
+	} else {
+		newVM, err = c.CloneContext(ctx, source, pc.vm.Name, pc.vm.Folder, pc.vm.ResourcePool)
+	}
+	if err != nil {
+		return err
+	}
+	pc.Ref = newVM.Ref.Value
+
+	err = c.prepareMember(ctx, pc, newVM, pc.vm.Configuration, PoweredOff)
+	if err != nil {
+		// If the apply was canceled, the VM should still be cleaned up.
+		rollbackCtx := ctx
+		if ctx.Err() != nil {
+			rollbackCtx = context.Background()
+		}
+		if c.destroyMember(rollbackCtx, newVM) == nil {
+			pc.RolledBack = true
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) applyUpdate(ctx context.Context, pc *PlannedChange) error {
+	vm, err := c.FindVMContext(ctx, pc.Ref, true)
+	if err != nil {
+		return err
+	}
+
+	// Only the hardware which differs is changed
+	desired := pc.vm.Configuration
+	var vmc *VirtualMachineConfiguration
+	if desired != nil {
+		vmc = &VirtualMachineConfiguration{}
+		if desired.CPUs != nil && *desired.CPUs != pc.current.cpus {
+			vmc.CPUs = desired.CPUs
+		}
+		if desired.Memory != nil && *desired.Memory != pc.current.memory {
+			vmc.Memory = desired.Memory
+		}
+		if desired.Network != nil && !pc.current.hasNetwork(*desired.Network) {
+			vmc.Network = desired.Network
+		}
+	}
+
+	return c.prepareMember(ctx, pc, vm, vmc, pc.current.powerState)
+}
+
+func (c *Client) applyDestroy(ctx context.Context, pc *PlannedChange) error {
+	vm, err := c.FindVMContext(ctx, pc.Ref, true)
+	if err != nil {
+		return err
+	}
+
+	return c.destroyMember(ctx, vm)
+}
+
+// prepareMember brings a VM in line with its description: its configuration
+// and ownership, notes, snapshots, and then power state.  Hardware is changed
+// while the VM is off.  The VM's power state is currently powerState.
+func (c *Client) prepareMember(ctx context.Context, pc *PlannedChange, vm *VirtualMachine, vmc *VirtualMachineConfiguration, powerState PowerState) error {
+	desired := pc.vm
+
+	configure := &VirtualMachineConfiguration{}
+	if vmc != nil {
+		instance := *vmc
+		configure = &instance
+	}
+	hardware := configure.CPUs != nil || configure.Memory != nil || configure.Network != nil || len(configure.Disks) != 0 || len(configure.NetworkAdapters) != 0
+
+	if pc.current == nil || pc.current.owner != pc.environment {
+		extraConfig := make(map[string]string, len(configure.ExtraConfig)+1)
+		for key, value := range configure.ExtraConfig {
+			extraConfig[key] = value
+		}
+		extraConfig[EnvironmentKey] = pc.environment
+		configure.ExtraConfig = extraConfig
+	}
+
+	if hardware || len(configure.ExtraConfig) != 0 || configure.Customization != nil {
+		if hardware && powerState != PoweredOff {
+			err := c.EnsureOffContext(ctx, vm)
+			if err != nil {
+				return err
+			}
+			powerState = PoweredOff
+		}
+
+		withNetwork, err := c.FindVMContext(ctx, vm.Ref.Value, true, "network")
+		if err != nil {
+			return err
+		}
+
+		err = c.ConfigureContext(ctx, withNetwork, configure)
+		if err != nil {
+			return err
+		}
+	}
+
+	if desired.Note != nil && (pc.current == nil || pc.current.annotation != *desired.Note) {
+		err := c.AssignNoteContext(ctx, vm, *desired.Note, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range desired.Snapshots {
+		if pc.current != nil && pc.current.snapshots[name] {
+			continue
+		}
+
+		_, err := c.SnapshotCreateContext(ctx, vm, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	target := desired.PowerState
+	if target == "" && pc.current != nil {
+		// Restore the state the VM was in before its hardware was changed
+		target = pc.current.powerState
+	}
+
+	var err error
+	switch {
+	case target == powerState || target == "":
+	case target == PoweredOn:
+		err = c.EnsureOnContext(ctx, vm)
+	case target == PoweredOff:
+		err = c.EnsureOffContext(ctx, vm)
+	case target == Suspended:
+		if powerState == PoweredOff {
+			err = c.EnsureOnContext(ctx, vm)
+		}
+		if err == nil {
+			err = c.SuspendContext(ctx, vm)
+		}
+	default:
+		err = InvalidConfigurationError{Message: fmt.Sprintf("Power state '%s' is invalid", target)}
+	}
+
+	return err
+}
+
+// destroyMember powers off and destroys a VM
+func (c *Client) destroyMember(ctx context.Context, vm *VirtualMachine) error {
+	err := c.EnsureOffContext(ctx, vm)
+	if err != nil {
+		return err
+	}
+
+	return c.DestroyContext(ctx, vm)
+}
+
+// environmentMembers finds the current state of the VMs in each of the
+// environment's folders, by memberKey
+func (c *Client) environmentMembers(ctx context.Context, env *Environment) (map[string]*environmentMember, error) {
+	members := map[string]*environmentMember{}
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		folders := []string{}
+		seen := map[string]bool{}
+		for _, vm := range env.VMs {
+			if !seen[vm.Folder] {
+				seen[vm.Folder] = true
+				folders = append(folders, vm.Folder)
+			}
+		}
+
+		paths := map[types.ManagedObjectReference]string{}
+		refs := []types.ManagedObjectReference{}
+		for _, folder := range folders {
+			folderPath := strings.TrimSuffix(c.makeInventoryPath(folder), "/")
+			_, err := c.Finder.Folder(ctx, folderPath)
+			if err := c.checkErr(ctx, translateFindErr(err, "folder", folder)); err != nil {
+				return errors.Wrapf(err, "Failed to find folder '%s'", folder)
+			}
+
+			vms, err := c.Finder.VirtualMachineList(ctx, path.Join(folderPath, "*"))
+			if _, ok := err.(*find.NotFoundError); ok {
+				// The folder is empty
+				continue
+			}
+			if err := c.checkErr(ctx, err); err != nil {
+				return errors.Wrapf(err, "While listing VMs in '%s'", folder)
+			}
+
+			for _, vm := range vms {
+				paths[vm.Reference()] = vm.InventoryPath
+				refs = append(refs, vm.Reference())
+			}
+		}
+		if len(refs) == 0 {
+			return nil
+		}
+
+		pc := property.DefaultCollector(c.Client.Client)
+		moVMs := []mo.VirtualMachine{}
+		err := pc.Retrieve(ctx, refs, environmentProperties, &moVMs)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrap(err, "While getting properties")
+		}
+
+		networkNames, err := c.networkNames(ctx, moVMs)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrap(err, "While getting network names")
+		}
+
+		for i := range moVMs {
+			m := newEnvironmentMember(&moVMs[i], c.makePath(paths[moVMs[i].Self]), networkNames)
+			members[memberKey(m.path)] = m
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrapf(err, "Timeout while finding VMs of environment '%s'", env.Name)
+		default:
+			// unknown error
+			err = errors.Wrapf(err, "Got error while finding VMs of environment '%s'", env.Name)
+		}
+		return nil, newOperationError("PlanEnvironment", "", err)
+	}
+
+	return members, nil
+}
+
+// memberKey normalizes a VM's path, so that paths with and without a leading
+// slash match
+func memberKey(vmPath string) string {
+	return strings.Trim(path.Clean("/"+vmPath), "/")
+}
+
+func newEnvironmentMember(vm *mo.VirtualMachine, vmPath string, networkNames map[types.ManagedObjectReference]string) *environmentMember {
+	m := &environmentMember{
+		ref:        vm.Self,
+		path:       vmPath,
+		powerState: toPowerState(vm.Runtime.PowerState),
+		cpus:       int(vm.Summary.Config.NumCpu),
+		memory:     int(vm.Summary.Config.MemorySizeMB),
+		snapshots:  map[string]bool{},
+	}
+
+	if vm.Config != nil {
+		m.annotation = vm.Config.Annotation
+		for _, bov := range vm.Config.ExtraConfig {
+			ov := bov.GetOptionValue()
+			if ov.Key == EnvironmentKey {
+				m.owner, _ = ov.Value.(string)
+			}
+		}
+	}
+
+	for _, ref := range vm.Network {
+		m.networks = append(m.networks, networkNames[ref])
+	}
+
+	if vm.Snapshot != nil {
+		var walk func(trees []types.VirtualMachineSnapshotTree)
+		walk = func(trees []types.VirtualMachineSnapshotTree) {
+			for _, tree := range trees {
+				m.snapshots[tree.Name] = true
+				walk(tree.ChildSnapshotList)
+			}
+		}
+		walk(vm.Snapshot.RootSnapshotList)
+	}
+
+	return m
+}
+
+// changes describes how the VM differs from its description
+func (m *environmentMember) changes(environment string, vm *EnvironmentVM) []string {
+	changes := []string{}
+
+	if m.owner != environment {
+		changes = append(changes, fmt.Sprintf("environment: '%s' -> '%s'", m.owner, environment))
+	}
+
+	if vmc := vm.Configuration; vmc != nil {
+		if vmc.CPUs != nil && *vmc.CPUs != m.cpus {
+			changes = append(changes, fmt.Sprintf("cpus: %d -> %d", m.cpus, *vmc.CPUs))
+		}
+		if vmc.Memory != nil && *vmc.Memory != m.memory {
+			changes = append(changes, fmt.Sprintf("memory: %d -> %d", m.memory, *vmc.Memory))
+		}
+		if vmc.Network != nil && !m.hasNetwork(*vmc.Network) {
+			changes = append(changes, fmt.Sprintf("network: '%s' -> '%s'", strings.Join(m.networks, "', '"), *vmc.Network))
+		}
+	}
+
+	if vm.Note != nil && *vm.Note != m.annotation {
+		changes = append(changes, "note")
+	}
+
+	for _, name := range vm.Snapshots {
+		if !m.snapshots[name] {
+			changes = append(changes, fmt.Sprintf("snapshot: create '%s'", name))
+		}
+	}
+
+	if vm.PowerState != "" && vm.PowerState != m.powerState {
+		changes = append(changes, fmt.Sprintf("power: %s -> %s", m.powerState, vm.PowerState))
+	}
+
+	return changes
+}
+
+func (m *environmentMember) hasNetwork(name string) bool {
+	for _, network := range m.networks {
+		if network == name {
+			return true
+		}
+	}
+	return false
+}
+
+// orphans plans to destroy the VMs which belong to the environment, but are
+// not wanted
+func orphans(env *Environment, members map[string]*environmentMember, wanted map[string]bool) []PlannedChange {
+	plan := []PlannedChange{}
+	for key, m := range members {
+		if m.owner == env.Name && !wanted[key] {
+			plan = append(plan, destroyChange(env, m))
+		}
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Path < plan[j].Path
+	})
+
+	return plan
+}
+
+func destroyChange(env *Environment, m *environmentMember) PlannedChange {
+	return PlannedChange{
+		Action:      ActionDestroy,
+		Path:        m.path,
+		Ref:         m.ref.Value,
+		environment: env.Name,
+		current:     m,
+	}
+}
@@ -0,0 +1,405 @@
+package vcon
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/progress"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// CopyResult describes a file or directory copied to or from the guest
+type CopyResult struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	IsDirectory bool   `json:"isDirectory"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// CopyToGuest copies a local file or directory into the guest OS through
+// VMware Tools.  The destination is the path of the copy in the guest; an
+// existing file is overwritten.  A directory is streamed as a tar archive, and
+// unpacked by the guest's `tar`.  The VM must be running, with VMware Tools.
+func (c *Client) CopyToGuest(vm *VirtualMachine, creds *GuestCredentials, source, destination string) (*CopyResult, error) {
+	return c.CopyToGuestContext(context.Background(), vm, creds, source, destination)
+}
+
+// CopyToGuestContext is like CopyToGuest, but uses the provided context
+func (c *Client) CopyToGuestContext(ctx context.Context, vm *VirtualMachine, creds *GuestCredentials, source, destination string) (*CopyResult, error) {
+	if c.Verbose {
+		fmt.Printf("Copying '%s' to guest...\n", source)
+	}
+
+	var result *CopyResult
+	err := func() error {
+		fi, err := os.Stat(source)
+		if err != nil {
+			return NotFoundError{Kind: "local file", Path: source}
+		}
+
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		g, err := c.newGuestOperations(ctx, vm, creds)
+		if err != nil {
+			return err
+		}
+
+		result = &CopyResult{
+			Source:      source,
+			Destination: destination,
+			IsDirectory: fi.IsDir(),
+		}
+
+		if !fi.IsDir() {
+			f, err := os.Open(source)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			result.Bytes = fi.Size()
+			err = g.upload(ctx, f, fi.Size(), destination, g.fileAttributes(fi.Mode()), c.progressSinker(source))
+			return errors.Wrap(err, "While uploading file")
+		}
+
+		archive, err := writeTar(source)
+		if err != nil {
+			return errors.Wrap(err, "While archiving directory")
+		}
+		defer func() {
+			archive.Close()
+			os.Remove(archive.Name())
+		}()
+
+		afi, err := archive.Stat()
+		if err != nil {
+			return err
+		}
+		result.Bytes = afi.Size()
+
+		remoteArchive, err := g.createTempFile(ctx, "vcon-cp", ".tar")
+		if err != nil {
+			return errors.Wrap(err, "While creating file for archive")
+		}
+		defer g.deleteFile(remoteArchive)
+
+		err = g.upload(ctx, archive, afi.Size(), remoteArchive, g.fileAttributes(0600), c.progressSinker(source))
+		if err != nil {
+			return errors.Wrap(err, "While uploading archive")
+		}
+
+		err = g.runTar(ctx, g.extractScript(remoteArchive, destination))
+		return errors.Wrap(err, "While unpacking archive")
+	}()
+
+	if err != nil {
+		return nil, copyError("CopyToGuest", vm, err)
+	}
+
+	return result, nil
+}
+
+// CopyFromGuest copies a file or directory from the guest OS through VMware
+// Tools.  The destination is the local path of the copy; an existing file is
+// overwritten.  A directory is packed by the guest's `tar`, and unpacked
+// locally.  The VM must be running, with VMware Tools.
+func (c *Client) CopyFromGuest(vm *VirtualMachine, creds *GuestCredentials, source, destination string) (*CopyResult, error) {
+	return c.CopyFromGuestContext(context.Background(), vm, creds, source, destination)
+}
+
+// CopyFromGuestContext is like CopyFromGuest, but uses the provided context
+func (c *Client) CopyFromGuestContext(ctx context.Context, vm *VirtualMachine, creds *GuestCredentials, source, destination string) (*CopyResult, error) {
+	if c.Verbose {
+		fmt.Printf("Copying '%s' from guest...\n", source)
+	}
+
+	var result *CopyResult
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		g, err := c.newGuestOperations(ctx, vm, creds)
+		if err != nil {
+			return err
+		}
+
+		result = &CopyResult{
+			Source:      source,
+			Destination: destination,
+		}
+
+		r, size, attrs, err := g.download(ctx, source, c.progressSinker(source))
+		if err == nil {
+			defer r.Close()
+
+			result.Bytes = size
+			err = writeFile(r, destination, attrs)
+			return errors.Wrap(err, "While writing file")
+		}
+		if tfe, ok := errors.Cause(err).(TaskFailedError); !ok || tfe.Fault != "NotAFile" {
+			return errors.Wrap(err, "While downloading file")
+		}
+
+		// The source is a directory; have the guest pack it up
+		result.IsDirectory = true
+
+		remoteArchive, err := g.createTempFile(ctx, "vcon-cp", ".tar")
+		if err != nil {
+			return errors.Wrap(err, "While creating file for archive")
+		}
+		defer g.deleteFile(remoteArchive)
+
+		err = g.runTar(ctx, g.createScript(source, remoteArchive))
+		if err != nil {
+			return errors.Wrap(err, "While archiving directory")
+		}
+
+		r, size, _, err = g.download(ctx, remoteArchive, c.progressSinker(source))
+		if err != nil {
+			return errors.Wrap(err, "While downloading archive")
+		}
+		defer r.Close()
+
+		result.Bytes = size
+		err = readTar(r, destination)
+		return errors.Wrap(err, "While unpacking archive")
+	}()
+
+	if err != nil {
+		return nil, copyError("CopyFromGuest", vm, err)
+	}
+
+	return result, nil
+}
+
+func copyError(operation string, vm *VirtualMachine, err error) error {
+	switch errors.Cause(err).(type) {
+	case TimeoutExceededError:
+		// handle specifically
+		err = errors.Wrap(err, "Timeout while copying files")
+	default:
+		// unknown error
+		err = errors.Wrap(err, "Got error while copying files")
+	}
+	return newOperationError(operation, vm.Ref.Value, err)
+}
+
+// progressSinker prints the progress of a transfer in 10% steps, if the Client
+// is verbose
+func (c *Client) progressSinker(name string) progress.Sinker {
+	if !c.Verbose {
+		return nil
+	}
+
+	return progress.SinkFunc(func() chan<- progress.Report {
+		ch := make(chan progress.Report)
+		go func() {
+			step := -1
+			for r := range ch {
+				if r.Error() != nil {
+					continue
+				}
+				if s := int(r.Percentage()) / 10; s > step {
+					step = s
+					fmt.Printf("  %s: %d%% (%s)\n", name, step*10, r.Detail())
+				}
+			}
+		}()
+		return ch
+	})
+}
+
+// fileAttributes describes a file to be written in the guest.  On POSIX
+// guests, the file gets the provided permissions.
+func (g *guestOperations) fileAttributes(mode os.FileMode) types.BaseGuestFileAttributes {
+	if g.windows {
+		return &types.GuestWindowsFileAttributes{}
+	}
+	return &types.GuestPosixFileAttributes{Permissions: int64(mode.Perm())}
+}
+
+// createScript packs the contents of the guest directory into the archive
+func (g *guestOperations) createScript(dir, archive string) string {
+	return g.quote([]string{"tar", "-cf", archive, "-C", dir, "."})
+}
+
+// extractScript unpacks the archive into the guest directory, creating it if
+// needed
+func (g *guestOperations) extractScript(archive, dir string) string {
+	if g.windows {
+		return fmt.Sprintf("(if not exist %s mkdir %s) && %s", g.quote([]string{dir}), g.quote([]string{dir}), g.quote([]string{"tar", "-xf", archive, "-C", dir}))
+	}
+	return fmt.Sprintf("%s && %s", g.quote([]string{"mkdir", "-p", dir}), g.quote([]string{"tar", "-xpf", archive, "-C", dir}))
+}
+
+// runTar runs a tar script in the guest, and fails if it does not succeed
+func (g *guestOperations) runTar(ctx context.Context, script string) error {
+	result, err := g.run(ctx, script)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return TaskFailedError{
+			Fault:   "GuestCommandFailed",
+			Message: fmt.Sprintf("tar exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr)),
+		}
+	}
+
+	return nil
+}
+
+// writeFile writes the local file from the reader.  If the guest reported
+// POSIX permissions, they are applied to the file.
+func writeFile(r io.Reader, path string, attrs types.BaseGuestFileAttributes) error {
+	mode := os.FileMode(0644)
+	if pa, ok := attrs.(*types.GuestPosixFileAttributes); ok && pa.Permissions != 0 {
+		mode = os.FileMode(pa.Permissions).Perm()
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(path, mode)
+}
+
+// writeTar packs the contents of the local directory into a temporary tar
+// file, so that its size is known before it is uploaded.  The file is
+// returned open, at its start; the caller should remove it.
+func writeTar(dir string) (*os.File, error) {
+	f, err := ioutil.TempFile("", "vcon-cp")
+	if err != nil {
+		return nil, err
+	}
+
+	err = func() error {
+		tw := tar.NewWriter(f)
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || rel == "." {
+				return err
+			}
+
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+
+			hdr, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			if fi.IsDir() {
+				hdr.Name += "/"
+			}
+
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+
+			src, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+
+			_, err = io.Copy(tw, src)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err = tw.Close(); err != nil {
+			return err
+		}
+
+		_, err = f.Seek(0, io.SeekStart)
+		return err
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readTar unpacks a tar stream into the local directory, creating it if
+// needed.  Entries which would land outside the directory are refused.
+func readTar(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return InvalidConfigurationError{Message: fmt.Sprintf("Archive entry '%s' is outside the destination", hdr.Name)}
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			if err = os.Chmod(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err = writeFile(tr, path, &types.GuestPosixFileAttributes{Permissions: int64(mode)}); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(path)
+			if err = os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// NetworkAdapter describes one of a VM's network adapters.  When configuring a
+// VM, an adapter with a Name changes that existing adapter, and an adapter
+// without one is added.
+type NetworkAdapter struct {
+	// Name identifies an existing adapter, either as reported by `info`, such
+	// as "ethernet-0", or by its label, such as "Network adapter 1"
+	Name string `json:"name,omitempty"`
+
+	// Network is the name of the network or distributed port group the
+	// adapter is attached to
+	Network string `json:"network,omitempty"`
+
+	// Type is the adapter type for a new adapter, such as "vmxnet3" (the
+	// default), "e1000", or "e1000e"
+	Type string `json:"type,omitempty"`
+
+	// MAC is the adapter's MAC address.  If it is set, the address is
+	// assigned manually; otherwise vSphere generates one for new adapters.
+	MAC string `json:"mac,omitempty"`
+
+	// Connected and StartConnected set whether the adapter is connected now,
+	// and when the VM is powered on
+	Connected      *bool `json:"connected,omitempty"`
+	StartConnected *bool `json:"startConnected,omitempty"`
+
+	// IPs are the addresses the guest reports for the adapter; they are only
+	// reported
+	IPs []string `json:"ips,omitempty"`
+
+	// Remove detaches the existing adapter
+	Remove bool `json:"remove,omitempty"`
+}
+
+// networkAdapterChanges builds the device changes which apply the network
+// adapter configuration to the VM.  The devices are returned with the new
+// adapters added.
+func (c *Client) networkAdapterChanges(ctx context.Context, devices object.VirtualDeviceList, adapters []NetworkAdapter) ([]types.BaseVirtualDeviceConfigSpec, object.VirtualDeviceList, error) {
+	var changes []types.BaseVirtualDeviceConfigSpec
+	for _, adapter := range adapters {
+		var change *types.VirtualDeviceConfigSpec
+		var err error
+		if adapter.Name == "" {
+			change, err = c.addNetworkAdapter(ctx, devices, adapter)
+			if err == nil {
+				// Keep the new adapter in the list, so that the next device
+				// gets its own key
+				devices = append(devices, change.Device)
+			}
+		} else {
+			change, err = c.editNetworkAdapter(ctx, devices, adapter)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, devices, nil
+}
+
+// addNetworkAdapter creates a new adapter attached to its network
+func (c *Client) addNetworkAdapter(ctx context.Context, devices object.VirtualDeviceList, adapter NetworkAdapter) (*types.VirtualDeviceConfigSpec, error) {
+	if adapter.Remove {
+		return nil, InvalidConfigurationError{Message: "A network adapter to remove must be identified by name"}
+	}
+	if adapter.Network == "" {
+		return nil, InvalidConfigurationError{Message: "A new network adapter requires a network"}
+	}
+
+	backing, err := c.networkBacking(ctx, adapter.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	adapterType := adapter.Type
+	if adapterType == "" {
+		adapterType = "vmxnet3"
+	}
+	device, err := devices.CreateEthernetCard(adapterType, backing)
+	if err != nil {
+		return nil, InvalidConfigurationError{Message: fmt.Sprintf("Network adapter type '%s' is invalid", adapter.Type)}
+	}
+	device.GetVirtualDevice().Key = devices.NewKey()
+
+	// New adapters are connected when the VM is powered on, unless set
+	// otherwise
+	device.GetVirtualDevice().Connectable = &types.VirtualDeviceConnectInfo{
+		StartConnected:    true,
+		AllowGuestControl: true,
+	}
+	setNetworkAdapter(device.(types.BaseVirtualEthernetCard), adapter)
+
+	return &types.VirtualDeviceConfigSpec{
+		Operation: types.VirtualDeviceConfigSpecOperationAdd,
+		Device:    device,
+	}, nil
+}
+
+// editNetworkAdapter changes the network, MAC address, or connection of an
+// existing adapter, or removes it.  Its type cannot be changed.
+func (c *Client) editNetworkAdapter(ctx context.Context, devices object.VirtualDeviceList, adapter NetworkAdapter) (*types.VirtualDeviceConfigSpec, error) {
+	device := findNetworkAdapter(devices, adapter.Name)
+	if device == nil {
+		return nil, NotFoundError{Kind: "network adapter", Path: adapter.Name}
+	}
+
+	if adapter.Remove {
+		return &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationRemove,
+			Device:    device,
+		}, nil
+	}
+
+	if adapter.Type != "" && adapter.Type != networkAdapterType(devices, device) {
+		return nil, InvalidConfigurationError{
+			Message: fmt.Sprintf("The type of network adapter '%s' cannot be changed; remove it and add another", adapter.Name),
+		}
+	}
+
+	if adapter.Network != "" {
+		backing, err := c.networkBacking(ctx, adapter.Network)
+		if err != nil {
+			return nil, err
+		}
+		device.GetVirtualDevice().Backing = backing
+	}
+
+	if device.GetVirtualDevice().Connectable == nil {
+		device.GetVirtualDevice().Connectable = &types.VirtualDeviceConnectInfo{}
+	}
+	setNetworkAdapter(device.(types.BaseVirtualEthernetCard), adapter)
+
+	return &types.VirtualDeviceConfigSpec{
+		Operation: types.VirtualDeviceConfigSpecOperationEdit,
+		Device:    device,
+	}, nil
+}
+
+// networkBacking finds the backing which attaches an adapter to the named
+// network or distributed port group
+func (c *Client) networkBacking(ctx context.Context, name string) (types.BaseVirtualDeviceBackingInfo, error) {
+	network, err := c.Finder.Network(ctx, name)
+	if err = c.checkErr(ctx, translateFindErr(err, "network", name)); err != nil {
+		return nil, err
+	}
+
+	if network == nil {
+		return nil, NotFoundError{Kind: "network", Path: name}
+	}
+
+	backing, err := network.EthernetCardBackingInfo(ctx)
+	if err = c.checkErr(ctx, err); err != nil {
+		return nil, err
+	}
+
+	return backing, nil
+}
+
+// setNetworkAdapter applies the adapter's MAC address and connection settings
+// to the device
+func setNetworkAdapter(device types.BaseVirtualEthernetCard, adapter NetworkAdapter) {
+	card := device.GetVirtualEthernetCard()
+	if adapter.MAC != "" {
+		card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+		card.MacAddress = adapter.MAC
+	}
+	if adapter.Connected != nil {
+		card.Connectable.Connected = *adapter.Connected
+	}
+	if adapter.StartConnected != nil {
+		card.Connectable.StartConnected = *adapter.StartConnected
+	}
+}
+
+// findNetworkAdapter finds an adapter by its device name or its label
+func findNetworkAdapter(devices object.VirtualDeviceList, name string) types.BaseVirtualDevice {
+	for _, device := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		d := device.GetVirtualDevice()
+		if devices.Name(device) == name || (d.DeviceInfo != nil && d.DeviceInfo.GetDescription().Label == name) {
+			return device
+		}
+	}
+
+	return nil
+}
+
+// networkAdapterType names the adapter's type, such as "vmxnet3"
+func networkAdapterType(devices object.VirtualDeviceList, device types.BaseVirtualDevice) string {
+	return strings.ToLower(strings.TrimPrefix(devices.TypeName(device), "Virtual"))
+}
+
+// reportNetworkAdapters describes the network adapters among the VM's
+// devices.  The IPs are matched to the adapters by MAC address.  Distributed
+// port groups are identified by their keys in the adapters' backings, so
+// their names are looked up in networkNames, which is keyed by ref.
+func reportNetworkAdapters(devices object.VirtualDeviceList, networkNames map[string]string, ips map[string][]string) []NetworkAdapter {
+	adapters := []NetworkAdapter{}
+	for _, device := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		card := device.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+		adapter := NetworkAdapter{
+			Name: devices.Name(device),
+			Type: networkAdapterType(devices, device),
+			MAC:  card.MacAddress,
+			IPs:  ips[card.MacAddress],
+		}
+
+		switch backing := card.Backing.(type) {
+		case *types.VirtualEthernetCardNetworkBackingInfo:
+			adapter.Network = backing.DeviceName
+		case *types.VirtualEthernetCardDistributedVirtualPortBackingInfo:
+			adapter.Network = networkNames[backing.Port.PortgroupKey]
+		case *types.VirtualEthernetCardOpaqueNetworkBackingInfo:
+			adapter.Network = backing.OpaqueNetworkId
+		}
+
+		if card.Connectable != nil {
+			connected := card.Connectable.Connected
+			startConnected := card.Connectable.StartConnected
+			adapter.Connected = &connected
+			adapter.StartConnected = &startConnected
+		}
+
+		adapters = append(adapters, adapter)
+	}
+
+	return adapters
+}
@@ -0,0 +1,302 @@
+package vcon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Kinds of WaitCondition
+const (
+	WaitToolsRunning = "tools-running"
+	WaitIP           = "ip"
+	WaitIPOnNetwork  = "ip-on-network"
+	WaitTCP          = "tcp"
+	WaitHTTP         = "http"
+	WaitGuestInfo    = "guestinfo"
+)
+
+// WaitCondition describes something about a VM to wait for
+type WaitCondition struct {
+	// Spec is the condition as it was written, i.e., "tcp:22@2m"
+	Spec string
+
+	// Kind is one of the Wait constants
+	Kind string
+
+	// Value is the network name for WaitIPOnNetwork, the port for WaitTCP,
+	// the URL for WaitHTTP, or the key for WaitGuestInfo
+	Value string
+
+	// Expected is the value the guestinfo key must have.  If it is empty,
+	// any value will do.
+	Expected string
+
+	// Timeout bounds the wait for this condition.  If it is 0, the client's
+	// timeout is used.
+	Timeout time.Duration
+}
+
+// WaitResult reports when a condition was satisfied.  Elapsed is measured
+// from the start of the wait for the first condition.
+type WaitResult struct {
+	Condition   string     `json:"condition"`
+	Satisfied   bool       `json:"satisfied"`
+	SatisfiedAt *time.Time `json:"satisfiedAt,omitempty"`
+	Elapsed     string     `json:"elapsed"`
+
+	// Value is what satisfied the condition, such as the IP address
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// waitPollInterval is how often the conditions are checked
+const waitPollInterval = 2 * time.Second
+
+// waitProbeTimeout bounds each attempt to connect to a TCP port or fetch a URL
+const waitProbeTimeout = 5 * time.Second
+
+// ipPlaceholder is replaced by the VM's IP address in HTTP conditions
+const ipPlaceholder = "{{ip}}"
+
+// ParseWaitCondition reads a condition written as one of:
+//
+//	tools-running
+//	ip
+//	ip-on-network=NETWORK
+//	tcp:PORT
+//	http://{{ip}}:8080/health (or https://)
+//	guestinfo.KEY=VALUE (or guestinfo.KEY, for any value)
+//
+// Any of these may end with "@DURATION", i.e., "tcp:22@2m", to give the
+// condition its own timeout.
+func ParseWaitCondition(spec string) (*WaitCondition, error) {
+	wc := &WaitCondition{Spec: spec}
+
+	s := spec
+	if i := strings.LastIndex(s, "@"); i != -1 {
+		if d, err := time.ParseDuration(s[i+1:]); err == nil {
+			s = s[:i]
+			wc.Timeout = d
+		}
+	}
+
+	switch {
+	case s == WaitToolsRunning:
+		wc.Kind = WaitToolsRunning
+	case s == WaitIP:
+		wc.Kind = WaitIP
+	case strings.HasPrefix(s, WaitIPOnNetwork+"="):
+		wc.Kind = WaitIPOnNetwork
+		wc.Value = strings.TrimPrefix(s, WaitIPOnNetwork+"=")
+	case strings.HasPrefix(s, WaitTCP+":"):
+		wc.Kind = WaitTCP
+		wc.Value = strings.TrimPrefix(s, WaitTCP+":")
+		if port, err := strconv.Atoi(wc.Value); err != nil || port < 1 || port > 65535 {
+			return nil, InvalidConfigurationError{Message: fmt.Sprintf("wait condition '%s' has an invalid port", spec)}
+		}
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		wc.Kind = WaitHTTP
+		wc.Value = s
+	case strings.HasPrefix(s, WaitGuestInfo+"."):
+		wc.Kind = WaitGuestInfo
+		wc.Value = s
+		if i := strings.Index(s, "="); i != -1 {
+			wc.Value = s[:i]
+			wc.Expected = s[i+1:]
+		}
+	default:
+		return nil, InvalidConfigurationError{
+			Message: fmt.Sprintf("wait condition '%s' is invalid; must be \"tools-running\", \"ip\", \"ip-on-network=NETWORK\", \"tcp:PORT\", an http(s) URL, or \"guestinfo.KEY=VALUE\"", spec),
+		}
+	}
+
+	if wc.Value == "" && wc.Kind != WaitToolsRunning && wc.Kind != WaitIP {
+		return nil, InvalidConfigurationError{Message: fmt.Sprintf("wait condition '%s' is incomplete", spec)}
+	}
+
+	return wc, nil
+}
+
+// WaitFor waits for each of the conditions in turn, and reports when each one
+// was satisfied.  Each condition has its own timeout.  If a condition is not
+// satisfied, the results so far are returned with the error, and the later
+// conditions are not checked.
+func (c *Client) WaitFor(vm *VirtualMachine, conditions []*WaitCondition) ([]WaitResult, error) {
+	return c.WaitForContext(context.Background(), vm, conditions)
+}
+
+// WaitForContext is like WaitFor, but uses the provided context
+func (c *Client) WaitForContext(ctx context.Context, vm *VirtualMachine, conditions []*WaitCondition) ([]WaitResult, error) {
+	if c.DryRun {
+		// Nothing was changed, so there is nothing to wait for
+		return []WaitResult{}, nil
+	}
+
+	start := time.Now()
+	results := []WaitResult{}
+	for _, wc := range conditions {
+		if c.Verbose {
+			fmt.Printf("Waiting for %s...\n", wc.Spec)
+		}
+
+		value, err := c.waitForCondition(ctx, vm, wc)
+
+		result := WaitResult{
+			Condition: wc.Spec,
+			Satisfied: err == nil,
+			Elapsed:   time.Since(start).Truncate(time.Millisecond).String(),
+			Value:     value,
+		}
+		if err != nil {
+			switch errors.Cause(err).(type) {
+			case TimeoutExceededError:
+				// handle specifically
+				err = errors.Wrapf(err, "Timeout while waiting for %s", wc.Spec)
+			default:
+				// unknown error
+				err = errors.Wrapf(err, "Got error while waiting for %s", wc.Spec)
+			}
+			result.Error = err.Error()
+			results = append(results, result)
+			return results, newOperationError("WaitFor", vm.Ref.Value, err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		result.SatisfiedAt = &now
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// waitForCondition polls the VM until the condition is satisfied, and
+// returns what satisfied it
+func (c *Client) waitForCondition(ctx context.Context, vm *VirtualMachine, wc *WaitCondition) (string, error) {
+	timeout := wc.Timeout
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+	cancelFn := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancelFn = context.WithTimeout(ctx, timeout)
+	}
+	defer cancelFn()
+
+	pc := property.DefaultCollector(c.Client.Client)
+	for {
+		var moVM mo.VirtualMachine
+		err := pc.RetrieveOne(ctx, vm.VM.Reference(), []string{"guest", "config.extraConfig"}, &moVM)
+		if err = c.checkWaitErr(ctx, err, timeout); err != nil {
+			return "", err
+		}
+
+		value, ok := checkCondition(ctx, &moVM, wc)
+		if ok {
+			return value, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", c.checkWaitErr(ctx, nil, timeout)
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
+
+// checkWaitErr is like checkErr, but reports the condition's timeout
+func (c *Client) checkWaitErr(ctx context.Context, err error, timeout time.Duration) error {
+	err = c.checkErr(ctx, err)
+	if _, ok := err.(TimeoutExceededError); ok {
+		return TimeoutExceededError{timeout: timeout}
+	}
+	return err
+}
+
+// checkCondition reports whether the VM satisfies the condition now, and what
+// satisfied it
+func checkCondition(ctx context.Context, moVM *mo.VirtualMachine, wc *WaitCondition) (string, bool) {
+	guest := moVM.Guest
+	if guest == nil {
+		guest = &types.GuestInfo{}
+	}
+
+	switch wc.Kind {
+	case WaitToolsRunning:
+		return guest.ToolsVersion, guest.ToolsRunningStatus == string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+	case WaitIP:
+		return guest.IpAddress, guest.IpAddress != ""
+	case WaitIPOnNetwork:
+		for _, nic := range guest.Net {
+			if nic.Network == wc.Value && len(nic.IpAddress) != 0 {
+				return nic.IpAddress[0], true
+			}
+		}
+	case WaitTCP:
+		if guest.IpAddress == "" {
+			return "", false
+		}
+		address := net.JoinHostPort(guest.IpAddress, wc.Value)
+		conn, err := net.DialTimeout("tcp", address, waitProbeTimeout)
+		if err != nil {
+			return "", false
+		}
+		conn.Close()
+		return address, true
+	case WaitHTTP:
+		url := wc.Value
+		if strings.Contains(url, ipPlaceholder) {
+			if guest.IpAddress == "" {
+				return "", false
+			}
+			host := guest.IpAddress
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			url = strings.Replace(url, ipPlaceholder, host, -1)
+		}
+		return url, probeHTTP(ctx, url)
+	case WaitGuestInfo:
+		if moVM.Config == nil {
+			return "", false
+		}
+		for _, option := range moVM.Config.ExtraConfig {
+			ov := option.GetOptionValue()
+			if ov.Key != wc.Value {
+				continue
+			}
+			value := fmt.Sprintf("%v", ov.Value)
+			return value, value != "" && (wc.Expected == "" || value == wc.Expected)
+		}
+	}
+
+	return "", false
+}
+
+// probeHTTP reports whether a GET of the URL succeeds with a 2xx status
+func probeHTTP(ctx context.Context, url string) bool {
+	ctx, cancelFn := context.WithTimeout(ctx, waitProbeTimeout)
+	defer cancelFn()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
@@ -0,0 +1,207 @@
+package vcon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+)
+
+// EndSession logs out of the session kept in the options' session file, if
+// it is still valid, and removes the file.  It is not an error if there is no
+// session.
+func EndSession(ctx context.Context, opts *ClientOptions) error {
+	c := &Client{
+		sessionFile: opts.SessionFile,
+		timeout:     time.Duration(opts.Timeout) * time.Second,
+		Verbose:     opts.Verbose,
+	}
+
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		verifier, err := newTLSVerifier(opts, opts.VSphere)
+		if err != nil {
+			return err
+		}
+
+		c.Client, err = c.resumeSession(ctx, opts.VSphere, verifier)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrapf(err, "Failed to resume session with vSphere at '%s'", opts.VSphere)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrap(err, "Timeout while attempting to end session")
+		default:
+			// unknown error
+			err = errors.Wrap(err, "Got error while attempting to end session")
+		}
+		return newOperationError("EndSession", "", err)
+	}
+
+	if c.Client == nil {
+		return c.removeSession()
+	}
+
+	return c.LogoutContext(ctx)
+}
+
+// Logout ends the vSphere session, and removes the session file, if any
+func (c *Client) Logout() error {
+	return c.LogoutContext(context.Background())
+}
+
+// LogoutContext is like Logout, but uses the provided context
+func (c *Client) LogoutContext(ctx context.Context) error {
+	if c.Verbose {
+		fmt.Printf("Logging out...\n")
+	}
+
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		err := c.Client.Logout(ctx)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrapf(err, "While logging out")
+		}
+
+		return c.removeSession()
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrap(err, "Timeout while attempting to log out")
+		default:
+			// unknown error
+			err = errors.Wrap(err, "Got error while logging out")
+		}
+		return newOperationError("Logout", "", err)
+	}
+
+	return nil
+}
+
+// resumeSession restores the client from the session file, provided that the
+// session belongs to the requested vSphere instance and is still logged in.
+// If there is no usable session, a nil client is returned.
+func (c *Client) resumeSession(ctx context.Context, vsphere string, verifier *tlsVerifier) (*govmomi.Client, error) {
+	if c.sessionFile == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(c.sessionFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "While reading session file")
+	}
+
+	vc := &vim25.Client{}
+	err = json.Unmarshal(b, vc)
+	if err != nil || !vc.Valid() || vc.URL().Host != vsphere {
+		if c.Verbose {
+			fmt.Printf("Ignoring unusable session file '%s'\n", c.sessionFile)
+		}
+		return nil, nil
+	}
+
+	// The restored SOAP client has default TLS settings; apply the current ones.
+	verifier.configure(vc.Client)
+
+	gc := &govmomi.Client{
+		Client:         vc,
+		SessionManager: session.NewManager(vc),
+	}
+
+	us, err := gc.SessionManager.UserSession(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		// The session could not be checked; it will be replaced.
+		us = nil
+	}
+
+	if us == nil {
+		if c.Verbose {
+			fmt.Printf("Session has expired\n")
+		}
+		return nil, nil
+	}
+
+	if c.Verbose {
+		fmt.Printf("Reusing session for user '%s'\n", us.UserName)
+	}
+
+	return gc, nil
+}
+
+// saveSession writes the client's session cookie to the session file, which
+// is only readable by the current user
+func (c *Client) saveSession() error {
+	if c.sessionFile == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(c.Client.Client)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.sessionFile)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// TempFile creates the file with 0600 permissions; rename it into place so
+	// that a concurrent vcon never reads a partial file.
+	f, err := ioutil.TempFile(dir, filepath.Base(c.sessionFile))
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), c.sessionFile)
+}
+
+func (c *Client) removeSession() error {
+	if c.sessionFile == "" {
+		return nil
+	}
+
+	err := os.Remove(c.sessionFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
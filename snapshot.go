@@ -3,23 +3,37 @@ package vcon
 import (
 	"context"
 	"fmt"
+	"path"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
 // Snapshot represents a node in a hierarchical list of VM snapshots
 type Snapshot struct {
-	Name     string     `json:"name"`
-	Ref      string     `json:"ref"`
-	Children []Snapshot `json:"children,omitempty"`
+	Name        string     `json:"name"`
+	Ref         string     `json:"ref"`
+	Description string     `json:"description,omitempty"`
+	CreateTime  *time.Time `json:"createTime,omitempty"`
+	PowerState  PowerState `json:"powerState,omitempty"`
+	Quiesced    bool       `json:"quiesced"`
+	IsCurrent   bool       `json:"isCurrent"`
+	Children    []Snapshot `json:"children,omitempty"`
 }
 
 // FindSnapshot will locate the Managed Object Reference for a snapshot, either
 // by looking it up by name, or converting the provided ref into a MORef.
 func (c *Client) FindSnapshot(vm *VirtualMachine, name string, byRef bool) (*types.ManagedObjectReference, error) {
+	return c.FindSnapshotContext(context.Background(), vm, name, byRef)
+}
+
+// FindSnapshotContext is like FindSnapshot, but uses the provided context
+func (c *Client) FindSnapshotContext(ctx context.Context, vm *VirtualMachine, name string, byRef bool) (*types.ManagedObjectReference, error) {
 	var moRef *types.ManagedObjectReference
 
 	if byRef {
@@ -29,28 +43,87 @@ func (c *Client) FindSnapshot(vm *VirtualMachine, name string, byRef bool) (*typ
 		}
 	} else {
 		err := func() error {
-			ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+			ctx, cancelFn := c.withTimeout(ctx)
 			defer cancelFn()
 
-			var err error
-			moRef, err = vm.VM.FindSnapshot(ctx, name)
+			o := mo.VirtualMachine{}
+			err := vm.VM.Properties(ctx, vm.VM.Reference(), []string{"snapshot"}, &o)
 			if err := c.checkErr(ctx, err); err != nil {
 				return errors.Wrapf(err, "While finding snapshot")
 			}
 
+			var matches []types.ManagedObjectReference
+			if o.Snapshot != nil {
+				matches = findSnapshotRefs(o.Snapshot.RootSnapshotList, "", name)
+			}
+
+			switch len(matches) {
+			case 0:
+				return NotFoundError{Kind: "snapshot", Path: name}
+			case 1:
+				moRef = &matches[0]
+			default:
+				return InvalidConfigurationError{
+					Message: fmt.Sprintf("Snapshot name '%s' resolves to %d snapshots", name, len(matches)),
+				}
+			}
+
 			return nil
 		}()
 
 		if err != nil {
-			switch err := errors.Cause(err).(type) {
-			case *TimeoutExceededError:
+			switch errors.Cause(err).(type) {
+			case TimeoutExceededError:
 				// handle specifically
-				return nil, fmt.Errorf("Timeout while attempting to find snapshot for a VM")
+				err = errors.Wrap(err, "Timeout while attempting to find snapshot for a VM")
 			default:
 				// unknown error
-				return nil, errors.Wrap(err, "Got error while finding snapshot for a VM")
+				err = errors.Wrap(err, "Got error while finding snapshot for a VM")
 			}
+			return nil, newOperationError("FindSnapshot", vm.Ref.Value, err)
+		}
+	}
+
+	return moRef, nil
+}
+
+// CurrentSnapshot returns the Managed Object Reference for the VM's current
+// snapshot, or nil if the VM has no snapshots
+func (c *Client) CurrentSnapshot(vm *VirtualMachine) (*types.ManagedObjectReference, error) {
+	return c.CurrentSnapshotContext(context.Background(), vm)
+}
+
+// CurrentSnapshotContext is like CurrentSnapshot, but uses the provided context
+func (c *Client) CurrentSnapshotContext(ctx context.Context, vm *VirtualMachine) (*types.ManagedObjectReference, error) {
+	var moRef *types.ManagedObjectReference
+
+	err := func() error {
+		ctx, cancelFn := c.withTimeout(ctx)
+		defer cancelFn()
+
+		o := mo.VirtualMachine{}
+		err := vm.VM.Properties(ctx, vm.VM.Reference(), []string{"snapshot"}, &o)
+		if err := c.checkErr(ctx, err); err != nil {
+			return errors.Wrapf(err, "While getting current snapshot")
+		}
+
+		if o.Snapshot != nil {
+			moRef = o.Snapshot.CurrentSnapshot
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
+			// handle specifically
+			err = errors.Wrap(err, "Timeout while attempting to get current snapshot for a VM")
+		default:
+			// unknown error
+			err = errors.Wrap(err, "Got error while getting current snapshot for a VM")
 		}
+		return nil, newOperationError("CurrentSnapshot", vm.Ref.Value, err)
 	}
 
 	return moRef, nil
@@ -62,6 +135,11 @@ func (c *Client) FindSnapshot(vm *VirtualMachine, name string, byRef bool) (*typ
 // not snapshotting the current memory state or quiescing the file system.
 // See https://docs.vmware.com/en/VMware-vSphere/6.5/com.vmware.vsphere.vm_admin.doc/GUID-53F65726-A23B-4CF0-A7D5-48E584B88613.html
 func (c *Client) SnapshotCreate(vm *VirtualMachine, name string) (*types.ManagedObjectReference, error) {
+	return c.SnapshotCreateContext(context.Background(), vm, name)
+}
+
+// SnapshotCreateContext is like SnapshotCreate, but uses the provided context
+func (c *Client) SnapshotCreateContext(ctx context.Context, vm *VirtualMachine, name string) (*types.ManagedObjectReference, error) {
 	if c.Verbose {
 		fmt.Printf("Creating a VM snapshot...\n")
 	}
@@ -69,9 +147,18 @@ func (c *Client) SnapshotCreate(vm *VirtualMachine, name string) (*types.Managed
 	var res types.ManagedObjectReference
 
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
+		request := types.CreateSnapshot_Task{This: vm.Ref, Name: name}
+		if c.dryRun("CreateSnapshot_Task", vm.Ref, request) {
+			res = types.ManagedObjectReference{
+				Type:  "VirtualMachineSnapshot",
+				Value: fmt.Sprintf("(dry run: %s)", name),
+			}
+			return nil
+		}
+
 		task, err := vm.VM.CreateSnapshot(ctx, name, "", false, false)
 		any, err := c.finishTask(ctx, task, err)
 		if err != nil {
@@ -84,49 +171,70 @@ func (c *Client) SnapshotCreate(vm *VirtualMachine, name string) (*types.Managed
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return nil, fmt.Errorf("Timeout while attempting to snapshot VM")
+			err = errors.Wrap(err, "Timeout while attempting to snapshot VM")
 		default:
 			// unknown error
-			return nil, errors.Wrap(err, "Got error while snapshotting a VM")
+			err = errors.Wrap(err, "Got error while snapshotting a VM")
 		}
+		return nil, newOperationError("SnapshotCreate", vm.Ref.Value, err)
 	}
 
 	return &res, nil
 }
 
-// SnapshotList retrieves all the snapshots for the provided VM, hierarchically
-func (c *Client) SnapshotList(vm *VirtualMachine) (*Snapshot, error) {
+// SnapshotList retrieves all the snapshots for the provided VM, hierarchically.
+// A VM may have more than one root snapshot, so a list of root nodes is
+// returned; it is empty if the VM has no snapshots.
+func (c *Client) SnapshotList(vm *VirtualMachine) ([]Snapshot, error) {
+	return c.SnapshotListContext(context.Background(), vm)
+}
+
+// SnapshotListContext is like SnapshotList, but uses the provided context
+func (c *Client) SnapshotListContext(ctx context.Context, vm *VirtualMachine) ([]Snapshot, error) {
 	if c.Verbose {
 		fmt.Printf("Getting a list of snapshots for VM...\n")
 	}
 
-	var sn *Snapshot
+	sn := []Snapshot{}
 
 	err := func() error {
-		_, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
+		if vm.MO == nil {
+			pc := property.DefaultCollector(c.Client.Client)
+			refs := []types.ManagedObjectReference{vm.VM.Reference()}
+			res := []mo.VirtualMachine{}
+			err := pc.Retrieve(ctx, refs, []string{"snapshot"}, &res)
+			if err := c.checkErr(ctx, err); err != nil {
+				return errors.Wrapf(err, "While getting snapshot properties")
+			}
+			vm.MO = &res[0]
+		}
+
 		if vm.MO.Snapshot == nil {
 			return nil
 		}
 
-		// TODO: unclear how to proceed here.
+		current := vm.MO.Snapshot.CurrentSnapshot
+		sn = buildSnapshotTree(vm.MO.Snapshot.RootSnapshotList, current)
 
 		return nil
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return nil, fmt.Errorf("Timeout while attempting to list snapshots for a VM")
+			err = errors.Wrap(err, "Timeout while attempting to list snapshots for a VM")
 		default:
 			// unknown error
-			return nil, errors.Wrap(err, "Got error while listing snapshots for a VM")
+			err = errors.Wrap(err, "Got error while listing snapshots for a VM")
 		}
+		return nil, newOperationError("SnapshotList", vm.Ref.Value, err)
 	}
 
 	return sn, nil
@@ -134,8 +242,13 @@ func (c *Client) SnapshotList(vm *VirtualMachine) (*Snapshot, error) {
 
 // SnapshotRemove removes a single snapshot from the provided VM
 func (c *Client) SnapshotRemove(vm *VirtualMachine, moRef *types.ManagedObjectReference) error {
+	return c.SnapshotRemoveContext(context.Background(), vm, moRef)
+}
+
+// SnapshotRemoveContext is like SnapshotRemove, but uses the provided context
+func (c *Client) SnapshotRemoveContext(ctx context.Context, vm *VirtualMachine, moRef *types.ManagedObjectReference) error {
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		consolidate := true
@@ -145,6 +258,10 @@ func (c *Client) SnapshotRemove(vm *VirtualMachine, moRef *types.ManagedObjectRe
 			Consolidate:    &consolidate,
 		}
 
+		if c.dryRun("RemoveSnapshot_Task", req.This, req) {
+			return nil
+		}
+
 		res, err := methods.RemoveSnapshot_Task(ctx, vm.VM.Client(), &req)
 		if err := c.checkErr(ctx, err); err != nil {
 			return errors.Wrapf(err, "While removing snapshot")
@@ -160,14 +277,15 @@ func (c *Client) SnapshotRemove(vm *VirtualMachine, moRef *types.ManagedObjectRe
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to list snapshots for a VM")
+			err = errors.Wrap(err, "Timeout while attempting to list snapshots for a VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while listing snapshots for a VM")
+			err = errors.Wrap(err, "Got error while listing snapshots for a VM")
 		}
+		return newOperationError("SnapshotRemove", vm.Ref.Value, err)
 	}
 
 	return nil
@@ -175,11 +293,21 @@ func (c *Client) SnapshotRemove(vm *VirtualMachine, moRef *types.ManagedObjectRe
 
 // SnapshotRemoveAll removes snapshots from the provided VM
 func (c *Client) SnapshotRemoveAll(vm *VirtualMachine) error {
+	return c.SnapshotRemoveAllContext(context.Background(), vm)
+}
+
+// SnapshotRemoveAllContext is like SnapshotRemoveAll, but uses the provided context
+func (c *Client) SnapshotRemoveAllContext(ctx context.Context, vm *VirtualMachine) error {
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		consolidate := true
+		request := types.RemoveAllSnapshots_Task{This: vm.Ref, Consolidate: &consolidate}
+		if c.dryRun("RemoveAllSnapshots_Task", vm.Ref, request) {
+			return nil
+		}
+
 		task, err := vm.VM.RemoveAllSnapshot(ctx, &consolidate)
 		_, err = c.finishTask(ctx, task, err)
 		if err != nil {
@@ -190,14 +318,15 @@ func (c *Client) SnapshotRemoveAll(vm *VirtualMachine) error {
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to remove all snapshots for a VM")
+			err = errors.Wrap(err, "Timeout while attempting to remove all snapshots for a VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while removing all snapshots for a VM")
+			err = errors.Wrap(err, "Got error while removing all snapshots for a VM")
 		}
+		return newOperationError("SnapshotRemoveAll", vm.Ref.Value, err)
 	}
 
 	return nil
@@ -205,10 +334,21 @@ func (c *Client) SnapshotRemoveAll(vm *VirtualMachine) error {
 
 // SnapshotRevert will revert the provided VM back the previous snapshot
 func (c *Client) SnapshotRevert(vm *VirtualMachine) error {
+	return c.SnapshotRevertContext(context.Background(), vm)
+}
+
+// SnapshotRevertContext is like SnapshotRevert, but uses the provided context
+func (c *Client) SnapshotRevertContext(ctx context.Context, vm *VirtualMachine) error {
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
+		suppress := true
+		request := types.RevertToCurrentSnapshot_Task{This: vm.Ref, SuppressPowerOn: &suppress}
+		if c.dryRun("RevertToCurrentSnapshot_Task", vm.Ref, request) {
+			return nil
+		}
+
 		task, err := vm.VM.RevertToCurrentSnapshot(ctx, true)
 		_, err = c.finishTask(ctx, task, err)
 		if err != nil {
@@ -219,14 +359,15 @@ func (c *Client) SnapshotRevert(vm *VirtualMachine) error {
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to revert to the most recent snapshot for a VM")
+			err = errors.Wrap(err, "Timeout while attempting to revert to the most recent snapshot for a VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while reverting to the most recent snapshot for a VM")
+			err = errors.Wrap(err, "Got error while reverting to the most recent snapshot for a VM")
 		}
+		return newOperationError("SnapshotRevert", vm.Ref.Value, err)
 	}
 
 	return nil
@@ -234,8 +375,13 @@ func (c *Client) SnapshotRevert(vm *VirtualMachine) error {
 
 // SnapshotRevertTo will revert the provided VM to the specified snapshot
 func (c *Client) SnapshotRevertTo(vm *VirtualMachine, moRef *types.ManagedObjectReference) error {
+	return c.SnapshotRevertToContext(context.Background(), vm, moRef)
+}
+
+// SnapshotRevertToContext is like SnapshotRevertTo, but uses the provided context
+func (c *Client) SnapshotRevertToContext(ctx context.Context, vm *VirtualMachine, moRef *types.ManagedObjectReference) error {
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		suppress := true
@@ -244,6 +390,10 @@ func (c *Client) SnapshotRevertTo(vm *VirtualMachine, moRef *types.ManagedObject
 			SuppressPowerOn: &suppress,
 		}
 
+		if c.dryRun("RevertToSnapshot_Task", req.This, req) {
+			return nil
+		}
+
 		res, err := methods.RevertToSnapshot_Task(ctx, c.Client.Client, &req)
 		err = c.checkErr(ctx, err)
 		if err != nil {
@@ -260,15 +410,61 @@ func (c *Client) SnapshotRevertTo(vm *VirtualMachine, moRef *types.ManagedObject
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return fmt.Errorf("Timeout while attempting to revert to a specific snapshot for a VM")
+			err = errors.Wrap(err, "Timeout while attempting to revert to a specific snapshot for a VM")
 		default:
 			// unknown error
-			return errors.Wrap(err, "Got error while reverting to a specific snapshot for a VM")
+			err = errors.Wrap(err, "Got error while reverting to a specific snapshot for a VM")
 		}
+		return newOperationError("SnapshotRevertTo", vm.Ref.Value, err)
 	}
 
 	return nil
 }
+
+// buildSnapshotTree converts vSphere's snapshot tree into a tree of Snapshot
+// nodes, marking the node which matches the VM's current snapshot
+func buildSnapshotTree(trees []types.VirtualMachineSnapshotTree, current *types.ManagedObjectReference) []Snapshot {
+	nodes := make([]Snapshot, 0, len(trees))
+	for _, tree := range trees {
+		createTime := tree.CreateTime
+		node := Snapshot{
+			Name:        tree.Name,
+			Ref:         tree.Snapshot.Value,
+			Description: tree.Description,
+			CreateTime:  &createTime,
+			PowerState:  toPowerState(tree.State),
+			Quiesced:    tree.Quiesced,
+			IsCurrent:   current != nil && current.Value == tree.Snapshot.Value,
+		}
+		if len(tree.ChildSnapshotList) != 0 {
+			node.Children = buildSnapshotTree(tree.ChildSnapshotList, current)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// findSnapshotRefs returns the snapshots which match the provided name.  As
+// with govmomi, the name may be the snapshot's name, its ref, or its path in
+// the snapshot tree (i.e., "base/patched").
+func findSnapshotRefs(trees []types.VirtualMachineSnapshotTree, parent, name string) []types.ManagedObjectReference {
+	matches := []types.ManagedObjectReference{}
+	for _, tree := range trees {
+		snapshotPath := tree.Name
+		if parent != "" {
+			snapshotPath = path.Join(parent, tree.Name)
+		}
+
+		if tree.Name == name || tree.Snapshot.Value == name || snapshotPath == name {
+			matches = append(matches, tree.Snapshot)
+		}
+
+		matches = append(matches, findSnapshotRefs(tree.ChildSnapshotList, snapshotPath, name)...)
+	}
+
+	return matches
+}
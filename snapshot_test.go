@@ -0,0 +1,148 @@
+package vcon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// snapshotRef is the ref of a snapshot in the test trees
+func snapshotRef(value string) types.ManagedObjectReference {
+	return types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: value}
+}
+
+// testSnapshotTrees is "base", with the children "patched" and "other", which
+// has a child also named "patched"; and a second root, "other"
+func testSnapshotTrees(created time.Time) []types.VirtualMachineSnapshotTree {
+	return []types.VirtualMachineSnapshotTree{
+		{
+			Snapshot:    snapshotRef("snapshot-1"),
+			Name:        "base",
+			Description: "the base",
+			CreateTime:  created,
+			State:       types.VirtualMachinePowerStatePoweredOff,
+			ChildSnapshotList: []types.VirtualMachineSnapshotTree{
+				{
+					Snapshot:   snapshotRef("snapshot-2"),
+					Name:       "patched",
+					CreateTime: created,
+					State:      types.VirtualMachinePowerStatePoweredOn,
+					Quiesced:   true,
+				},
+				{
+					Snapshot:   snapshotRef("snapshot-3"),
+					Name:       "other",
+					CreateTime: created,
+					State:      types.VirtualMachinePowerStateSuspended,
+					ChildSnapshotList: []types.VirtualMachineSnapshotTree{
+						{
+							Snapshot:   snapshotRef("snapshot-4"),
+							Name:       "patched",
+							CreateTime: created,
+						},
+					},
+				},
+			},
+		},
+		{
+			Snapshot:   snapshotRef("snapshot-5"),
+			Name:       "other",
+			CreateTime: created,
+		},
+	}
+}
+
+func TestFindSnapshotRefs(t *testing.T) {
+	trees := testSnapshotTrees(time.Now())
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{name: "base", expected: []string{"snapshot-1"}},
+		{name: "patched", expected: []string{"snapshot-2", "snapshot-4"}},
+		{name: "other", expected: []string{"snapshot-3", "snapshot-5"}},
+		{name: "base/patched", expected: []string{"snapshot-2"}},
+		{name: "base/other/patched", expected: []string{"snapshot-4"}},
+		{name: "snapshot-4", expected: []string{"snapshot-4"}},
+		{name: "other/patched", expected: []string{}},
+		{name: "/base", expected: []string{}},
+		{name: "missing", expected: []string{}},
+		{name: "", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := []string{}
+			for _, ref := range findSnapshotRefs(trees, "", tt.name) {
+				actual = append(actual, ref.Value)
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildSnapshotTree(t *testing.T) {
+	created := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	trees := testSnapshotTrees(created)
+	current := snapshotRef("snapshot-4")
+
+	tests := []struct {
+		name     string
+		trees    []types.VirtualMachineSnapshotTree
+		current  *types.ManagedObjectReference
+		expected []Snapshot
+	}{
+		{
+			name:     "no snapshots",
+			expected: []Snapshot{},
+		},
+		{
+			name:    "tree",
+			trees:   trees,
+			current: &current,
+			expected: []Snapshot{
+				{
+					Name:        "base",
+					Ref:         "snapshot-1",
+					Description: "the base",
+					CreateTime:  &created,
+					PowerState:  PoweredOff,
+					Children: []Snapshot{
+						{Name: "patched", Ref: "snapshot-2", CreateTime: &created, PowerState: PoweredOn, Quiesced: true},
+						{
+							Name:       "other",
+							Ref:        "snapshot-3",
+							CreateTime: &created,
+							PowerState: Suspended,
+							Children: []Snapshot{
+								{Name: "patched", Ref: "snapshot-4", CreateTime: &created, PowerState: Unknown, IsCurrent: true},
+							},
+						},
+					},
+				},
+				{Name: "other", Ref: "snapshot-5", CreateTime: &created, PowerState: Unknown},
+			},
+		},
+		{
+			name:  "no current snapshot",
+			trees: trees[1:],
+			expected: []Snapshot{
+				{Name: "other", Ref: "snapshot-5", CreateTime: &created, PowerState: Unknown},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := buildSnapshotTree(tt.trees, tt.current)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, actual)
+			}
+		})
+	}
+}
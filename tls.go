@@ -0,0 +1,233 @@
+package vcon
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// tlsVerifier checks the certificate presented by vSphere.  A certificate is
+// accepted if it is signed by a trusted certificate authority, if it matches
+// the expected thumbprint, or if it matches the thumbprint recorded for the
+// host in the known hosts file.
+type tlsVerifier struct {
+	host            string
+	roots           *x509.CertPool
+	thumbprint      string
+	knownHostsFile  string
+	trustOnFirstUse bool
+	insecure        bool
+
+	// failure keeps the reason the certificate was rejected, since the TLS and
+	// HTTP layers only report it as a string
+	failure error
+}
+
+func newTLSVerifier(opts *ClientOptions, host string) (*tlsVerifier, error) {
+	v := &tlsVerifier{
+		host:            hostAddr(host),
+		thumbprint:      opts.Thumbprint,
+		knownHostsFile:  opts.KnownHostsFile,
+		trustOnFirstUse: opts.TrustOnFirstUse,
+		insecure:        opts.Insecure,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, InvalidConfigurationError{Message: fmt.Sprintf("Failed to read CA file '%s': %s", opts.CAFile, err.Error())}
+		}
+
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(pem) {
+			return nil, InvalidConfigurationError{Message: fmt.Sprintf("No certificates found in CA file '%s'", opts.CAFile)}
+		}
+	}
+
+	return v, nil
+}
+
+// configure replaces the TLS configuration of the SOAP client, so that the
+// server's certificate is checked by the verifier
+func (v *tlsVerifier) configure(sc *soap.Client) {
+	t, ok := sc.Client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+
+	// Verification is done in VerifyPeerCertificate, so that a certificate
+	// which fails the usual checks can still be matched against a thumbprint.
+	t.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if !v.insecure {
+		t.TLSClientConfig.VerifyPeerCertificate = v.verify
+	}
+	t.DialTLS = nil
+}
+
+func (v *tlsVerifier) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return v.fail("", fmt.Sprintf("Failed to parse certificate: %s", err.Error()))
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return v.fail("", "No certificate was presented")
+	}
+
+	leaf := certs[0]
+	thumbprint := thumbprintSHA256(leaf)
+
+	if v.thumbprint != "" {
+		if matchThumbprint(leaf, v.thumbprint) {
+			return nil
+		}
+		return v.fail(thumbprint, "Certificate does not match the expected thumbprint")
+	}
+
+	hostname, _, err := net.SplitHostPort(v.host)
+	if err != nil {
+		hostname = v.host
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verr := leaf.Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: intermediates,
+		Roots:         v.roots,
+	})
+	if verr == nil {
+		return nil
+	}
+
+	known, err := readKnownHost(v.knownHostsFile, v.host)
+	if err != nil {
+		return v.fail(thumbprint, fmt.Sprintf("Failed to read known hosts: %s", err.Error()))
+	}
+	if known != "" {
+		if matchThumbprint(leaf, known) {
+			return nil
+		}
+		return v.fail(thumbprint, "Certificate has changed since it was first trusted")
+	}
+
+	if v.trustOnFirstUse && v.knownHostsFile != "" {
+		err = appendKnownHost(v.knownHostsFile, v.host, thumbprint)
+		if err != nil {
+			return v.fail(thumbprint, fmt.Sprintf("Failed to record thumbprint: %s", err.Error()))
+		}
+		fmt.Fprintf(os.Stderr, "Trusting certificate for '%s' with thumbprint %s\n", v.host, thumbprint)
+		return nil
+	}
+
+	return v.fail(thumbprint, verr.Error())
+}
+
+func (v *tlsVerifier) fail(thumbprint, reason string) error {
+	v.failure = CertificateError{
+		Host:       v.host,
+		Thumbprint: thumbprint,
+		Reason:     reason,
+	}
+	return v.failure
+}
+
+// matchThumbprint compares a certificate to a SHA-1 or SHA-256 thumbprint.
+// The thumbprint's case and colon separators are ignored.
+func matchThumbprint(cert *x509.Certificate, thumbprint string) bool {
+	normalize := func(s string) string {
+		return strings.ToUpper(strings.Replace(s, ":", "", -1))
+	}
+
+	expected := normalize(thumbprint)
+	switch len(expected) {
+	case sha1.Size * 2:
+		return expected == normalize(soap.ThumbprintSHA1(cert))
+	case sha256.Size * 2:
+		return expected == normalize(thumbprintSHA256(cert))
+	}
+
+	return false
+}
+
+// thumbprintSHA256 returns the SHA-256 thumbprint of a certificate, in the
+// same colon-separated form that vSphere uses for SHA-1 thumbprints
+func thumbprintSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// readKnownHost finds the thumbprint recorded for the host.  Each line of the
+// known hosts file is a host address and a thumbprint, separated by a space.
+func readKnownHost(file, host string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(e) == 2 && e[0] == host {
+			return e[1], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+func appendKnownHost(file, host, thumbprint string) error {
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "%s %s\n", host, thumbprint)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// hostAddr adds the default HTTPS port to an address which does not have one
+func hostAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, "443")
+	}
+	return addr
+}
@@ -0,0 +1,49 @@
+package vcon
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMatchThumbprint(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("certificate")}
+	sha1Hex := fmt.Sprintf("%X", sha1.Sum(cert.Raw))
+	sha256Hex := fmt.Sprintf("%X", sha256.Sum256(cert.Raw))
+
+	// colons inserts a colon between each pair of hex digits
+	colons := func(s string) string {
+		pairs := []string{}
+		for i := 0; i < len(s); i += 2 {
+			pairs = append(pairs, s[i:i+2])
+		}
+		return strings.Join(pairs, ":")
+	}
+
+	tests := []struct {
+		name       string
+		thumbprint string
+		expected   bool
+	}{
+		{name: "SHA-1", thumbprint: colons(sha1Hex), expected: true},
+		{name: "SHA-1 without colons", thumbprint: sha1Hex, expected: true},
+		{name: "SHA-1 in lowercase", thumbprint: strings.ToLower(colons(sha1Hex)), expected: true},
+		{name: "SHA-256", thumbprint: colons(sha256Hex), expected: true},
+		{name: "SHA-256 without colons", thumbprint: strings.ToLower(sha256Hex), expected: true},
+		{name: "another SHA-1", thumbprint: strings.Repeat("00", sha1.Size), expected: false},
+		{name: "another SHA-256", thumbprint: strings.Repeat("00", sha256.Size), expected: false},
+		{name: "truncated", thumbprint: sha1Hex[2:], expected: false},
+		{name: "empty", thumbprint: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := matchThumbprint(cert, tt.thumbprint); actual != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package vcon
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi/object"
@@ -20,18 +21,82 @@ type VirtualMachine struct {
 
 // VirtualMachineConfiguration describes the virtual hardware assigned to a VM
 type VirtualMachineConfiguration struct {
-	CPUs    *int    `json:"cpus,omitempty"`
-	Memory  *int    `json:"memory,omitempty"`
+	CPUs   *int `json:"cpus,omitempty"`
+	Memory *int `json:"memory,omitempty"`
+
+	// Network attaches the VM's first network adapter to the network
 	Network *string `json:"network,omitempty"`
+
+	// NetworkAdapters are changed, added, or removed, in order.  Unlike
+	// Network, each adapter may be attached to its own network.
+	NetworkAdapters []NetworkAdapter `json:"networkAdapters,omitempty"`
+
+	// Disks are grown, added, or removed, in order
+	Disks []Disk `json:"disks,omitempty"`
+
+	// ExtraConfig sets advanced configuration options, such as guestinfo keys
+	ExtraConfig map[string]string `json:"extraConfig,omitempty"`
+
+	// Customization prepares the guest OS when the VM is next powered on
+	Customization *Customization `json:"customization,omitempty"`
 }
 
 // VirtualMachineInfo describes interesting information about a VM
 type VirtualMachineInfo struct {
+	Annotation    string                       `json:"annotation,omitempty"`
+	BIOSUUID      string                       `json:"biosUuid,omitempty"`
+	BootTime      *time.Time                   `json:"bootTime,omitempty"`
+	Cluster       string                       `json:"cluster,omitempty"`
 	Configuration *VirtualMachineConfiguration `json:"configuration"`
-	IPs           []string                     `json:"ips"`
-	IsRunning     bool                         `json:"isRunning"`
-	Path          string                       `json:"path"`
-	Ref           string                       `json:"ref"`
+	Created       *time.Time                   `json:"created,omitempty"`
+	Datastores    []DatastoreUsage             `json:"datastores,omitempty"`
+
+	// GuestOS is reported by VMware Tools when it is running, and otherwise
+	// is the OS the VM is configured for
+	GuestOS         string `json:"guestOS,omitempty"`
+	HardwareVersion string `json:"hardwareVersion,omitempty"`
+	Host            string `json:"host,omitempty"`
+	Hostname        string `json:"hostname,omitempty"`
+	InstanceUUID    string `json:"instanceUuid,omitempty"`
+
+	IPs []string `json:"ips"`
+
+	// IsRunning is set unless the VM is powered off; PowerState tells
+	// whether it is on or suspended
+	IsRunning    bool          `json:"isRunning"`
+	LeaseExpires *time.Time    `json:"leaseExpires,omitempty"`
+	Path         string        `json:"path"`
+	PowerState   PowerState    `json:"powerState,omitempty"`
+	Ref          string        `json:"ref"`
+	ResourcePool string        `json:"resourcePool,omitempty"`
+	Storage      *StorageUsage `json:"storage,omitempty"`
+	Tools        *ToolsInfo    `json:"tools,omitempty"`
+
+	// UptimeSeconds is how long the VM has been powered on
+	UptimeSeconds int64 `json:"uptimeSeconds,omitempty"`
+}
+
+// StorageUsage is the space used by a VM's files, in bytes.  Committed space
+// is in use, uncommitted space may yet be used by thin disks, and unshared
+// space is used only by this VM, rather than by linked clones too.
+type StorageUsage struct {
+	Committed   int64 `json:"committed"`
+	Uncommitted int64 `json:"uncommitted"`
+	Unshared    int64 `json:"unshared"`
+}
+
+// DatastoreUsage is the space used by a VM on one datastore
+type DatastoreUsage struct {
+	Name string `json:"name"`
+	StorageUsage
+}
+
+// ToolsInfo describes VMware Tools in a VM's guest, with the status values
+// reported by vSphere, such as "guestToolsRunning" and "guestToolsCurrent"
+type ToolsInfo struct {
+	RunningStatus string `json:"runningStatus,omitempty"`
+	Version       string `json:"version,omitempty"`
+	VersionStatus string `json:"versionStatus,omitempty"`
 }
 
 // FindVM will fetch the Virtual Machine struct for use with this API.  The VM
@@ -40,6 +105,11 @@ type VirtualMachineInfo struct {
 // populated with the additional data as requested in the `properties`
 // argument.
 func (c *Client) FindVM(path string, pathIsRef bool, properties ...string) (*VirtualMachine, error) {
+	return c.FindVMContext(context.Background(), path, pathIsRef, properties...)
+}
+
+// FindVMContext is like FindVM, but uses the provided context
+func (c *Client) FindVMContext(ctx context.Context, path string, pathIsRef bool, properties ...string) (*VirtualMachine, error) {
 	// Example complete inventory path
 	// /Static/vm/Rally/Engineering/AC2GO/Templates/AC2Go RIO Studio Template (Built 2018-04-24)
 
@@ -51,7 +121,7 @@ func (c *Client) FindVM(path string, pathIsRef bool, properties ...string) (*Vir
 	var ref types.ManagedObjectReference
 	var vm *object.VirtualMachine
 	err := func() error {
-		ctx, cancelFn := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancelFn := c.withTimeout(ctx)
 		defer cancelFn()
 
 		if pathIsRef {
@@ -67,11 +137,11 @@ func (c *Client) FindVM(path string, pathIsRef bool, properties ...string) (*Vir
 				return errors.Wrap(err, "Failed to find VM by inventory path")
 			}
 			if inventoryRef == nil {
-				return fmt.Errorf("Failed to find VM '%s'", path)
+				return NotFoundError{Kind: "VM", Path: path}
 			}
 			ref = inventoryRef.Reference()
 		}
-		vm = object.NewVirtualMachine(c.Client.Client, ref)
+		vm = object.NewVirtualMachine(c.Client.Client, c.resolveDryRunRef(ref))
 
 		if len(properties) != 0 {
 			pc := property.DefaultCollector(c.Client.Client)
@@ -89,14 +159,15 @@ func (c *Client) FindVM(path string, pathIsRef bool, properties ...string) (*Vir
 	}()
 
 	if err != nil {
-		switch err := errors.Cause(err).(type) {
-		case *TimeoutExceededError:
+		switch errors.Cause(err).(type) {
+		case TimeoutExceededError:
 			// handle specifically
-			return nil, fmt.Errorf("Timeout while finding VM '%s'", path)
+			err = errors.Wrapf(err, "Timeout while finding VM '%s'", path)
 		default:
 			// unknown error
-			return nil, errors.Wrapf(err, "Got error while finding VM '%s'", path)
+			err = errors.Wrapf(err, "Got error while finding VM '%s'", path)
 		}
+		return nil, newOperationError("FindVM", ref.Value, err)
 	}
 
 	result := &VirtualMachine{
@@ -0,0 +1,54 @@
+package vcon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWaitCondition(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected WaitCondition
+		err      bool
+	}{
+		{spec: "tools-running", expected: WaitCondition{Kind: WaitToolsRunning}},
+		{spec: "ip", expected: WaitCondition{Kind: WaitIP}},
+		{spec: "ip@30s", expected: WaitCondition{Kind: WaitIP, Timeout: 30 * time.Second}},
+		{spec: "ip-on-network=VM Network", expected: WaitCondition{Kind: WaitIPOnNetwork, Value: "VM Network"}},
+		{spec: "tcp:22", expected: WaitCondition{Kind: WaitTCP, Value: "22"}},
+		{spec: "tcp:22@2m", expected: WaitCondition{Kind: WaitTCP, Value: "22", Timeout: 2 * time.Minute}},
+		{spec: "http://{{ip}}:8080/health", expected: WaitCondition{Kind: WaitHTTP, Value: "http://{{ip}}:8080/health"}},
+		{spec: "https://{{ip}}/health@1m", expected: WaitCondition{Kind: WaitHTTP, Value: "https://{{ip}}/health", Timeout: time.Minute}},
+		{spec: "http://user@{{ip}}/", expected: WaitCondition{Kind: WaitHTTP, Value: "http://user@{{ip}}/"}},
+		{spec: "guestinfo.ready", expected: WaitCondition{Kind: WaitGuestInfo, Value: "guestinfo.ready"}},
+		{spec: "guestinfo.ready=yes", expected: WaitCondition{Kind: WaitGuestInfo, Value: "guestinfo.ready", Expected: "yes"}},
+		{spec: "guestinfo.ready=a=b@5m", expected: WaitCondition{Kind: WaitGuestInfo, Value: "guestinfo.ready", Expected: "a=b", Timeout: 5 * time.Minute}},
+		{spec: "tcp:0", err: true},
+		{spec: "tcp:65536", err: true},
+		{spec: "tcp:ssh", err: true},
+		{spec: "ip-on-network=", err: true},
+		{spec: "guestinfo=yes", err: true},
+		{spec: "ready", err: true},
+		{spec: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			wc, err := ParseWaitCondition(tt.spec)
+			if tt.err {
+				if _, ok := err.(InvalidConfigurationError); !ok {
+					t.Fatalf("expected an InvalidConfigurationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.expected.Spec = tt.spec
+			if !reflect.DeepEqual(*wc, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, *wc)
+			}
+		})
+	}
+}